@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// Announcements are written to the file given in 'announce' option so that
+// screen readers and braille displays can follow the navigation. The file is
+// kept open since readers of fifos get end of file when it is closed. It is
+// written without blocking so that a slow or missing reader does not block
+// the ui and lines are dropped instead.
+var (
+	gAnnounceFd   = -1
+	gAnnouncePath string
+)
+
+// This function writes the given line to the file given in 'announce' option
+// or to the standard output when it is '-'.
+func announce(line string) {
+	if gOpts.announce != gAnnouncePath {
+		closeAnnounce()
+	}
+
+	if gOpts.announce == "" {
+		return
+	}
+
+	if gAnnounceFd < 0 {
+		if gOpts.announce == "-" {
+			gAnnounceFd = syscall.Stdout
+		} else {
+			p := strings.Replace(gOpts.announce, "~", envHome, 1)
+			fd, err := syscall.Open(p, syscall.O_WRONLY|syscall.O_APPEND|syscall.O_CREAT|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0600)
+			if err != nil {
+				// fifos can not be opened until there is a reader
+				if err != syscall.ENXIO {
+					log.Printf("opening announce file: %s", err)
+				}
+				return
+			}
+			gAnnounceFd = fd
+		}
+		gAnnouncePath = gOpts.announce
+	}
+
+	if _, err := syscall.Write(gAnnounceFd, []byte(line+"\n")); err != nil {
+		if err == syscall.EAGAIN {
+			return
+		}
+		// fifo is opened again when there is a new reader
+		log.Printf("writing announce file: %s", err)
+		closeAnnounce()
+	}
+}
+
+func closeAnnounce() {
+	if gAnnounceFd > syscall.Stderr {
+		syscall.Close(gAnnounceFd)
+	}
+	gAnnounceFd = -1
+	gAnnouncePath = ""
+}
+
+// This function announces the current directory, the current file with its
+// position and the message line when they are changed.
+func (ui *UI) announce(nav *Nav) {
+	if gOpts.announce == "" {
+		return
+	}
+
+	dir := nav.currDir()
+	if dir.loading {
+		return
+	}
+
+	if dir.path != ui.annDir {
+		announce(dir.path)
+		ui.annDir = dir.path
+		ui.annFile = ""
+	}
+
+	file := "empty"
+	if len(dir.fi) != 0 {
+		f := dir.fi[dir.ind]
+		name := f.Name()
+		if f.IsDir() {
+			name += "/"
+		}
+		if nav.marks[path.Join(dir.path, f.Name())] {
+			name = "marked " + name
+		}
+		file = fmt.Sprintf("%s %d/%d", name, dir.ind+1, len(dir.fi))
+	}
+
+	if file != ui.annFile {
+		announce(file)
+		ui.annFile = file
+	}
+
+	if ui.message != ui.annMsg {
+		if ui.message != "" {
+			announce(ui.message)
+		}
+		ui.annMsg = ui.message
+	}
+}
@@ -1,18 +1,36 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 type App struct {
-	ui  *UI
-	nav *Nav
+	ui       *UI
+	nav      *Nav // navigation of the active tab
+	tabs     *Tabs
+	exprChan chan Expr
+	dir      string // working directory of shell commands if not current
+	quiet    bool   // options are not broadcast (e.g. in config and remote commands)
+	shared   string // marks last shared with other clients sorted one per line
+	checked  time.Time
 }
 
+// Removal of the current directory is checked at most once in this interval
+// in the main loop since directories are checked with a stat call each.
+const gRemovedInterval = time.Second
+
 func waitKey() error {
 	// TODO: this should be done with termbox somehow
 
@@ -29,37 +47,385 @@ func waitKey() error {
 	return nil
 }
 
+// This function returns the directory to change to on exit for the given
+// directory. Shells can not change to directories inside archives so the
+// directory of the archive is used instead.
+func lastDir(p string) string {
+	if arch, _, ok := splitArchive(p); ok {
+		return path.Dir(arch)
+	}
+	return p
+}
+
+// This function writes the given files to the file given with
+// 'selection-path' flag, one per line in sorted order, to use lf as a file
+// picker. Files are printed to stdout after the ui is closed when the path is
+// '-'. Files inside archives are refused since they are only extracted until
+// lf exits.
+func writeSelection(list []string) error {
+	for _, f := range list {
+		if _, _, ok := splitArchive(f); ok {
+			return fmt.Errorf("files inside archives can not be selected: %s", f)
+		}
+	}
+
+	list = append([]string(nil), list...)
+	sort.Strings(list)
+
+	s := strings.Join(list, "\n") + "\n"
+
+	if gSelectionPath == "-" {
+		gSelection = s
+		return nil
+	}
+
+	if err := ioutil.WriteFile(gSelectionPath, []byte(s), 0644); err != nil {
+		return fmt.Errorf("writing selection file: %s", err)
+	}
+
+	return nil
+}
+
 func (app *App) handleInp() {
 	for {
 		if gExitFlag {
 			log.Print("bye!")
 
-			if gLastDirPath != "" {
-				f, err := os.Create(gLastDirPath)
-				if err != nil {
-					log.Printf("opening last dir file: %s", err)
-				}
-				defer f.Close()
+			gLastDir = lastDir(app.nav.currDir().path)
 
-				dir := app.nav.currDir()
-
-				_, err = f.WriteString(dir.path)
-				if err != nil {
+			if gLastDirPath != "" {
+				if err := ioutil.WriteFile(gLastDirPath, []byte(gLastDir), 0600); err != nil {
 					log.Printf("writing last dir file: %s", err)
 				}
 			}
 
 			return
 		}
+
+		// current directory may be removed by other programs
+		if time.Since(app.checked) >= gRemovedInterval && app.checkRemoved() {
+			app.ui.draw(app.nav)
+		}
+
+		select {
+		case e := <-app.exprChan:
+			e.eval(app, nil)
+			app.shareMarks()
+			app.ui.draw(app.nav)
+			continue
+		case <-gPreviewChan:
+			app.ui.draw(app.nav)
+			continue
+		case dir := <-gDirChan:
+			app.dirLoaded(dir)
+			app.ui.draw(app.nav)
+			continue
+		case p := <-gDirSizeChan:
+			app.nav.usageLoaded(p)
+			app.ui.draw(app.nav)
+			continue
+		case job := <-gJobDone:
+			app.jobDone(job)
+			app.ui.draw(app.nav)
+			continue
+		default:
+		}
+
 		e := app.ui.getExpr()
 		if e == nil {
+			// interrupts without commands are sent to update job progress
+			// or to clear informational messages and visual bell
+			app.ui.expireFlash()
+			if app.ui.expireMsg() {
+				app.ui.draw(app.nav)
+				continue
+			}
+			// progress updates are not drawn for screen readers since
+			// they would be read out every second
+			if !gOpts.screenreader {
+				app.ui.drawIndicators(app.nav)
+				screenFlush()
+			}
 			continue
 		}
 		e.eval(app, nil)
+		app.shareMarks()
 		app.ui.draw(app.nav)
 	}
 }
 
+// This function returns the marks of the given tab sorted one per line to
+// compare them with the marks last shared.
+func markList(nav *Nav) (marks []string, s string) {
+	marks = nav.currMarks()
+	sort.Strings(marks)
+	return marks, strings.Join(marks, "\n")
+}
+
+// This function sends the marks of the active tab to the server when they are
+// changed and 'sharemarks' is set so that the same files are marked in other
+// clients. Server asks all clients to load the marks again with 'sync'
+// afterwards. Changes made while the option is not set are not shared later.
+func (app *App) shareMarks() {
+	marks, s := markList(app.nav)
+	if s == app.shared {
+		return
+	}
+
+	if gOpts.sharemarks {
+		if err := saveMarkList(marks); err != nil {
+			log.Printf("sharing marks: %s", err)
+		}
+	}
+
+	app.shared = s
+}
+
+// This function replaces the marks of the active tab with the marks shared by
+// other clients when 'sharemarks' is set.
+func (app *App) syncMarks() error {
+	if !gOpts.sharemarks {
+		return nil
+	}
+
+	marks, err := loadMarkList()
+	if err != nil {
+		return err
+	}
+
+	app.nav.marks = make(map[string]bool)
+	for _, m := range marks {
+		app.nav.marks[m] = true
+	}
+
+	sort.Strings(marks)
+	app.shared = strings.Join(marks, "\n")
+
+	return nil
+}
+
+// This function moves out of the current directory when it is removed by
+// other programs and shows a message. It is also called on reloads and
+// finished jobs to notice the removal right away. It returns true when the
+// current directory is changed.
+func (app *App) checkRemoved() bool {
+	app.checked = time.Now()
+
+	msg := app.nav.checkRemoved()
+	if msg == "" {
+		return false
+	}
+
+	app.ui.message = msg
+	log.Print(msg)
+
+	return true
+}
+
+// This function updates the ui when a job is finished. The cursor is moved to
+// the new files when the destination is still the current directory.
+func (app *App) jobDone(job *Job) {
+	app.nav.renew(app.nav.height)
+	app.checkRemoved()
+
+	if job.state == "canceled" {
+		app.ui.echo(fmt.Sprintf("job %d: canceled", job.id))
+		return
+	}
+
+	if job.err != nil {
+		msg := fmt.Sprintf("job %d: %s", job.id, job.err)
+		app.ui.message = msg
+		log.Print(msg)
+		app.ui.bell()
+		return
+	}
+
+	if job.names != nil && app.nav.currDir().path == job.dst {
+		app.nav.follow(job.names)
+	}
+}
+
+// This function evaluates the commands bound to the given keys as if they are
+// typed. When 'remap' is false, keys bound to other key sequences are looked
+// up in the default bindings instead so that keys can be swapped (e.g.
+// 'noremap j k' and 'noremap k j'). Otherwise key sequences are expanded up to
+// a fixed depth to avoid loops.
+func (app *App) feedKeys(keys string, remap bool, depth int) error {
+	if depth >= gMaxRemapDepth {
+		return fmt.Errorf("recursive mapping: %s", keys)
+	}
+
+	binds := gOpts.keys
+	if !remap {
+		binds = make(map[string]Expr)
+		for key, expr := range gOpts.keys {
+			if _, ok := expr.(*KeysExpr); !ok {
+				binds[key] = expr
+			} else if def, ok := gDefaultKeys[key]; ok {
+				binds[key] = def
+			}
+		}
+	}
+
+	var acc string
+	var count int
+	for _, key := range splitKeys(keys) {
+		// digits before keys are read as a count as in typed keys
+		if r := []rune(key); acc == "" && len(r) == 1 && isCountDigit(r[0], count) {
+			if matches, _ := findBinds(binds, key); len(matches) == 0 {
+				count = min(count*10+int(r[0]-'0'), gMaxCount)
+				continue
+			}
+		}
+
+		acc += key
+
+		matches, ok := findBinds(binds, acc)
+		if len(matches) == 0 {
+			return fmt.Errorf("unknown mapping: %s", acc)
+		}
+		if !ok {
+			continue
+		}
+
+		expr := binds[acc]
+		acc = ""
+
+		if e, ok := expr.(*KeysExpr); ok {
+			for i := 0; i < max(count, 1); i++ {
+				if err := app.feedKeys(e.keys, e.remap, depth+1); err != nil {
+					return err
+				}
+			}
+			count = 0
+			continue
+		}
+
+		countExpr(expr, count).eval(app, nil)
+		count = 0
+	}
+
+	if acc != "" || count != 0 {
+		return fmt.Errorf("incomplete mapping: %s", keys)
+	}
+
+	return nil
+}
+
+// This function runs the commands in the given file or stdin for '-' before
+// the ui starts reading keys. Each command waits for the shown directories to
+// be loaded so that scripts can move around as if they are typed. Stdin is
+// replaced with the terminal afterwards for shell commands.
+func (app *App) runCommands(name string) {
+	r := os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			msg := fmt.Sprintf("opening commands file: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		defer f.Close()
+		r = f
+	}
+
+	log.Printf("reading commands: %s", name)
+
+	app.quiet = true
+	defer func() { app.quiet = false }()
+
+	p := newParser(r)
+	for {
+		app.waitDirs()
+		if !p.parse() {
+			break
+		}
+		p.expr.eval(app, nil)
+	}
+
+	if p.err != nil {
+		msg := fmt.Sprintf("reading commands: %s", p.err)
+		app.ui.message = msg
+		log.Print(msg)
+	}
+
+	if name == "-" {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			log.Printf("opening terminal: %s", err)
+			return
+		}
+		os.Stdin = tty
+	}
+}
+
+// This function waits until the shown directories are loaded in the
+// background.
+func (app *App) waitDirs() {
+	for {
+		loading := false
+		for _, dir := range app.nav.dirs {
+			loading = loading || dir.loading
+		}
+		if !loading {
+			return
+		}
+		app.dirLoaded(<-gDirChan)
+	}
+}
+
+// This function passes the directory read in the background to all tabs so
+// that directories shown in inactive tabs are loaded as well.
+func (app *App) dirLoaded(d *Dir) {
+	for _, nav := range app.tabs.navs {
+		nav.dirLoaded(d)
+	}
+}
+
+// This function makes the active tab current. Working directory is changed to
+// the directory of the tab and its directories are read again since they may
+// be changed while the tab is inactive. Marks of the tab are not shared since
+// they are not changed.
+func (app *App) switchTab() {
+	app.nav = app.tabs.curr()
+	_, app.shared = markList(app.nav)
+
+	if err := chdir(app.nav.currDir().path); err != nil {
+		msg := fmt.Sprintf("switching tab: %s", err)
+		app.ui.message = msg
+		log.Print(msg)
+	}
+
+	app.nav.renew(app.ui.wins[0].h)
+}
+
+// This function returns the values of placeholders in shell commands. Current
+// file ('%f'), current directory ('%d') and selected files ('%s') are quoted
+// for shell so that commands work with any file names.
+func (app *App) placeholders() map[byte]string {
+	dir := app.nav.currDir()
+
+	var file string
+	if len(dir.fi) != 0 {
+		file = shellQuote(app.nav.currPath())
+	}
+
+	var sel []string
+	if len(dir.fi) != 0 {
+		for _, f := range app.nav.currSelection() {
+			sel = append(sel, shellQuote(f))
+		}
+	}
+
+	return map[byte]string{
+		'f': file,
+		'd': shellQuote(dir.path),
+		's': strings.Join(sel, " "),
+	}
+}
+
 func (app *App) exportVars() {
 	dir := app.nav.currDir()
 
@@ -72,6 +438,8 @@ func (app *App) exportVars() {
 
 	envFiles := strings.Join(marks, ":")
 
+	os.Setenv("id", strconv.Itoa(gClientId))
+	os.Setenv("d", dir.path)
 	os.Setenv("f", envFile)
 	os.Setenv("fs", envFiles)
 
@@ -80,6 +448,18 @@ func (app *App) exportVars() {
 	} else {
 		os.Setenv("fx", envFiles)
 	}
+
+	// context of the view for scripts behaving differently per pane layout
+	panes := len(app.ui.wins)
+	if gOpts.preview && panes > 1 {
+		panes--
+	}
+	win := app.ui.wins[panes-1]
+
+	os.Setenv("LF_PANE", strconv.Itoa(panes))
+	os.Setenv("LF_PANES", strconv.Itoa(len(app.ui.wins)))
+	os.Setenv("LF_WIDTH", strconv.Itoa(win.w))
+	os.Setenv("LF_HEIGHT", strconv.Itoa(win.h))
 }
 
 // This function is used to run a command in shell. Following modes are used:
@@ -91,14 +471,7 @@ func (app *App) exportVars() {
 //
 // Waiting async commands are not used for now.
 func (app *App) runShell(s string, args []string, wait bool, async bool) {
-	app.exportVars()
-
-	if len(gOpts.ifs) != 0 {
-		s = fmt.Sprintf("IFS='%s'; %s", gOpts.ifs, s)
-	}
-
-	args = append([]string{"-c", s, "--"}, args...)
-	cmd := exec.Command(envShell, args...)
+	cmd := app.shellCmd(s, args)
 
 	if !async {
 		cmd.Stdin = os.Stdin
@@ -136,3 +509,329 @@ func (app *App) runShell(s string, args []string, wait bool, async bool) {
 		}
 	}
 }
+
+// This function returns the command to run the given shell command with the
+// given arguments. Variables are exported beforehand.
+func (app *App) shellCmd(s string, args []string) *exec.Cmd {
+	app.exportVars()
+
+	if len(gOpts.ifs) != 0 {
+		s = fmt.Sprintf("IFS='%s'; %s", gOpts.ifs, s)
+	}
+
+	args = append([]string{"-c", s, "--"}, args...)
+	cmd := exec.Command(envShell, args...)
+
+	cmd.Dir = app.dir
+
+	return cmd
+}
+
+// This function runs the given shell command in the background without
+// pausing the ui. Each line of its output is shown in the message line as it
+// is written and directories are renewed when the command exits.
+func (app *App) runPipe(s string, args []string) {
+	cmd := app.shellCmd(s, args)
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		msg := fmt.Sprintf("running shell: %s", err)
+		app.ui.message = msg
+		log.Print(msg)
+		return
+	}
+	cmd.Stderr = cmd.Stdout
+
+	if err := cmd.Start(); err != nil {
+		msg := fmt.Sprintf("running shell: %s", err)
+		app.ui.message = msg
+		log.Print(msg)
+		return
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			app.exprChan <- &CallExpr{"echo", []string{scanner.Text()}}
+			screenInterrupt()
+		}
+
+		if err := cmd.Wait(); err != nil {
+			msg := fmt.Sprintf("running shell: %s", err)
+			log.Print(msg)
+			app.exprChan <- &CallExpr{"echo", []string{msg}}
+		}
+
+		app.exprChan <- &CallExpr{"redraw", nil}
+		screenInterrupt()
+	}()
+}
+
+// This function starts an interactive shell (or the command given in
+// 'terminal' option) in the current directory. Variables are exported as in
+// shell commands and the ui is resumed when the shell exits.
+func (app *App) runTerminal() {
+	app.exportVars()
+
+	var cmd *exec.Cmd
+	if gOpts.terminal == "" {
+		cmd = exec.Command(envShell)
+	} else {
+		cmd = exec.Command(envShell, "-c", gOpts.terminal)
+	}
+
+	cmd.Dir = app.nav.currDir().path
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	app.ui.pause()
+	defer app.ui.resume()
+	defer app.nav.renew(app.ui.wins[0].h)
+
+	if err := cmd.Run(); err != nil {
+		msg := fmt.Sprintf("running terminal: %s", err)
+		app.ui.message = msg
+		log.Print(msg)
+	}
+}
+
+// This function is called when an operation fails due to permissions. When
+// 'escalate' option is set (e.g. 'sudo'), it asks for confirmation and reruns
+// the given command line with the escalation command in a waiting shell so
+// that a password can be entered. It returns false when the command is not
+// run.
+func (app *App) escalate(args []string) bool {
+	if gOpts.escalate == "" {
+		return false
+	}
+
+	ans := app.ui.prompt(fmt.Sprintf("permission denied, retry with %s? [y/N] ", gOpts.escalate))
+	if ans != "y" && ans != "Y" {
+		return false
+	}
+
+	log.Printf("escalate: %s %v", gOpts.escalate, args)
+
+	app.runShell(gOpts.escalate+` "$@"`, args, true, false)
+
+	return true
+}
+
+// This function copies or moves the selected files to the given directory and
+// offers to escalate when it fails due to permissions. Errors are shown with
+// the given command name.
+func (app *App) copyTo(name, dst string, keep bool) {
+	if err := app.nav.copyTo(dst, keep); err != nil {
+		if os.IsPermission(err) {
+			args := append(shellOp(keep), app.nav.currSelection()...)
+			if app.escalate(append(args, dst)) {
+				app.nav.renew(app.nav.height)
+				return
+			}
+		}
+		msg := fmt.Sprintf("%s: %s", name, err)
+		app.ui.message = msg
+		log.Print(msg)
+		app.ui.bell()
+		return
+	}
+	app.nav.renew(app.nav.height)
+}
+
+// This function copies the paths of the selected files to the clipboard using
+// the command given in 'clipboard' option. Paths are joined with the given
+// separator and quoted for shell when requested.
+func (app *App) copyPaths(sep string, quote bool) error {
+	paths := app.nav.currSelection()
+	sort.Strings(paths)
+
+	if quote {
+		for i, p := range paths {
+			paths[i] = shellQuote(p)
+		}
+	}
+
+	return app.copyText(strings.Join(paths, sep))
+}
+
+// This function copies the content of the current file to the clipboard
+// using the command given in 'clipboard' option. Files larger than
+// 'clipsize' kilobytes and binary files are refused.
+func (app *App) copyContent() error {
+	p, err := extractPath(app.nav.currPath())
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	if !f.Mode().IsRegular() {
+		return fmt.Errorf("not a regular file: %s", f.Name())
+	}
+
+	if gOpts.clipsize > 0 && f.Size() > int64(gOpts.clipsize)<<10 {
+		return fmt.Errorf("file is larger than %dK: %s", gOpts.clipsize, f.Name())
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+
+	if bytes.IndexByte(b, 0) >= 0 {
+		return fmt.Errorf("binary file: %s", f.Name())
+	}
+
+	return app.copyText(string(b))
+}
+
+func (app *App) copyText(text string) error {
+	cmd := exec.Command(envShell, "-c", gOpts.clipboard)
+
+	cmd.Stdin = strings.NewReader(text)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// This function shows the given text in the pager given by '$PAGER' variable.
+// The ui is paused while the pager is running.
+func (app *App) runPager(text string) {
+	cmd := exec.Command(envShell, "-c", envPager)
+
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	app.ui.pause()
+	defer app.ui.resume()
+
+	if err := cmd.Run(); err != nil {
+		msg := fmt.Sprintf("running pager: %s", err)
+		app.ui.message = msg
+		log.Print(msg)
+	}
+}
+
+// This function writes the names of the selected files to a temporary file to
+// be edited with '$EDITOR' and renames the files to the edited names. Files in
+// the current directory are listed with their names and others with their
+// paths. Lines should not be added or removed. Renames are only listed in the
+// pager for dry runs.
+func (app *App) bulkRename(dry bool) error {
+	dir := app.nav.currDir()
+
+	list := app.nav.currSelection()
+
+	for _, p := range list {
+		if err := checkArchive(p); err != nil {
+			return err
+		}
+	}
+
+	var names []string
+	for _, p := range list {
+		if path.Dir(p) == dir.path {
+			p = path.Base(p)
+		}
+		names = append(names, p)
+	}
+
+	f, err := ioutil.TempFile("", "lf-bulkrename-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(strings.Join(names, "\n") + "\n")
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(envShell, "-c", envEditor+` "$1"`, "--", f.Name())
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	app.ui.pause()
+	err = cmd.Run()
+	app.ui.resume()
+	if err != nil {
+		return fmt.Errorf("running editor: %s", err)
+	}
+
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+
+	edited := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if len(edited) != len(list) {
+		return fmt.Errorf("number of lines changed from %d to %d", len(list), len(edited))
+	}
+
+	var news []string
+	for _, s := range edited {
+		if s == "" {
+			return fmt.Errorf("empty name")
+		}
+		news = append(news, app.nav.absPath(s))
+	}
+
+	if dry {
+		steps, err := renamePlan(list, news, currJobOpts())
+		if err != nil {
+			return err
+		}
+		var plan []string
+		for _, s := range steps {
+			plan = append(plan, fmt.Sprintf("rename %s -> %s", s.src, s.dst))
+		}
+		if len(plan) == 0 {
+			plan = append(plan, "no file renamed")
+		}
+		app.runPager(strings.Join(plan, "\n") + "\n")
+		return nil
+	}
+
+	old := dir.names()
+
+	if err := bulkRename(list, news, currJobOpts()); err != nil {
+		return err
+	}
+
+	app.nav.marks = make(map[string]bool)
+	app.nav.renew(app.nav.height)
+	app.nav.follow(old)
+
+	return nil
+}
+
+func (app *App) dumpOpts() {
+	t := new(tabwriter.Writer)
+	b := new(bytes.Buffer)
+
+	defs := gDefaultOpts.list()
+
+	t.Init(b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(t, "option\tvalue\tdefault")
+	for i, opt := range gOpts.list() {
+		def := defs[i][1]
+		if opt[1] != def {
+			opt[0] += " *"
+		}
+		fmt.Fprintf(t, "%s\t%s\t%s\n", opt[0], opt[1], def)
+	}
+	t.Flush()
+
+	app.runPager(b.String())
+}
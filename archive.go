@@ -0,0 +1,431 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Extensions of archives which can be browsed as directories.
+var gArchiveExts = []string{".zip", ".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2"}
+
+func isArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range gArchiveExts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Files inside archives can not be modified.
+var errReadOnly = errors.New("archives are read-only")
+
+// ArchiveFile is an entry of an archive. Name is taken from the cleaned path
+// of the entry since names stored in archives may have leading './' or
+// trailing slashes.
+type ArchiveFile struct {
+	os.FileInfo
+	name string
+}
+
+func (f ArchiveFile) Name() string { return f.name }
+
+// ArchiveDir is a directory which is not stored in the archive itself but is
+// a parent of a stored entry.
+type ArchiveDir struct {
+	name  string
+	mtime time.Time
+}
+
+func (d ArchiveDir) Name() string       { return d.name }
+func (d ArchiveDir) Size() int64        { return 0 }
+func (d ArchiveDir) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (d ArchiveDir) ModTime() time.Time { return d.mtime }
+func (d ArchiveDir) IsDir() bool        { return true }
+func (d ArchiveDir) Sys() interface{}   { return nil }
+
+// Archive is the listing of an archive file browsed as a directory. Entries
+// are kept with their cleaned paths in the archive without a leading slash.
+type Archive struct {
+	path  string
+	mtime time.Time
+	files map[string]os.FileInfo
+	dirs  map[string][]os.FileInfo // entries of directories, "" for the root
+}
+
+// Archives are read once and read again when they are modified. Directories
+// inside archives are read in the background so access is synchronized.
+var (
+	gArchives     = make(map[string]*Archive)
+	gArchiveMutex sync.Mutex
+)
+
+// This function returns the path of the archive and the path inside the
+// archive for the given path if it is an archive or inside one. Inner path
+// is empty for the archive itself.
+func splitArchive(p string) (arch, inner string, ok bool) {
+	for i := 1; i <= len(p); i++ {
+		if i < len(p) && p[i] != '/' {
+			continue
+		}
+		if !isArchive(p[:i]) {
+			continue
+		}
+		if f, err := os.Stat(p[:i]); err == nil && f.Mode().IsRegular() {
+			return p[:i], strings.Trim(p[i:], "/"), true
+		}
+	}
+	return "", "", false
+}
+
+// This function returns whether the given file can be opened as a directory.
+// Archives are browsed as directories.
+func isBrowsable(p string, f os.FileInfo) bool {
+	return f.IsDir() || f.Mode().IsRegular() && isArchive(p)
+}
+
+// This function returns the information of the given file which can be
+// inside an archive.
+func statPath(p string) (os.FileInfo, error) {
+	arch, inner, ok := splitArchive(p)
+	if !ok || inner == "" {
+		return os.Stat(p)
+	}
+
+	a, err := openArchive(arch)
+	if err != nil {
+		return nil, err
+	}
+
+	f, ok := a.files[inner]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist}
+	}
+
+	return f, nil
+}
+
+// This function returns the entries of the given directory which can be an
+// archive or a directory inside one.
+func readEntries(p string) ([]os.FileInfo, error) {
+	arch, inner, ok := splitArchive(p)
+	if !ok {
+		return ioutil.ReadDir(p)
+	}
+
+	a, err := openArchive(arch)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := a.files[inner]; !ok && inner != "" {
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	}
+
+	// entries are copied since they are sorted in place
+	return append([]os.FileInfo(nil), a.dirs[inner]...), nil
+}
+
+// This function returns an error if the given path is inside an archive. It
+// is used to refuse operations modifying files.
+func checkArchive(p string) error {
+	if _, _, ok := splitArchive(p); ok {
+		return errReadOnly
+	}
+	return nil
+}
+
+// This function changes the working directory to the given directory. For
+// archives and directories inside archives, the directory of the archive is
+// used instead.
+func chdir(p string) error {
+	arch, _, ok := splitArchive(p)
+	if !ok {
+		return os.Chdir(p)
+	}
+
+	f, err := statPath(p)
+	if err != nil {
+		return err
+	}
+
+	if !isBrowsable(p, f) {
+		return fmt.Errorf("not a directory: %s", p)
+	}
+
+	return os.Chdir(path.Dir(arch))
+}
+
+func openArchive(p string) (*Archive, error) {
+	f, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	gArchiveMutex.Lock()
+	defer gArchiveMutex.Unlock()
+
+	if a, ok := gArchives[p]; ok && a.mtime.Equal(f.ModTime()) {
+		return a, nil
+	}
+
+	a := &Archive{
+		path:  p,
+		mtime: f.ModTime(),
+		files: make(map[string]os.FileInfo),
+		dirs:  make(map[string][]os.FileInfo),
+	}
+
+	err = walkArchive(p, func(name string, f os.FileInfo, open func() (io.Reader, error)) (bool, error) {
+		a.add(name, f)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, f := range a.files {
+		dir := path.Dir(name)
+		if dir == "." {
+			dir = ""
+		}
+		a.dirs[dir] = append(a.dirs[dir], f)
+	}
+
+	gArchives[p] = a
+
+	return a, nil
+}
+
+// This function adds the given entry to the archive along with its parent
+// directories if they are not stored in the archive.
+func (a *Archive) add(name string, f os.FileInfo) {
+	a.files[name] = ArchiveFile{f, path.Base(name)}
+
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := a.files[dir]; ok {
+			break
+		}
+		a.files[dir] = ArchiveDir{path.Base(dir), a.mtime}
+	}
+}
+
+// This function calls the given function for each entry of the given archive
+// with the cleaned name of the entry and a function to read its contents.
+// Walking stops when the function returns true or an error.
+func walkArchive(p string, fn func(name string, f os.FileInfo, open func() (io.Reader, error)) (bool, error)) error {
+	clean := func(name string) string {
+		return strings.TrimPrefix(path.Clean("/"+name), "/")
+	}
+
+	if strings.HasSuffix(strings.ToLower(p), ".zip") {
+		r, err := zip.OpenReader(p)
+		if err != nil {
+			return fmt.Errorf("reading archive: %s", err)
+		}
+		defer r.Close()
+
+		for _, zf := range r.File {
+			name := clean(zf.Name)
+			if name == "" {
+				continue
+			}
+
+			var rc io.ReadCloser
+			open := func() (io.Reader, error) {
+				var err error
+				rc, err = zf.Open()
+				return rc, err
+			}
+
+			stop, err := fn(name, zf.FileInfo(), open)
+			if rc != nil {
+				rc.Close()
+			}
+			if stop || err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return fmt.Errorf("reading archive: %s", err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+
+	switch lower := strings.ToLower(p); {
+	case strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz"):
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("reading archive: %s", err)
+		}
+		defer gz.Close()
+		r = gz
+	case strings.HasSuffix(lower, ".bz2") || strings.HasSuffix(lower, ".tbz2"):
+		r = bzip2.NewReader(file)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive: %s", err)
+		}
+
+		name := clean(hdr.Name)
+		if name == "" {
+			continue
+		}
+
+		open := func() (io.Reader, error) { return tr, nil }
+
+		if stop, err := fn(name, hdr.FileInfo(), open); stop || err != nil {
+			return err
+		}
+	}
+}
+
+// Temporary directory files inside archives are extracted to. It is created
+// when the first file is extracted and removed on exit. Extracted paths are
+// remembered since directories may exist before all of their contents are
+// extracted.
+var (
+	gArchiveTemp string
+	gExtracted   = make(map[string]bool)
+)
+
+// This function returns the path of the given file on the file system. Files
+// inside archives are extracted to a temporary directory on demand along with
+// the contents of directories. Other paths are returned as they are.
+func extractPath(p string) (string, error) {
+	arch, inner, ok := splitArchive(p)
+	if !ok || inner == "" {
+		return p, nil
+	}
+
+	a, err := openArchive(arch)
+	if err != nil {
+		return "", err
+	}
+
+	f, ok := a.files[inner]
+	if !ok {
+		return "", &os.PathError{Op: "extract", Path: p, Err: os.ErrNotExist}
+	}
+
+	if gArchiveTemp == "" {
+		dir, err := ioutil.TempDir("", "lf-archive-")
+		if err != nil {
+			return "", fmt.Errorf("creating temporary directory: %s", err)
+		}
+		gArchiveTemp = dir
+	}
+
+	// archives are extracted to separate directories for each version
+	key := fmt.Sprintf("%s\x00%d", arch, a.mtime.UnixNano())
+	root := path.Join(gArchiveTemp, fmt.Sprintf("%x", sha1.Sum([]byte(key))))
+	dst := path.Join(root, inner)
+
+	if gExtracted[dst] {
+		return dst, nil
+	}
+
+	err = walkArchive(arch, func(name string, f os.FileInfo, open func() (io.Reader, error)) (bool, error) {
+		if name != inner && !strings.HasPrefix(name, inner+"/") {
+			return false, nil
+		}
+		if err := extractFile(path.Join(root, name), f, open); err != nil {
+			return true, err
+		}
+		// single files are extracted without reading the rest
+		return name == inner && !f.IsDir(), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if f.IsDir() {
+		// directories may not be stored in the archive or may be empty
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return "", fmt.Errorf("extracting file: %s", err)
+		}
+	} else if _, err := os.Lstat(dst); err != nil {
+		return "", fmt.Errorf("extracting file: %s", err)
+	}
+
+	gExtracted[dst] = true
+
+	return dst, nil
+}
+
+func extractFile(dst string, f os.FileInfo, open func() (io.Reader, error)) error {
+	if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("extracting file: %s", err)
+	}
+
+	switch {
+	case f.IsDir():
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return fmt.Errorf("extracting file: %s", err)
+		}
+	case f.Mode().IsRegular():
+		r, err := open()
+		if err != nil {
+			return fmt.Errorf("extracting file: %s", err)
+		}
+		out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode().Perm()|0600)
+		if err != nil {
+			return fmt.Errorf("extracting file: %s", err)
+		}
+		if _, err := io.Copy(out, r); err != nil {
+			out.Close()
+			return fmt.Errorf("extracting file: %s", err)
+		}
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("extracting file: %s", err)
+		}
+	default:
+		// links and special files are skipped
+		return nil
+	}
+
+	if err := os.Chtimes(dst, f.ModTime(), f.ModTime()); err != nil {
+		log.Printf("setting time of extracted file: %s", err)
+	}
+
+	return nil
+}
+
+// This function removes the files extracted from archives.
+func cleanArchives() {
+	if gArchiveTemp == "" {
+		return
+	}
+	if err := os.RemoveAll(gArchiveTemp); err != nil {
+		log.Printf("removing extracted files: %s", err)
+	}
+	gArchiveTemp = ""
+	gExtracted = make(map[string]bool)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Files written to test archives. Parent directories are not stored so that
+// they are expected to be added when archives are read.
+var gTestArchiveFiles = []struct {
+	name string
+	body string
+}{
+	{"./foo", "foo"},
+	{"dir/bar", "bar"},
+	{"dir/sub/baz", "baz"},
+}
+
+func writeTestArchives(t *testing.T, dir string) {
+	zf, err := os.Create(path.Join(dir, "test.zip"))
+	if err != nil {
+		t.Fatalf("creating archive: %s", err)
+	}
+	zw := zip.NewWriter(zf)
+	for _, f := range gTestArchiveFiles {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatalf("writing archive: %s", err)
+		}
+		w.Write([]byte(f.body))
+	}
+	zw.Close()
+	zf.Close()
+
+	tf, err := os.Create(path.Join(dir, "test.tar.gz"))
+	if err != nil {
+		t.Fatalf("creating archive: %s", err)
+	}
+	gw := gzip.NewWriter(tf)
+	tw := tar.NewWriter(gw)
+	for _, f := range gTestArchiveFiles {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("writing archive: %s", err)
+		}
+		tw.Write([]byte(f.body))
+	}
+	tw.Close()
+	gw.Close()
+	tf.Close()
+}
+
+func TestArchives(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer cleanArchives()
+
+	writeTestArchives(t, dir)
+
+	for _, name := range []string{"test.zip", "test.tar.gz"} {
+		arch := path.Join(dir, name)
+
+		splits := []struct {
+			p     string
+			inner string
+			ok    bool
+		}{
+			{dir, "", false},
+			{arch, "", true},
+			{arch + "/dir/sub", "dir/sub", true},
+			{path.Join(dir, "test"), "", false},
+		}
+
+		for _, test := range splits {
+			if _, inner, ok := splitArchive(test.p); inner != test.inner || ok != test.ok {
+				t.Errorf("at input '%s' expected '%s' (%t) but got '%s' (%t)", test.p, test.inner, test.ok, inner, ok)
+			}
+		}
+
+		entries := []struct {
+			p   string
+			exp string
+		}{
+			{arch, "dir foo"},
+			{arch + "/dir", "bar sub"},
+			{arch + "/dir/sub", "baz"},
+		}
+
+		for _, test := range entries {
+			fi, err := readEntries(test.p)
+			if err != nil {
+				t.Errorf("at input '%s' expected '%s' but got error: %s", test.p, test.exp, err)
+				continue
+			}
+			var names []string
+			for _, f := range fi {
+				names = append(names, f.Name())
+			}
+			sort.Strings(names)
+			if got := strings.Join(names, " "); got != test.exp {
+				t.Errorf("at input '%s' expected '%s' but got '%s'", test.p, test.exp, got)
+			}
+		}
+
+		if f, err := statPath(arch + "/dir"); err != nil || !f.IsDir() {
+			t.Errorf("at input '%s' expected a directory but got error: %v", arch+"/dir", err)
+		}
+
+		if _, err := statPath(arch + "/nothing"); !os.IsNotExist(err) {
+			t.Errorf("at input '%s' expected not exist error but got: %v", arch+"/nothing", err)
+		}
+
+		p, err := extractPath(arch + "/dir/sub/baz")
+		if err != nil {
+			t.Fatalf("extracting file: %s", err)
+		}
+		if b, err := ioutil.ReadFile(p); err != nil || string(b) != "baz" {
+			t.Errorf("at input '%s' expected 'baz' but got '%s' (%v)", arch+"/dir/sub/baz", b, err)
+		}
+
+		p, err = extractPath(arch + "/dir")
+		if err != nil {
+			t.Fatalf("extracting directory: %s", err)
+		}
+		if b, err := ioutil.ReadFile(path.Join(p, "bar")); err != nil || string(b) != "bar" {
+			t.Errorf("at input '%s' expected 'bar' but got '%s' (%v)", arch+"/dir", b, err)
+		}
+
+		if err := checkArchive(arch + "/foo"); err != errReadOnly {
+			t.Errorf("at input '%s' expected read-only error but got: %v", arch+"/foo", err)
+		}
+
+		if d := lastDir(arch + "/dir/sub"); d != dir {
+			t.Errorf("at input '%s' expected last dir '%s' but got '%s'", arch+"/dir/sub", dir, d)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Bookmarks are kept in a file with a directory path on each line so that
+// they are shared between clients and can be edited by hand. Bookmarks saved
+// with a mark are written with the letter and a colon before the path (e.g.
+// 'a:/home/user/docs'). Empty lines and lines starting with '#' are ignored.
+func readBookmarks() ([]string, error) {
+	f, err := os.Open(gBookmarksPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+
+	s := bufio.NewScanner(f)
+
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+
+	return lines, s.Err()
+}
+
+// This function splits the mark from the path of a line in the bookmarks
+// file. Mark is empty for bookmarks without marks and ignored lines.
+func splitBookmark(line string) (key, p string) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", ""
+	}
+	if toks := strings.SplitN(line, ":", 2); len(toks) == 2 && isMarkKey(toks[0]) {
+		return toks[0], toks[1]
+	}
+	return "", line
+}
+
+// This function returns the directories in the bookmarks file including the
+// ones saved with marks.
+func loadBookmarks() ([]string, error) {
+	lines, err := readBookmarks()
+	if err != nil {
+		return nil, err
+	}
+
+	var marks []string
+	seen := make(map[string]bool)
+
+	for _, line := range lines {
+		if _, p := splitBookmark(line); p != "" && !seen[p] {
+			marks = append(marks, p)
+			seen[p] = true
+		}
+	}
+
+	return marks, nil
+}
+
+func addBookmark(p string) error {
+	marks, err := loadBookmarks()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range marks {
+		if m == p {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(path.Dir(gBookmarksPath), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(gBookmarksPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintln(f, p)
+
+	return err
+}
+
+// Marks are bookmarks saved with a letter so that they can be jumped to with
+// a single key.
+func loadMarks() (map[string]string, error) {
+	lines, err := readBookmarks()
+	if err != nil {
+		return nil, err
+	}
+
+	marks := make(map[string]string)
+
+	for _, line := range lines {
+		if key, p := splitBookmark(line); key != "" {
+			marks[key] = p
+		}
+	}
+
+	return marks, nil
+}
+
+// This function reports whether the given key can be used for a mark.
+func isMarkKey(key string) bool {
+	if len(key) != 1 {
+		return false
+	}
+	c := key[0]
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// This function saves the given directory with the given mark replacing the
+// directory previously saved with the mark if any. Other lines of the
+// bookmarks file are kept as they are.
+func saveMark(key, p string) error {
+	if !isMarkKey(key) {
+		return fmt.Errorf("invalid mark: %s", key)
+	}
+
+	lines, err := readBookmarks()
+	if err != nil {
+		return err
+	}
+
+	line := fmt.Sprintf("%s:%s", key, p)
+
+	found := false
+	for i, l := range lines {
+		if k, _ := splitBookmark(l); k == key {
+			lines[i] = line
+			found = true
+			break
+		}
+	}
+	if !found {
+		lines = append(lines, line)
+	}
+
+	if err := os.MkdirAll(path.Dir(gBookmarksPath), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(gBookmarksPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
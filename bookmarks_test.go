@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestBookmarks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(p string) { gBookmarksPath = p }(gBookmarksPath)
+	gBookmarksPath = path.Join(dir, "lf", "bookmarks")
+
+	for _, p := range []string{"/foo", "/bar", "/foo"} {
+		if err := addBookmark(p); err != nil {
+			t.Fatalf("adding bookmark: %s", err)
+		}
+	}
+
+	marks, err := loadBookmarks()
+	if err != nil {
+		t.Fatalf("loading bookmarks: %s", err)
+	}
+
+	if exp := []string{"/foo", "/bar"}; !reflect.DeepEqual(marks, exp) {
+		t.Errorf("expected '%v' but got '%v'", exp, marks)
+	}
+}
+
+func TestMarks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(p string) { gBookmarksPath = p }(gBookmarksPath)
+	gBookmarksPath = path.Join(dir, "lf", "bookmarks")
+
+	if err := addBookmark("/quux"); err != nil {
+		t.Fatalf("adding bookmark: %s", err)
+	}
+
+	for _, m := range [][2]string{{"b", "/foo"}, {"a", "/bar:baz"}, {"b", "/qux"}} {
+		if err := saveMark(m[0], m[1]); err != nil {
+			t.Fatalf("saving mark: %s", err)
+		}
+	}
+
+	if err := saveMark("1", "/foo"); err == nil {
+		t.Errorf("at invalid mark expected an error but got none")
+	}
+
+	marks, err := loadMarks()
+	if err != nil {
+		t.Fatalf("loading marks: %s", err)
+	}
+
+	if exp := map[string]string{"a": "/bar:baz", "b": "/qux"}; !reflect.DeepEqual(marks, exp) {
+		t.Errorf("expected '%v' but got '%v'", exp, marks)
+	}
+
+	// marks are kept in the bookmarks file with the other bookmarks
+	bookmarks, err := loadBookmarks()
+	if err != nil {
+		t.Fatalf("loading bookmarks: %s", err)
+	}
+
+	if exp := []string{"/quux", "/qux", "/bar:baz"}; !reflect.DeepEqual(bookmarks, exp) {
+		t.Errorf("expected '%v' but got '%v'", exp, bookmarks)
+	}
+}
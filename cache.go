@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This function returns the directory given with 'cachedir' option with the
+// home directory expanded. It returns an empty string when caching is off.
+func cacheDir() string {
+	dir := gOpts.cachedir
+	if strings.HasPrefix(dir, "~") {
+		dir = envHome + dir[1:]
+	}
+	return dir
+}
+
+// Prefix of temporary files in the cache directory.
+const gCacheTempPrefix = ".tmp-"
+
+// CacheOpts are the options of the preview cache. They are copied in the main
+// loop for previews generated in the background since options may be changed
+// with 'set' in the meantime.
+type CacheOpts struct {
+	dir   string
+	limit int64 // in bytes
+}
+
+func currCacheOpts() CacheOpts {
+	return CacheOpts{cacheDir(), int64(gOpts.cachesize) << 20}
+}
+
+// This function returns the name of the cache file for the preview of the
+// given file. Modification time and size of the file are part of the key so
+// that a new preview is generated when the file is changed. Previewer command
+// and dimensions are also part of the key since they change the output.
+func cacheKey(s, path string, f os.FileInfo, w, h int) string {
+	key := fmt.Sprintf("%s\x00%s\x00%d\x00%d\x00%d\x00%d", s, path, f.ModTime().UnixNano(), f.Size(), w, h)
+	return fmt.Sprintf("%x", sha1.Sum([]byte(key)))
+}
+
+// This function returns the output of the previewer for the given file. When
+// the cache directory is set, the output is read from the cache if available
+// or otherwise written to the cache after running the previewer. Cache is
+// pruned to the size limit afterwards by removing the least recently used
+// files.
+func cachedPreview(s, p string, f os.FileInfo, w, h int, opts CacheOpts) ([]byte, error) {
+	dir := opts.dir
+	if dir == "" {
+		return runPreviewer(s, p, w, h)
+	}
+
+	name := path.Join(dir, cacheKey(s, p, f, w, h))
+
+	if out, err := ioutil.ReadFile(name); err == nil {
+		// modification time is used to find least recently used files
+		now := time.Now()
+		if err := os.Chtimes(name, now, now); err != nil {
+			log.Printf("touching cache file: %s", err)
+		}
+		return out, nil
+	}
+
+	out, err := runPreviewer(s, p, w, h)
+	if err != nil {
+		return out, err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("creating cache directory: %s", err)
+		return out, nil
+	}
+
+	if err := writeCacheFile(name, out); err != nil {
+		log.Printf("writing cache file: %s", err)
+		return out, nil
+	}
+
+	pruneCache(dir, opts.limit)
+
+	return out, nil
+}
+
+// This function writes the given cache file. Contents are written to a
+// temporary file in the same directory which is renamed afterwards so that
+// previews generated at the same time never see a partially written file.
+func writeCacheFile(name string, data []byte) error {
+	tmp, err := ioutil.TempFile(path.Dir(name), gCacheTempPrefix)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// This function removes least recently used files in the given directory
+// until the total size of the files is not larger than the given limit.
+func pruneCache(dir string, limit int64) {
+	fi, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Printf("reading cache directory: %s", err)
+		return
+	}
+
+	// temporary files are still being written by other previews
+	files := fi[:0]
+	for _, f := range fi {
+		if !strings.HasPrefix(f.Name(), gCacheTempPrefix) {
+			files = append(files, f)
+		}
+	}
+	fi = files
+
+	var total int64
+	for _, f := range fi {
+		total += f.Size()
+	}
+
+	if total <= limit {
+		return
+	}
+
+	sort.Sort(ByTime(fi))
+
+	for _, f := range fi {
+		if total <= limit {
+			break
+		}
+		if err := os.Remove(path.Join(dir, f.Name())); err != nil {
+			log.Printf("removing cache file: %s", err)
+			continue
+		}
+		total -= f.Size()
+	}
+}
+
+// Number of background workers generating previews ahead of time.
+const gPrefetchWorkers = 2
+
+type prefetchJob struct {
+	s    string
+	path string
+	f    os.FileInfo
+	w, h int
+	opts CacheOpts
+}
+
+var (
+	gPrefetchOnce    sync.Once
+	gPrefetchJobs    chan prefetchJob
+	gPrefetchMutex   sync.Mutex
+	gPrefetchPending = make(map[string]bool)
+)
+
+func prefetchWorker() {
+	for job := range gPrefetchJobs {
+		if _, err := cachedPreview(job.s, job.path, job.f, job.w, job.h, job.opts); err != nil {
+			log.Printf("prefetching preview: %s", err)
+		}
+
+		gPrefetchMutex.Lock()
+		delete(gPrefetchPending, job.path)
+		gPrefetchMutex.Unlock()
+	}
+}
+
+// This function queues previews of the visible files in the given directory
+// to be generated in the background when 'cachedir' option is set. This way
+// scrolling through directories with expensive previews (e.g. image
+// thumbnails) does not wait for the previewer on each file. Files which are
+// already cached or queued are skipped and files are dropped when the queue
+// is full.
+func prefetchPreviews(dir *Dir, height, w, h int) {
+	opts := currCacheOpts()
+	if opts.dir == "" || len(gOpts.prevs) == 0 {
+		return
+	}
+
+	// files inside archives are extracted when they are previewed
+	if _, _, ok := splitArchive(dir.path); ok {
+		return
+	}
+
+	gPrefetchOnce.Do(func() {
+		gPrefetchJobs = make(chan prefetchJob, 64)
+		for i := 0; i < gPrefetchWorkers; i++ {
+			go prefetchWorker()
+		}
+	})
+
+	beg := max(dir.ind-dir.pos, 0)
+	end := min(beg+height, len(dir.fi))
+
+	for _, f := range dir.fi[beg:end] {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		p := path.Join(dir.path, f.Name())
+
+		s := previewCmd(p)
+		if s == "" {
+			continue
+		}
+
+		if _, err := os.Stat(path.Join(opts.dir, cacheKey(s, p, f, w, h))); err == nil {
+			continue
+		}
+
+		gPrefetchMutex.Lock()
+		if gPrefetchPending[p] {
+			gPrefetchMutex.Unlock()
+			continue
+		}
+
+		select {
+		case gPrefetchJobs <- prefetchJob{s, p, f, w, h, opts}:
+			gPrefetchPending[p] = true
+		default:
+		}
+		gPrefetchMutex.Unlock()
+	}
+}
+
+// This function returns the shell command to preview the given file. Commands
+// defined with 'previewer' for matching patterns take precedence over the
+// script given in 'previewer' option. It returns an empty string when there is
+// no previewer for the file.
+func previewCmd(p string) string {
+	if expr := findHandler(gOpts.prevs, p); expr != nil {
+		return expr.(*ExecExpr).expr
+	}
+
+	if gOpts.previewer != "" {
+		script := gOpts.previewer
+		if strings.HasPrefix(script, "~") {
+			script = envHome + script[1:]
+		}
+		return shellQuote(script) + ` "$@"`
+	}
+
+	return ""
+}
+
+// Maximum number of previews kept in memory before they are cleared.
+const gMaxPreviews = 32
+
+var (
+	gPreviews       = make(map[string][]byte)
+	gPreviewPending = make(map[string]bool)
+	gPreviewMutex   sync.Mutex
+	gPreviewChan    = make(chan string, 16)
+)
+
+// This function returns the output of the previewer for the given file if it
+// is ready. Otherwise it starts the previewer in the background and returns
+// false. The main loop is notified with 'gPreviewChan' when the output is
+// ready so that the preview pane is redrawn. Outputs are kept in memory and
+// also in 'cachedir' if it is set.
+func asyncPreview(s, p string, f os.FileInfo, w, h int) ([]byte, bool) {
+	key := cacheKey(s, p, f, w, h)
+
+	gPreviewMutex.Lock()
+	defer gPreviewMutex.Unlock()
+
+	if out, ok := gPreviews[key]; ok {
+		return out, true
+	}
+
+	if gPreviewPending[key] {
+		return nil, false
+	}
+	gPreviewPending[key] = true
+
+	opts := currCacheOpts()
+
+	go func() {
+		out, err := cachedPreview(s, p, f, w, h, opts)
+		if err != nil {
+			log.Printf("running previewer: %s", err)
+		}
+
+		gPreviewMutex.Lock()
+		if len(gPreviews) >= gMaxPreviews {
+			gPreviews = make(map[string][]byte)
+		}
+		gPreviews[key] = out
+		delete(gPreviewPending, key)
+		gPreviewMutex.Unlock()
+
+		gPreviewChan <- p
+		screenInterrupt()
+	}()
+
+	return nil, false
+}
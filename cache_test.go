@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPruneCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	for i, name := range []string{"old", "mid", "new"} {
+		p := path.Join(dir, name)
+		if err := ioutil.WriteFile(p, make([]byte, 10), 0600); err != nil {
+			t.Fatalf("writing file: %s", err)
+		}
+		mtime := now.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatalf("changing times: %s", err)
+		}
+	}
+
+	tests := []struct {
+		limit int64
+		names []string
+	}{
+		{30, []string{"mid", "new", "old"}},
+		{25, []string{"mid", "new"}},
+		{10, []string{"new"}},
+		{0, nil},
+	}
+
+	for _, test := range tests {
+		pruneCache(dir, test.limit)
+
+		fi, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("reading directory: %s", err)
+		}
+
+		var names []string
+		for _, f := range fi {
+			names = append(names, f.Name())
+		}
+		sort.Strings(names)
+
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("at limit '%d' expected '%v' but got '%v'", test.limit, test.names, names)
+		}
+	}
+}
+
+func TestWriteCacheFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := path.Join(dir, "key")
+	if err := writeCacheFile(name, []byte("preview")); err != nil {
+		t.Fatalf("writing cache file: %s", err)
+	}
+
+	fi, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("reading directory: %s", err)
+	}
+	if len(fi) != 1 || fi[0].Name() != "key" {
+		t.Errorf("expected only the cache file but got '%v'", fi)
+	}
+
+	tmp := path.Join(dir, gCacheTempPrefix+"partial")
+	if err := ioutil.WriteFile(tmp, make([]byte, 100), 0600); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+
+	pruneCache(dir, 0)
+
+	if _, err := os.Stat(tmp); err != nil {
+		t.Errorf("temporary file should not be pruned: %s", err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("cache file should be pruned")
+	}
+}
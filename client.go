@@ -2,12 +2,14 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
-
-	"github.com/nsf/termbox-go"
+	"strings"
+	"time"
 )
 
 func client() {
@@ -20,41 +22,256 @@ func client() {
 
 	log.Print("hi!")
 
-	if err := termbox.Init(); err != nil {
+	// printed after termbox is closed
+	var exitMsgs []string
+	defer func() {
+		for _, msg := range exitMsgs {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+	}()
+
+	st := newStartup()
+
+	if err := screenInit(); err != nil {
 		log.Fatalf("initializing termbox: %s", err)
 	}
-	defer termbox.Close()
+	defer screenClose()
+	defer stopUeberzug()
+	defer cleanArchives()
+
+	st.mark("initializing termbox")
 
 	ui := newUI()
 	nav := newNav(ui.wins[0].h)
-	app := &App{ui, nav}
+	app := &App{ui: ui, nav: nav, tabs: newTabs(nav), exprChan: make(chan Expr, 100)}
+	ui.tabs = app.tabs
+
+	st.mark("loading directories")
+
+	for _, path := range gConfigPaths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
 
-	if _, err := os.Stat(gConfigPath); err == nil {
-		log.Printf("reading configuration file: %s", gConfigPath)
+		log.Printf("reading configuration file: %s", path)
 
-		rcFile, err := os.Open(gConfigPath)
-		if err != nil {
-			msg := fmt.Sprintf("opening configuration file: %s", err)
+		if err := app.readConfig(path); err != nil {
+			msg := fmt.Sprintf("reading configuration file: %s", err)
 			app.ui.message = msg
-			log.Printf(msg)
-		} else {
+			log.Print(msg)
+			// also printed on exit since the message is easily missed
+			exitMsgs = append(exitMsgs, msg)
+		} else if len(exitMsgs) == 0 {
 			app.ui.echoFileInfo(app.nav)
 		}
-		defer rcFile.Close()
 
-		p := newParser(rcFile)
-		for p.parse() {
-			p.expr.eval(app, nil)
+		st.mark("reading configuration file")
+	}
+
+	// files given at startup are selected in their directories
+	if gStartPath != "" {
+		p := app.nav.absPath(gStartPath)
+		cd := app.nav.cd
+		if f, err := os.Stat(p); err == nil && !f.IsDir() {
+			cd = app.nav.find
+		}
+		if err := cd(p); err != nil {
+			app.ui.message = err.Error()
+			log.Print(err)
 		}
 
-		// TODO: parser error check
+		st.mark("changing to start path")
 	}
 
+	// commands are also read when stdin is not a terminal (e.g. 'lf < script')
+	if gCommandsPath == "" {
+		if f, err := os.Stdin.Stat(); err == nil && f.Mode()&os.ModeCharDevice == 0 {
+			gCommandsPath = "-"
+		}
+	}
+
+	if gCommandsPath != "" {
+		app.runCommands(gCommandsPath)
+
+		st.mark("running startup commands")
+	}
+
+	if gLevel > 1 {
+		switch gOpts.nested {
+		case "warn":
+			app.ui.message = fmt.Sprintf("warning: nested lf (level %d)", gLevel)
+		case "reuse":
+			if parent := os.Getenv("id"); parent != "" {
+				wd := app.nav.currDir().path
+				err := sendRemote(fmt.Sprintf("send %s cd %s", parent, wd))
+				if err == nil {
+					log.Printf("reusing parent client: %s", parent)
+					exitMsgs = append(exitMsgs, "lf: already running in a parent shell, exit the shell to return")
+					return
+				}
+				log.Printf("reusing parent client: %s", err)
+			}
+		}
+	}
+
+	// marks of other clients are loaded before the first change is shared
+	// unless files are already marked by startup commands
+	if len(app.nav.marks) == 0 {
+		if err := app.syncMarks(); err != nil {
+			log.Printf("loading marks: %s", err)
+		}
+	}
+
+	go readExpr(app.exprChan)
+
 	app.ui.draw(app.nav)
 
+	st.mark("drawing ui")
+	st.write()
+
 	app.handleInp()
 }
 
+// This function reads the given configuration file. The file is parsed as a
+// whole before it is evaluated so that a file with a syntax error is not
+// loaded partially. Errors are reported with their line and column.
+func (app *App) readConfig(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var exprs []Expr
+
+	p := newParser(f)
+	for p.parse() {
+		exprs = append(exprs, p.expr)
+	}
+
+	if p.err != nil {
+		return fmt.Errorf("%s:%s", path, p.err)
+	}
+
+	app.quiet = true
+	defer func() { app.quiet = false }()
+
+	for _, e := range exprs {
+		e.eval(app, nil)
+	}
+
+	return nil
+}
+
+// This function connects to the server and reads commands sent to this client
+// (e.g. 'lf -remote "send 1234 cd /path"'). Parsed expressions are passed to
+// the main loop using the given channel and termbox is interrupted to make
+// sure they are evaluated without waiting for a key press.
+func readExpr(ch chan<- Expr) {
+	var c net.Conn
+	var err error
+
+	// server may still be starting up
+	for i := 0; i < 10; i++ {
+		if c, err = net.Dial("unix", gSocketPath); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err != nil {
+		log.Printf("dialing to read commands: %s", err)
+		return
+	}
+	defer c.Close()
+
+	fmt.Fprintf(c, "conn %d\n", gClientId)
+
+	s := bufio.NewScanner(c)
+
+	for s.Scan() {
+		log.Printf("remote command: %s", s.Text())
+
+		p := newParser(strings.NewReader(s.Text()))
+		for p.parse() {
+			ch <- &RemoteExpr{p.expr}
+			screenInterrupt()
+		}
+
+		if p.err != nil {
+			log.Printf("remote command: %s", p.err)
+		}
+	}
+
+	if s.Err() != nil {
+		log.Printf("reading commands: %s", s.Err())
+	}
+}
+
+// RemoteExpr is an expression sent by the server. Options set in remote
+// commands are not broadcast again since the server sends broadcast options
+// back to all clients including the sender.
+type RemoteExpr struct {
+	expr Expr
+}
+
+func (e *RemoteExpr) String() string { return e.expr.String() }
+
+func (e *RemoteExpr) eval(app *App, args []string) {
+	quiet := app.quiet
+	app.quiet = true
+	e.expr.eval(app, args)
+	app.quiet = quiet
+}
+
+func sendRemote(cmd string) error {
+	c, err := net.Dial("unix", gSocketPath)
+	if err != nil {
+		return fmt.Errorf("dialing to send command: %s", err)
+	}
+	defer c.Close()
+
+	fmt.Fprintln(c, cmd)
+
+	return nil
+}
+
+// Startup is used to measure the time spent in each step of the startup when
+// '-startuptime' flag is given. Timings are relative to the start of the
+// process and written to the given file once the ui is drawn.
+type Startup struct {
+	buf  bytes.Buffer
+	last time.Time
+}
+
+func newStartup() *Startup {
+	return &Startup{last: gStartTime}
+}
+
+func (st *Startup) mark(event string) {
+	if gStartupPath == "" {
+		return
+	}
+
+	now := time.Now()
+	fmt.Fprintf(&st.buf, "%8.3fms %8.3fms: %s\n", msecs(now.Sub(gStartTime)), msecs(now.Sub(st.last)), event)
+	st.last = now
+}
+
+func (st *Startup) write() {
+	if gStartupPath == "" {
+		return
+	}
+
+	if err := ioutil.WriteFile(gStartupPath, st.buf.Bytes(), 0644); err != nil {
+		log.Printf("writing startup time file: %s", err)
+	}
+}
+
+func msecs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
 func saveFiles(list []string, keep bool) error {
 	c, err := net.Dial("unix", gSocketPath)
 	if err != nil {
@@ -114,3 +331,44 @@ func loadFiles() (list []string, keep bool, err error) {
 
 	return
 }
+
+func saveMarkList(list []string) error {
+	c, err := net.Dial("unix", gSocketPath)
+	if err != nil {
+		return fmt.Errorf("dialing to save marks: %s", err)
+	}
+	defer c.Close()
+
+	log.Printf("saving marks: %v", list)
+
+	fmt.Fprintln(c, "save-marks")
+
+	for _, m := range list {
+		fmt.Fprintln(c, m)
+	}
+
+	return nil
+}
+
+func loadMarkList() (list []string, err error) {
+	c, err := net.Dial("unix", gSocketPath)
+	if err != nil {
+		return nil, fmt.Errorf("dialing to load marks: %s", err)
+	}
+	defer c.Close()
+
+	fmt.Fprintln(c, "load-marks")
+
+	s := bufio.NewScanner(c)
+	for s.Scan() {
+		list = append(list, s.Text())
+	}
+
+	if s.Err() != nil {
+		return nil, fmt.Errorf("scanning mark list: %s", s.Err())
+	}
+
+	log.Printf("loading marks: %v", list)
+
+	return list, nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Style is the foreground and background attributes used to draw a file.
+type Style struct {
+	fg termbox.Attribute
+	bg termbox.Attribute
+}
+
+// ColorMap is used to keep the styles of files shown in panes. Keys are the
+// same as in 'LS_COLORS', either file types (e.g. 'di' for directories) or
+// patterns matching the end of file names (e.g. '*.go'). Styles of the theme
+// given in 'theme' option are overridden with 'LS_COLORS' and 'LF_COLORS'
+// environment variables in order and lastly with the file given in 'colors'
+// option. Styles of ui elements are kept with keys 'user' and 'path' for the
+// header, 'ind' for indicators (e.g. filters), 'err' for invalid input,
+// 'mark' for marked files which otherwise use 'markcolor' option and 'visual'
+// for the range in visual mode.
+type ColorMap map[string]Style
+
+var gColors ColorMap
+
+// Built-in themes to be set with 'theme' option.
+var gThemes = map[string]func() ColorMap{
+	"default": defaultColors,
+	"light":   lightColors,
+	"mono":    monoColors,
+}
+
+func defaultColors() ColorMap {
+	return ColorMap{
+		"fi":     {termbox.ColorDefault, termbox.ColorDefault},
+		"di":     {termbox.AttrBold | termbox.ColorBlue, termbox.ColorDefault},
+		"ln":     {termbox.ColorCyan, termbox.ColorDefault},
+		"ex":     {termbox.AttrBold | termbox.ColorGreen, termbox.ColorDefault},
+		"pi":     {termbox.ColorRed, termbox.ColorDefault},
+		"so":     {termbox.ColorYellow, termbox.ColorDefault},
+		"bd":     {termbox.ColorWhite, termbox.ColorDefault},
+		"cd":     {termbox.ColorWhite, termbox.ColorDefault},
+		"user":   {termbox.AttrBold | termbox.ColorGreen, termbox.ColorDefault},
+		"path":   {termbox.AttrBold | termbox.ColorBlue, termbox.ColorDefault},
+		"ind":    {termbox.ColorYellow, termbox.ColorDefault},
+		"err":    {termbox.ColorRed, termbox.ColorDefault},
+		"visual": {termbox.ColorDefault, termbox.ColorBlue},
+	}
+}
+
+// This theme avoids yellow and white which are hard to read on light
+// backgrounds.
+func lightColors() ColorMap {
+	return ColorMap{
+		"fi":     {termbox.ColorDefault, termbox.ColorDefault},
+		"di":     {termbox.AttrBold | termbox.ColorBlue, termbox.ColorDefault},
+		"ln":     {termbox.ColorMagenta, termbox.ColorDefault},
+		"ex":     {termbox.AttrBold | termbox.ColorGreen, termbox.ColorDefault},
+		"pi":     {termbox.ColorRed, termbox.ColorDefault},
+		"so":     {termbox.ColorRed, termbox.ColorDefault},
+		"bd":     {termbox.ColorBlack, termbox.ColorDefault},
+		"cd":     {termbox.ColorBlack, termbox.ColorDefault},
+		"user":   {termbox.AttrBold | termbox.ColorGreen, termbox.ColorDefault},
+		"path":   {termbox.AttrBold | termbox.ColorBlue, termbox.ColorDefault},
+		"ind":    {termbox.ColorMagenta, termbox.ColorDefault},
+		"err":    {termbox.ColorRed, termbox.ColorDefault},
+		"visual": {termbox.ColorDefault, termbox.ColorCyan},
+	}
+}
+
+// This theme uses attributes only instead of colors for color blind users and
+// terminals with unusual palettes. Colors in previews are dropped as well.
+func monoColors() ColorMap {
+	return ColorMap{
+		"fi":     {termbox.ColorDefault, termbox.ColorDefault},
+		"di":     {termbox.AttrBold, termbox.ColorDefault},
+		"ln":     {termbox.AttrUnderline, termbox.ColorDefault},
+		"ex":     {termbox.AttrBold | termbox.AttrUnderline, termbox.ColorDefault},
+		"pi":     {termbox.ColorDefault, termbox.ColorDefault},
+		"so":     {termbox.ColorDefault, termbox.ColorDefault},
+		"bd":     {termbox.ColorDefault, termbox.ColorDefault},
+		"cd":     {termbox.ColorDefault, termbox.ColorDefault},
+		"user":   {termbox.AttrBold, termbox.ColorDefault},
+		"path":   {termbox.AttrBold, termbox.ColorDefault},
+		"ind":    {termbox.AttrUnderline, termbox.ColorDefault},
+		"err":    {termbox.AttrBold | termbox.AttrUnderline, termbox.ColorDefault},
+		"mark":   {termbox.AttrReverse, termbox.ColorDefault},
+		"visual": {termbox.AttrUnderline, termbox.ColorDefault},
+	}
+}
+
+// Colors are loaded on first use and loaded again after 'colors' or 'theme'
+// options are changed. 'LS_COLORS' is ignored with 'mono' theme since it is
+// often set system wide.
+func getColors() ColorMap {
+	if gColors == nil {
+		gColors = gThemes[gOpts.theme]()
+		if gOpts.theme != "mono" {
+			gColors.parseEnv(os.Getenv("LS_COLORS"))
+		}
+		gColors.parseEnv(os.Getenv("LF_COLORS"))
+		if gOpts.colors != "" {
+			gColors.load(gOpts.colors)
+		}
+	}
+	return gColors
+}
+
+// This function returns the style of the given ui element or the default
+// style when the theme does not have one.
+func (cm ColorMap) ui(key string) Style {
+	if st, ok := cm[key]; ok {
+		return st
+	}
+	return Style{termbox.ColorDefault, termbox.ColorDefault}
+}
+
+// This function returns the given style without colors keeping attributes
+// such as bold or underline. It is used for previews with 'mono' theme.
+func (st Style) attrsOnly() Style {
+	return Style{st.fg &^ 0x1FF, st.bg &^ 0x1FF}
+}
+
+// This function parses the styles in 'LS_COLORS' format (e.g.
+// 'di=01;34:*.go=32'). Entries with invalid codes are skipped.
+func (cm ColorMap) parseEnv(env string) {
+	for _, entry := range strings.Split(env, ":") {
+		toks := strings.SplitN(entry, "=", 2)
+		if len(toks) != 2 || toks[0] == "" {
+			continue
+		}
+
+		st, err := parseStyle(toks[1])
+		if err != nil {
+			log.Printf("invalid color entry: %s", entry)
+			continue
+		}
+
+		cm[toks[0]] = st
+	}
+}
+
+// This function loads styles from the given file with lines consisting of a
+// key and codes seperated with whitespace (e.g. 'di 01;34').
+func (cm ColorMap) load(filename string) {
+	if strings.HasPrefix(filename, "~") {
+		filename = envHome + filename[1:]
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Printf("opening colors file: %s", err)
+		return
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		toks := strings.Fields(line)
+		if len(toks) != 2 {
+			log.Printf("invalid line in colors file: %s", line)
+			continue
+		}
+
+		st, err := parseStyle(toks[1])
+		if err != nil {
+			log.Printf("invalid line in colors file: %s", line)
+			continue
+		}
+
+		cm[toks[0]] = st
+	}
+
+	if s.Err() != nil {
+		log.Printf("reading colors file: %s", s.Err())
+	}
+}
+
+// This function converts select graphic rendition codes (e.g. '01;34') to
+// termbox attributes. Only the eight basic colors are supported so that
+// bright and 256 colors are mapped to the closest basic color.
+func parseStyle(codes string) (Style, error) {
+	return applySGR(Style{termbox.ColorDefault, termbox.ColorDefault}, codes)
+}
+
+// This function applies the given select graphic rendition codes on top of
+// the given style. It is also used for escape sequences in previewer outputs
+// where each sequence changes the style set by the earlier ones.
+func applySGR(st Style, codes string) (Style, error) {
+	toks := strings.Split(codes, ";")
+
+	for i := 0; i < len(toks); i++ {
+		if toks[i] == "" {
+			if len(toks) == 1 {
+				st = Style{termbox.ColorDefault, termbox.ColorDefault}
+			}
+			continue
+		}
+
+		n, err := strconv.Atoi(toks[i])
+		if err != nil {
+			return st, err
+		}
+
+		switch {
+		case n == 0:
+			st = Style{termbox.ColorDefault, termbox.ColorDefault}
+		case n == 1:
+			st.fg |= termbox.AttrBold
+		case n == 4:
+			st.fg |= termbox.AttrUnderline
+		case n == 7:
+			st.fg |= termbox.AttrReverse
+		case n >= 30 && n <= 37:
+			st.fg = withColor(st.fg, n-30)
+		case n >= 40 && n <= 47:
+			st.bg = termbox.Attribute(n - 40 + 1)
+		case n >= 90 && n <= 97:
+			st.fg = withColor(st.fg, n-90)
+		case n >= 100 && n <= 107:
+			st.bg = termbox.Attribute(n - 100 + 1)
+		case (n == 38 || n == 48) && i+2 < len(toks) && toks[i+1] == "5":
+			c, err := strconv.Atoi(toks[i+2])
+			if err != nil {
+				return st, err
+			}
+			i += 2
+			if c >= 16 {
+				continue
+			}
+			if n == 38 {
+				st.fg = withColor(st.fg, c%8)
+			} else {
+				st.bg = termbox.Attribute(c%8 + 1)
+			}
+		}
+	}
+
+	return st, nil
+}
+
+// This function replaces the color in the given attribute with the basic color
+// of the given index (e.g. 4 for blue) keeping the other attributes.
+func withColor(attr termbox.Attribute, c int) termbox.Attribute {
+	return attr&^0x1FF | termbox.Attribute(c+1)
+}
+
+// This function returns the style of the given file. Special files and
+// permissions (e.g. setuid or sticky directories) are checked first as in
+// 'ls' and patterns are only used for regular files without special styles.
+func (cm ColorMap) get(f os.FileInfo) Style {
+	mode := f.Mode()
+
+	var key string
+
+	switch {
+	case mode&os.ModeSymlink != 0:
+		key = "ln"
+	case mode&os.ModeNamedPipe != 0:
+		key = "pi"
+	case mode&os.ModeSocket != 0:
+		key = "so"
+	case mode&os.ModeCharDevice != 0:
+		key = "cd"
+	case mode&os.ModeDevice != 0:
+		key = "bd"
+	case mode.IsDir():
+		switch {
+		case mode&os.ModeSticky != 0 && mode&0002 != 0:
+			key = "tw"
+		case mode&0002 != 0:
+			key = "ow"
+		case mode&os.ModeSticky != 0:
+			key = "st"
+		}
+		if _, ok := cm[key]; !ok {
+			key = "di"
+		}
+	default:
+		switch {
+		case mode&os.ModeSetuid != 0:
+			key = "su"
+		case mode&os.ModeSetgid != 0:
+			key = "sg"
+		case mode&0111 != 0:
+			key = "ex"
+		}
+		if _, ok := cm[key]; !ok {
+			key = cm.match(f.Name())
+		}
+	}
+
+	if st, ok := cm[key]; ok {
+		return st
+	}
+
+	return cm["fi"]
+}
+
+// This function returns the longest pattern matching the end of the given
+// name or 'fi' when there is no match.
+func (cm ColorMap) match(name string) string {
+	key := "fi"
+	n := 0
+
+	for k := range cm {
+		if strings.HasPrefix(k, "*") && len(k) > n && strings.HasSuffix(name, k[1:]) {
+			key, n = k, len(k)
+		}
+	}
+
+	return key
+}
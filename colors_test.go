@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
+
+func TestParseStyle(t *testing.T) {
+	def := termbox.ColorDefault
+
+	tests := []struct {
+		codes string
+		st    Style
+	}{
+		{"", Style{def, def}},
+		{"0", Style{def, def}},
+		{"01;34", Style{termbox.AttrBold | termbox.ColorBlue, def}},
+		{"32", Style{termbox.ColorGreen, def}},
+		{"30;41", Style{termbox.ColorBlack, termbox.ColorRed}},
+		{"1;4;93", Style{termbox.AttrBold | termbox.AttrUnderline | termbox.ColorYellow, def}},
+		{"38;5;4", Style{termbox.ColorBlue, def}},
+		{"38;5;208;1", Style{termbox.AttrBold, def}},
+		{"1;0;35", Style{termbox.ColorMagenta, def}},
+	}
+
+	for _, test := range tests {
+		st, err := parseStyle(test.codes)
+		if err != nil {
+			t.Errorf("at input '%s' expected '%v' but got error '%s'", test.codes, test.st, err)
+			continue
+		}
+		if st != test.st {
+			t.Errorf("at input '%s' expected '%v' but got '%v'", test.codes, test.st, st)
+		}
+	}
+
+	if _, err := parseStyle("01;xx"); err == nil {
+		t.Errorf("at input '01;xx' expected an error")
+	}
+}
+
+func TestColorMapMatch(t *testing.T) {
+	cm := ColorMap{}
+	cm.parseEnv("di=01;34:*.gz=31:*.tar.gz=32:*README=33:invalid:ex=x")
+
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"foo.gz", "*.gz"},
+		{"foo.tar.gz", "*.tar.gz"},
+		{"README", "*README"},
+		{"foo.txt", "fi"},
+	}
+
+	for _, test := range tests {
+		if key := cm.match(test.name); key != test.key {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", test.name, test.key, key)
+		}
+	}
+
+	if _, ok := cm["ex"]; ok {
+		t.Errorf("at input 'ex=x' expected entry to be skipped")
+	}
+}
+
+func TestThemes(t *testing.T) {
+	keys := []string{"fi", "di", "ln", "ex", "pi", "so", "bd", "cd", "user", "path", "ind", "err"}
+
+	for name, theme := range gThemes {
+		cm := theme()
+		for _, key := range keys {
+			if _, ok := cm[key]; !ok {
+				t.Errorf("at theme '%s' expected style for '%s'", name, key)
+			}
+		}
+	}
+
+	for key, st := range monoColors() {
+		if st.fg&0x1FF != 0 || st.bg&0x1FF != 0 {
+			t.Errorf("at theme 'mono' expected no colors for '%s' but got '%v'", key, st)
+		}
+	}
+
+	st := Style{termbox.AttrBold | termbox.ColorRed, termbox.ColorBlue}.attrsOnly()
+	if exp := (Style{termbox.AttrBold, termbox.ColorDefault}); st != exp {
+		t.Errorf("at dropping colors expected '%v' but got '%v'", exp, st)
+	}
+}
@@ -5,24 +5,97 @@ import (
 	"log"
 	"os"
 	"path"
+	"sort"
 	"strings"
 )
 
 var (
-	gCmdWords = []string{"set", "map", "cmd"}
+	gCmdWords = []string{"set", "map", "remap", "noremap", "cmd", "cmddir", "opener", "previewer"}
 	gOptWords = []string{
+		"all",
+		"autopanes",
+		"noautopanes",
+		"autopanes!",
+		"broadcast",
+		"nobroadcast",
+		"broadcast!",
 		"preview",
 		"nopreview",
 		"preview!",
+		"readonly",
+		"noreadonly",
+		"readonly!",
+		"sharemarks",
+		"nosharemarks",
+		"sharemarks!",
+		"sequential",
+		"nosequential",
+		"sequential!",
+		"reverse",
+		"noreverse",
+		"reverse!",
+		"dirfirst",
+		"nodirfirst",
+		"dirfirst!",
+		"ignorecase",
+		"noignorecase",
+		"ignorecase!",
+		"smartcase",
+		"nosmartcase",
+		"smartcase!",
+		"mouse",
+		"nomouse",
+		"mouse!",
+		"altkeys",
+		"noaltkeys",
+		"altkeys!",
+		"screenreader",
+		"noscreenreader",
+		"screenreader!",
 		"hidden",
 		"nohidden",
 		"hidden!",
+		"icons",
+		"noicons",
+		"icons!",
 		"tabstop",
+		"pwdmode",
+		"nested",
+		"markchar",
+		"theme",
+		"timefmt",
+		"infotimefmt",
+		"decimalsep",
+		"markmode",
+		"bell",
+		"markcolor",
 		"scrolloff",
+		"namewidth",
+		"msgtimeout",
 		"sortby",
-		"showinfo",
+		"info",
+		"escalate",
 		"opener",
+		"oplog",
+		"terminal",
+		"clipboard",
+		"cleaner",
+		"announce",
+		"imagepreview",
+		"colors",
+		"previewer",
+		"cachedir",
+		"cachesize",
+		"clipsize",
+		"treedepth",
+		"flattendepth",
+		"confirmdelete",
+		"confirmpaste",
+		"history",
 		"ratios",
+		"hiddenfiles",
+		"protect",
+		"ruler",
 	}
 )
 
@@ -36,11 +109,16 @@ func matchLongest(s1, s2 string) string {
 	return s1[:i]
 }
 
-func matchWord(s string, words []string) string {
+// Match functions return the longest common prefix of the matching words
+// along with the list of all matching words. When there is a single match, a
+// trailing space is added to the match to start the next word.
+func matchWord(s string, words []string) (string, []string) {
 	var match string
+	var cands []string
 
 	for _, w := range words {
 		if strings.HasPrefix(w, s) {
+			cands = append(cands, w)
 			if match != "" {
 				match = matchLongest(match, w)
 			} else {
@@ -50,14 +128,15 @@ func matchWord(s string, words []string) string {
 	}
 
 	if match != "" {
-		return match
+		return match, cands
 	}
 
-	return s
+	return s, cands
 }
 
-func matchExec(s string) string {
+func matchExec(s string) (string, []string) {
 	var match string
+	var cands []string
 
 	paths := strings.Split(envPath, ":")
 
@@ -76,11 +155,13 @@ func matchExec(s string) string {
 				f, err = os.Stat(path.Join(p, f.Name()))
 				if err != nil {
 					log.Printf("getting file information: %s", err)
+					continue
 				}
 
 				if !f.Mode().IsRegular() || f.Mode()&0111 == 0 {
 					continue
 				}
+				cands = append(cands, f.Name())
 				if match != "" {
 					match = matchLongest(match, f.Name())
 				} else {
@@ -91,14 +172,15 @@ func matchExec(s string) string {
 	}
 
 	if match != "" {
-		return match
+		return match, cands
 	}
 
-	return s
+	return s, cands
 }
 
-func matchFile(s string) string {
+func matchFile(s string) (string, []string) {
 	var match string
+	var cands []string
 
 	wd, err := os.Getwd()
 	if err != nil {
@@ -112,6 +194,7 @@ func matchFile(s string) string {
 
 	for _, f := range fi {
 		if strings.HasPrefix(f.Name(), s) {
+			cands = append(cands, f.Name())
 			if match != "" {
 				match = matchLongest(match, f.Name())
 			} else {
@@ -121,15 +204,94 @@ func matchFile(s string) string {
 	}
 
 	if match != "" {
-		return match
+		return match, cands
 	}
 
-	return s
+	return s, cands
 }
 
-func compCmd(acc []rune) []rune {
+// This function matches directories for the given path. Unlike other match
+// functions, candidates are full paths ending with a slash so that a single
+// match can be followed with the next directory. Given recent directories
+// matching the path are listed before the others.
+func matchDir(s string, recents []string) (string, []string) {
+	var match string
+	var cands []string
+
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		cands = append(cands, name)
+		if match != "" {
+			match = matchLongest(match, name)
+		} else {
+			match = name
+		}
+	}
+
+	for _, r := range recents {
+		if r = r + "/"; strings.HasPrefix(r, s) {
+			add(r)
+		}
+	}
+
+	dir, base := path.Split(s)
+
+	p := dir
+	if strings.HasPrefix(p, "~") {
+		p = envHome + p[1:]
+	}
+	if p == "" {
+		p = "."
+	}
+
+	fi, err := ioutil.ReadDir(p)
+	if err != nil {
+		log.Printf("reading directory: %s", err)
+	}
+
+	for _, f := range fi {
+		if !strings.HasPrefix(f.Name(), base) {
+			continue
+		}
+
+		if f.Mode()&os.ModeSymlink != 0 {
+			if f, err = os.Stat(path.Join(p, f.Name())); err != nil {
+				continue
+			}
+		}
+
+		if !f.IsDir() {
+			continue
+		}
+
+		add(dir + f.Name() + "/")
+	}
+
+	if match != "" {
+		return match, cands
+	}
+
+	return s, cands
+}
+
+// This function returns the names of custom commands in sorted order so that
+// completion candidates are listed in the same order each time.
+func cmdNames() []string {
+	var names []string
+	for c := range gOpts.cmds {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func compCmd(acc []rune) ([]rune, []string) {
 	if len(acc) == 0 || acc[len(acc)-1] == ' ' {
-		return acc
+		return acc, nil
 	}
 
 	s := string(acc)
@@ -138,37 +300,38 @@ func compCmd(acc []rune) []rune {
 	switch len(f) {
 	case 0: // do nothing
 	case 1:
-		words := gCmdWords
-		for c, _ := range gOpts.cmds {
-			words = append(words, c)
-		}
-		return []rune(matchWord(s, words))
+		words := append([]string(nil), gCmdWords...)
+		words = append(words, cmdNames()...)
+		match, cands := matchWord(s, words)
+		return []rune(match), cands
 	default:
 		switch f[0] {
 		case "set":
-			opt := matchWord(f[1], gOptWords)
+			opt, cands := matchWord(f[1], gOptWords)
 			ret := []rune(f[0])
 			ret = append(ret, ' ')
 			ret = append(ret, []rune(opt)...)
-			return ret
+			return ret, cands
 		case "map", "cmd": // do nothing
 		default:
+			var cands []string
 			ret := []rune(f[0])
 			ret = append(ret, ' ')
 			for i := 1; i < len(f); i++ {
-				name := matchFile(f[i])
+				var name string
+				name, cands = matchFile(f[i])
 				ret = append(ret, []rune(name)...)
 			}
-			return ret
+			return ret, cands
 		}
 	}
 
-	return acc
+	return acc, nil
 }
 
-func compShell(acc []rune) []rune {
+func compShell(acc []rune) ([]rune, []string) {
 	if len(acc) == 0 || acc[len(acc)-1] == ' ' {
-		return acc
+		return acc, nil
 	}
 
 	s := string(acc)
@@ -177,16 +340,24 @@ func compShell(acc []rune) []rune {
 	switch len(f) {
 	case 0: // do nothing
 	case 1:
-		return []rune(matchExec(s))
+		match, cands := matchExec(s)
+		return []rune(match), cands
 	default:
+		var cands []string
 		ret := []rune(f[0])
 		ret = append(ret, ' ')
 		for i := 1; i < len(f); i++ {
-			name := matchFile(f[i])
+			var name string
+			name, cands = matchFile(f[i])
 			ret = append(ret, []rune(name)...)
 		}
-		return ret
+		return ret, cands
 	}
 
-	return acc
+	return acc, nil
+}
+
+func compDir(acc []rune) ([]rune, []string) {
+	match, cands := matchDir(string(acc), gRecents)
+	return []rune(match), cands
 }
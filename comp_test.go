@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestMatchDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, d := range []string{"foo", "foobar", "baz"} {
+		if err := os.Mkdir(path.Join(tmp, d), 0755); err != nil {
+			t.Fatalf("creating directory: %s", err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(tmp, "file"), nil, 0644); err != nil {
+		t.Fatalf("creating file: %s", err)
+	}
+
+	tests := []struct {
+		s       string
+		recents []string
+		match   string
+		cands   []string
+	}{
+		{tmp + "/b", nil, tmp + "/baz/", []string{tmp + "/baz/"}},
+		{tmp + "/f", nil, tmp + "/foo", []string{tmp + "/foo/", tmp + "/foobar/"}},
+		{tmp + "/fi", nil, tmp + "/fi", nil},
+		{tmp + "/f", []string{tmp + "/foobar", "/other"}, tmp + "/foo", []string{tmp + "/foobar/", tmp + "/foo/"}},
+	}
+
+	for _, test := range tests {
+		match, cands := matchDir(test.s, test.recents)
+		if match != test.match || !reflect.DeepEqual(cands, test.cands) {
+			t.Errorf("at input '%s' expected '%s' '%v' but got '%s' '%v'", test.s, test.match, test.cands, match, cands)
+		}
+	}
+}
+
+func TestCompCmd(t *testing.T) {
+	defer func(o Opts) { gOpts = o }(gOpts)
+	gOpts.cmds = map[string]Expr{
+		"trash":   &ExecExpr{"$", "mv $fx ~/.trash", false},
+		"tree":    &ExecExpr{"!", "tree", false},
+		"extract": &ExecExpr{"$", "tar xf $f", false},
+	}
+
+	tests := []struct {
+		s     string
+		match string
+		cands []string
+	}{
+		{"tr", "tr", []string{"trash", "tree"}},
+		{"tra", "trash ", []string{"trash"}},
+		{"ex", "extract ", []string{"extract"}},
+		{"cm", "cmd", []string{"cmd", "cmddir"}},
+	}
+
+	for _, test := range tests {
+		match, cands := compCmd([]rune(test.s))
+		if string(match) != test.match || !reflect.DeepEqual(cands, test.cands) {
+			t.Errorf("at input '%s' expected '%s' '%v' but got '%s' '%v'", test.s, test.match, test.cands, string(match), cands)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"syscall"
+)
+
+// Returned by file operations stopped with 'paste-cancel'.
+var errCanceled = errors.New("canceled")
+
+// Size of the buffer used to copy files. Cancellation is checked and progress
+// is updated after each chunk.
+const gCopyBufSize = 32 * 1024
+
+// This function reports whether the given channel is closed to cancel the
+// operation. A nil channel is never closed.
+func canceled(quit <-chan struct{}) bool {
+	select {
+	case <-quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// This function copies the contents of the given regular file to the
+// destination path with the same permissions. Number of bytes written is added
+// to the given counter unless it is nil.
+func copyFile(src, dst string, mode os.FileMode, written *int64, quit <-chan struct{}) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, gCopyBufSize)
+
+	for {
+		if canceled(quit) {
+			w.Close()
+			os.Remove(dst)
+			return errCanceled
+		}
+
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				w.Close()
+				return err
+			}
+			if written != nil {
+				atomic.AddInt64(written, int64(n))
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	return w.Close()
+}
+
+// This function copies the given file to the destination path. Directories
+// are copied recursively and links are copied as links. Copying stops with
+// 'errCanceled' when the quit channel is closed.
+func copyPath(src, dst string, written *int64, quit <-chan struct{}) error {
+	f, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	switch mode := f.Mode(); {
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	case mode.IsDir():
+		// permissions are set after the contents are copied since read-only
+		// directories can not be filled otherwise
+		if err := os.MkdirAll(dst, 0700); err != nil {
+			return err
+		}
+
+		fi, err := ioutil.ReadDir(src)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range fi {
+			if err := copyPath(path.Join(src, f.Name()), path.Join(dst, f.Name()), written, quit); err != nil {
+				return err
+			}
+		}
+
+		return os.Chmod(dst, mode.Perm())
+	case mode.IsRegular():
+		return copyFile(src, dst, mode, written, quit)
+	default:
+		return fmt.Errorf("cannot copy special file: %s", src)
+	}
+}
+
+// This function moves the given file to the destination path. Files are
+// renamed when possible and otherwise (i.e. across file systems) they are
+// copied and removed afterwards. Renamed files are counted as written all at
+// once.
+func movePath(src, dst string, written *int64, quit <-chan struct{}) error {
+	size := diskUsage(src)
+
+	err := os.Rename(src, dst)
+	if err == nil {
+		if written != nil {
+			atomic.AddInt64(written, size)
+		}
+		return nil
+	}
+
+	if e, ok := err.(*os.LinkError); !ok || e.Err != syscall.EXDEV {
+		return err
+	}
+
+	if err := copyPath(src, dst, written, quit); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(src)
+}
+
+// This function removes the given file and the files under it when it is a
+// directory. Size of each removed file is added to the given counter.
+// Removing stops with 'errCanceled' when the quit channel is closed, leaving
+// the files not yet removed in place.
+func removePath(name string, removed *int64, quit <-chan struct{}) error {
+	if canceled(quit) {
+		return errCanceled
+	}
+
+	f, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+
+	if f.IsDir() {
+		fi, err := ioutil.ReadDir(name)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range fi {
+			if err := removePath(path.Join(name, f.Name()), removed, quit); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+
+	if removed != nil {
+		atomic.AddInt64(removed, f.Size())
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestTransfer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, dst := path.Join(dir, "src"), path.Join(dir, "dst")
+	for _, d := range []string{src, path.Join(src, "sub"), dst} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("creating directory: %s", err)
+		}
+	}
+
+	files := map[string]string{
+		path.Join(src, "foo"):        "foo",
+		path.Join(src, "sub", "bar"): "foobar",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("writing file: %s", err)
+		}
+	}
+
+	var written int64
+	list := []string{path.Join(src, "foo"), path.Join(src, "sub")}
+	if err := transfer(list, dst, true, JobOpts{}, &written, nil, nil); err != nil {
+		t.Fatalf("copying files: %s", err)
+	}
+
+	if written != 9 {
+		t.Errorf("at copy expected '9' bytes written but got '%d'", written)
+	}
+
+	for name, data := range files {
+		copied := path.Join(dst, name[len(src):])
+		if b, err := ioutil.ReadFile(copied); err != nil || string(b) != data {
+			t.Errorf("at file '%s' expected '%s' but got '%s' (%v)", copied, data, b, err)
+		}
+	}
+
+	tests := []struct {
+		list []string
+		dst  string
+		keep bool
+	}{
+		{[]string{path.Join(src, "foo")}, src, true},
+		{[]string{src}, path.Join(src, "sub"), true},
+		{[]string{src}, path.Join(src, "sub"), false},
+	}
+
+	for _, test := range tests {
+		if err := transfer(test.list, test.dst, test.keep, JobOpts{}, nil, nil, nil); err == nil {
+			t.Errorf("at input '%v' to '%s' expected an error but got none", test.list, test.dst)
+		}
+	}
+
+	// jobs use the patterns copied when they are started
+	if err := remove([]string{dst}, JobOpts{protect: []string{"dst"}}, nil, nil, nil); err == nil {
+		t.Errorf("at protected delete expected an error but got none")
+	}
+
+	quit := make(chan struct{})
+	close(quit)
+
+	if err := remove([]string{dst}, JobOpts{}, nil, quit, nil); err != errCanceled {
+		t.Errorf("at canceled delete expected '%s' but got '%v'", errCanceled, err)
+	}
+
+	if err := remove([]string{dst}, JobOpts{}, nil, nil, nil); err != nil {
+		t.Errorf("at delete expected no error but got '%s'", err)
+	}
+
+	if _, err := os.Lstat(dst); !os.IsNotExist(err) {
+		t.Errorf("at delete expected '%s' to be removed", dst)
+	}
+}
+
+func TestCopyReadOnlyDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, dst := path.Join(dir, "src"), path.Join(dir, "dst")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatalf("creating directory: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(src, "foo"), []byte("foo"), 0644); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+	if err := os.Chmod(src, 0555); err != nil {
+		t.Fatalf("changing mode: %s", err)
+	}
+
+	// directories are made writable again so that they can be cleaned up
+	defer os.Chmod(src, 0755)
+	defer os.Chmod(dst, 0755)
+
+	if err := copyPath(src, dst, nil, nil); err != nil {
+		t.Fatalf("at read-only directory expected no error but got '%s'", err)
+	}
+
+	if b, err := ioutil.ReadFile(path.Join(dst, "foo")); err != nil || string(b) != "foo" {
+		t.Errorf("at read-only directory expected 'foo' but got '%s' (%v)", b, err)
+	}
+
+	if f, err := os.Stat(dst); err != nil {
+		t.Errorf("at read-only directory expected no error but got '%s'", err)
+	} else if f.Mode().Perm() != 0555 {
+		t.Errorf("at read-only directory expected mode '0555' but got '%v'", f.Mode().Perm())
+	}
+}
+
+func TestTransferOpLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, dst := path.Join(dir, "foo"), path.Join(dir, "dst")
+	if err := os.Mkdir(dst, 0755); err != nil {
+		t.Fatalf("creating directory: %s", err)
+	}
+	if err := ioutil.WriteFile(src, []byte("foo"), 0644); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+
+	// jobs use the option copied when they are started
+	oplog := path.Join(dir, "oplog")
+	if err := transfer([]string{src}, dst, true, JobOpts{oplog: oplog}, nil, nil, nil); err != nil {
+		t.Fatalf("copying files: %s", err)
+	}
+
+	var rec OpRecord
+	b, err := ioutil.ReadFile(oplog)
+	if err != nil || json.Unmarshal(b, &rec) != nil {
+		t.Fatalf("reading operation log: %s (%v)", b, err)
+	}
+	if rec.Op != "copy" || rec.Src != src || rec.Dst != path.Join(dst, "foo") || rec.Result != "ok" {
+		t.Errorf("at copy expected a copy of '%s' but got '%+v'", src, rec)
+	}
+}
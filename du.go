@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Recursive sizes of directories calculated in the background for disk usage
+// view. The main loop is notified with 'gDirSizeChan' when a size is ready so
+// that the entries are sorted again. Sizes are kept until disk usage view is
+// turned on again for the directory containing them.
+var (
+	gDirSizes       = make(map[string]int64)
+	gDirSizePending = make(map[string]bool)
+	gDirSizeMutex   sync.Mutex
+	gDirSizeChan    = make(chan string, 16)
+)
+
+// Number of directories walked at the same time. Others wait for their turn
+// so that large directories do not start a walk for each subdirectory at once.
+const gDirSizeWorkers = 4
+
+var gDirSizeSem = make(chan struct{}, gDirSizeWorkers)
+
+// Width of the bar graphs drawn in disk usage view.
+const gUsageBarWidth = 10
+
+// This function returns the size of the given entry counting the contents of
+// directories recursively as in 'diskUsage'. Sizes of directories are
+// calculated in the background and false is returned until they are ready.
+func asyncUsage(p string, f os.FileInfo) (int64, bool) {
+	if !f.IsDir() {
+		return f.Size(), true
+	}
+
+	gDirSizeMutex.Lock()
+	defer gDirSizeMutex.Unlock()
+
+	if size, ok := gDirSizes[p]; ok {
+		return size, true
+	}
+
+	if gDirSizePending[p] {
+		return 0, false
+	}
+	gDirSizePending[p] = true
+
+	go func() {
+		gDirSizeSem <- struct{}{}
+		size := diskUsage(p)
+		<-gDirSizeSem
+
+		gDirSizeMutex.Lock()
+		gDirSizes[p] = size
+		delete(gDirSizePending, p)
+		gDirSizeMutex.Unlock()
+
+		gDirSizeChan <- p
+		screenInterrupt()
+	}()
+
+	return 0, false
+}
+
+// This function returns the given entries sorted by their sizes with the
+// largest first. Directories still being calculated are listed at the end in
+// their original order.
+func (dir *Dir) usageSort(fi []os.FileInfo) []os.FileInfo {
+	sizes := make(map[string]int64, len(fi))
+	for _, f := range fi {
+		if size, ok := asyncUsage(path.Join(dir.path, f.Name()), f); ok {
+			sizes[f.Name()] = size
+		} else {
+			sizes[f.Name()] = -1
+		}
+	}
+
+	sort.SliceStable(fi, func(i, j int) bool {
+		return sizes[fi[i].Name()] > sizes[fi[j].Name()]
+	})
+
+	return fi
+}
+
+// This function returns the size of the largest entry shown in disk usage
+// view which is used as the full length of bar graphs.
+func (dir *Dir) usageMax() int64 {
+	var largest int64
+	for _, f := range dir.fi {
+		if size, ok := asyncUsage(path.Join(dir.path, f.Name()), f); ok && size > largest {
+			largest = size
+		}
+	}
+	return largest
+}
+
+// This function returns the size column and the bar graph of the given entry
+// as drawn in disk usage view.
+func (dir *Dir) usageInfo(f os.FileInfo, largest int64) string {
+	size, ok := asyncUsage(path.Join(dir.path, f.Name()), f)
+	if !ok {
+		return fmt.Sprintf("%5s [%s] ", "...", strings.Repeat(" ", gUsageBarWidth))
+	}
+
+	var n int
+	if largest > 0 {
+		n = int(size * gUsageBarWidth / largest)
+	}
+
+	bar := strings.Repeat("#", n) + strings.Repeat(" ", gUsageBarWidth-n)
+
+	return fmt.Sprintf("%5s [%s] ", humanize(size), bar)
+}
+
+// This function turns disk usage view on or off for the current directory.
+// Sizes of subdirectories are calculated again when it is turned on so that
+// changes since the last time are shown.
+func (nav *Nav) toggleUsage() error {
+	dir := nav.currDir()
+
+	if _, _, ok := splitArchive(dir.path); ok {
+		return errors.New("not available in archives")
+	}
+
+	var name string
+	if len(dir.fi) != 0 {
+		name = strings.SplitN(dir.fi[dir.ind].Name(), "/", 2)[0]
+	}
+
+	dir.du = !dir.du
+	dir.tree = nil
+	dir.flat = false
+
+	if dir.du {
+		gDirSizeMutex.Lock()
+		for p := range gDirSizes {
+			if path.Dir(p) == dir.path {
+				delete(gDirSizes, p)
+			}
+		}
+		gDirSizeMutex.Unlock()
+	}
+
+	dir.renew(nav.height)
+	dir.load(dir.ind, dir.pos, nav.height, name)
+
+	return nil
+}
+
+// This function sorts the entries of the shown directories in disk usage
+// view again when the size of a subdirectory is calculated.
+func (nav *Nav) usageLoaded(p string) {
+	for _, dir := range nav.dirs {
+		if dir.du && !dir.loading && dir.path == path.Dir(p) {
+			dir.update(dir.expand(dir.all), nav.height)
+		}
+	}
+}
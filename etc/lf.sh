@@ -19,3 +19,9 @@ lf () {
     fi
     rm -f "$tmp"
 }
+
+# a simpler alternative without a temporary file:
+#
+# lf () {
+#     cd "$(command lf -print-last-dir "$@")"
+# }
@@ -1,30 +1,164 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
 )
 
+// This function returns whether the given option is sent to all clients when
+// 'broadcast' is set. Broadcast option itself is kept local to each client.
+func isBroadcast(opt string) bool {
+	switch opt {
+	case "all", "broadcast", "nobroadcast", "broadcast!":
+		return false
+	}
+	return true
+}
+
 func (e *SetExpr) eval(app *App, args []string) {
+	if gOpts.broadcast && !app.quiet && isBroadcast(e.opt) {
+		// evaluated when the server sends it back to all clients
+		err := sendRemote("send " + e.String())
+		if err == nil {
+			return
+		}
+		log.Printf("broadcasting option: %s", err)
+	}
+
 	switch e.opt {
+	case "all":
+		app.dumpOpts()
+	case "autopanes":
+		gOpts.autopanes = true
+		app.ui.renew()
+	case "noautopanes":
+		gOpts.autopanes = false
+		app.ui.renew()
+	case "autopanes!":
+		gOpts.autopanes = !gOpts.autopanes
+		app.ui.renew()
+	case "broadcast":
+		gOpts.broadcast = true
+	case "nobroadcast":
+		gOpts.broadcast = false
+	case "broadcast!":
+		gOpts.broadcast = !gOpts.broadcast
 	case "hidden":
 		gOpts.hidden = true
-		app.nav.renew(app.nav.height)
+		app.nav.reload()
 	case "nohidden":
 		gOpts.hidden = false
-		app.nav.renew(app.nav.height)
+		app.nav.reload()
 	case "hidden!":
 		gOpts.hidden = !gOpts.hidden
-		app.nav.renew(app.nav.height)
+		app.nav.reload()
+	case "icons":
+		gOpts.icons = true
+	case "noicons":
+		gOpts.icons = false
+	case "icons!":
+		gOpts.icons = !gOpts.icons
+	case "reverse":
+		gOpts.reverse = true
+		app.nav.reload()
+	case "noreverse":
+		gOpts.reverse = false
+		app.nav.reload()
+	case "reverse!":
+		gOpts.reverse = !gOpts.reverse
+		app.nav.reload()
+	case "dirfirst":
+		gOpts.dirfirst = true
+		app.nav.reload()
+	case "nodirfirst":
+		gOpts.dirfirst = false
+		app.nav.reload()
+	case "dirfirst!":
+		gOpts.dirfirst = !gOpts.dirfirst
+		app.nav.reload()
+	case "ignorecase":
+		gOpts.ignorecase = true
+	case "noignorecase":
+		gOpts.ignorecase = false
+	case "ignorecase!":
+		gOpts.ignorecase = !gOpts.ignorecase
+	case "smartcase":
+		gOpts.smartcase = true
+	case "nosmartcase":
+		gOpts.smartcase = false
+	case "smartcase!":
+		gOpts.smartcase = !gOpts.smartcase
+	case "mouse":
+		gOpts.mouse = true
+		screenSetInput(gOpts.mouse, gOpts.altkeys)
+	case "nomouse":
+		gOpts.mouse = false
+		screenSetInput(gOpts.mouse, gOpts.altkeys)
+	case "mouse!":
+		gOpts.mouse = !gOpts.mouse
+		screenSetInput(gOpts.mouse, gOpts.altkeys)
+	case "altkeys":
+		gOpts.altkeys = true
+		screenSetInput(gOpts.mouse, gOpts.altkeys)
+	case "noaltkeys":
+		gOpts.altkeys = false
+		screenSetInput(gOpts.mouse, gOpts.altkeys)
+	case "altkeys!":
+		gOpts.altkeys = !gOpts.altkeys
+		screenSetInput(gOpts.mouse, gOpts.altkeys)
+	case "screenreader":
+		gOpts.screenreader = true
+	case "noscreenreader":
+		gOpts.screenreader = false
+		screenHideCursor()
+	case "screenreader!":
+		gOpts.screenreader = !gOpts.screenreader
+		if !gOpts.screenreader {
+			screenHideCursor()
+		}
+	case "sequential":
+		gOpts.sequential = true
+	case "nosequential":
+		gOpts.sequential = false
+	case "sequential!":
+		gOpts.sequential = !gOpts.sequential
 	case "preview":
 		gOpts.preview = true
 	case "nopreview":
 		gOpts.preview = false
 	case "preview!":
 		gOpts.preview = !gOpts.preview
+	case "readonly":
+		gOpts.readonly = true
+	case "noreadonly", "readonly!":
+		if gReadonlyFlag {
+			msg := "readonly: can not be disabled when started with -readonly flag"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if e.opt == "noreadonly" {
+			gOpts.readonly = false
+		} else {
+			gOpts.readonly = !gOpts.readonly
+		}
+	case "sharemarks":
+		gOpts.sharemarks = true
+	case "nosharemarks":
+		gOpts.sharemarks = false
+	case "sharemarks!":
+		gOpts.sharemarks = !gOpts.sharemarks
 	case "scrolloff":
 		n, err := strconv.Atoi(e.val)
 		if err != nil {
@@ -45,6 +179,21 @@ func (e *SetExpr) eval(app *App, args []string) {
 			n = max
 		}
 		gOpts.scrolloff = n
+	case "namewidth":
+		n, err := strconv.Atoi(e.val)
+		if err != nil {
+			msg := fmt.Sprintf("namewidth: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if n < 0 {
+			msg := "namewidth: value should be a non-negative number"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.namewidth = n
 	case "tabstop":
 		n, err := strconv.Atoi(e.val)
 		if err != nil {
@@ -60,27 +209,292 @@ func (e *SetExpr) eval(app *App, args []string) {
 			return
 		}
 		gOpts.tabstop = n
+	case "confirmdelete", "confirmpaste":
+		n, err := strconv.Atoi(e.val)
+		if err != nil {
+			msg := fmt.Sprintf("%s: %s", e.opt, err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if n < 0 {
+			msg := fmt.Sprintf("%s: value should be a non-negative number", e.opt)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if e.opt == "confirmdelete" {
+			gOpts.confirmdelete = n
+		} else {
+			gOpts.confirmpaste = n
+		}
+	case "escalate":
+		gOpts.escalate = e.val
 	case "ifs":
 		gOpts.ifs = e.val
-	case "showinfo":
-		if e.val != "none" && e.val != "size" && e.val != "time" {
-			msg := "showinfo should either be 'none', 'size' or 'time'"
+	case "pwdmode":
+		if e.val != "logical" && e.val != "physical" && e.val != "both" {
+			msg := "pwdmode should either be 'logical', 'physical' or 'both'"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.pwdmode = e.val
+	case "nested":
+		if e.val != "allow" && e.val != "warn" && e.val != "reuse" {
+			msg := "nested should either be 'allow', 'warn' or 'reuse'"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.nested = e.val
+	case "markchar":
+		if utf8.RuneCountInString(e.val) != 1 {
+			msg := "markchar: value should be a single character"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.markchar = e.val
+	case "timefmt", "infotimefmt", "decimalsep":
+		// spaces are given with escape sequences since values are single words
+		s, err := strconv.Unquote(`"` + e.val + `"`)
+		if err != nil || s == "" {
+			msg := fmt.Sprintf("%s: invalid value: %s", e.opt, e.val)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		switch e.opt {
+		case "timefmt":
+			gOpts.timefmt = s
+		case "infotimefmt":
+			gOpts.infotimefmt = s
+		case "decimalsep":
+			gOpts.decimalsep = s
+		}
+	case "bell":
+		if e.val != "none" && e.val != "audible" && e.val != "visual" {
+			msg := "bell should either be 'none', 'audible' or 'visual'"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.bell = e.val
+	case "markmode":
+		if e.val != "margin" && e.val != "prefix" {
+			msg := "markmode should either be 'margin' or 'prefix'"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.markmode = e.val
+	case "markcolor":
+		c, ok := gColorNames[e.val]
+		if !ok {
+			msg := fmt.Sprintf("markcolor: unknown color: %s", e.val)
 			app.ui.message = msg
 			log.Print(msg)
 			return
 		}
-		gOpts.showinfo = e.val
+		gOpts.markcolor = c
+	case "info", "showinfo":
+		// showinfo is kept for old configuration files
+		if e.opt == "showinfo" {
+			log.Print("showinfo: deprecated option, use info instead")
+		}
+		if e.val == "" || e.val == "none" {
+			gOpts.info = nil
+			return
+		}
+		toks := strings.Split(e.val, ",")
+		for _, s := range toks {
+			if !isInfoType(s) {
+				msg := fmt.Sprintf("%s: unknown type: %s (should be one of %s)", e.opt, s, strings.Join(gInfoTypes, ", "))
+				app.ui.message = msg
+				log.Print(msg)
+				return
+			}
+		}
+		gOpts.info = toks
 	case "sortby":
-		if e.val != "name" && e.val != "size" && e.val != "time" {
-			msg := "sortby should either be 'name', 'size' or 'time'"
+		if !isSortType(e.val) {
+			msg := "sortby should either be 'natural', 'name', 'size', 'time' or 'ext'"
 			app.ui.message = msg
 			log.Print(msg)
 			return
 		}
 		gOpts.sortby = e.val
-		app.nav.renew(app.nav.height)
+		app.nav.reload()
 	case "opener":
 		gOpts.opener = e.val
+	case "oplog":
+		gOpts.oplog = e.val
+	case "terminal":
+		gOpts.terminal = e.val
+	case "clipboard":
+		gOpts.clipboard = e.val
+	case "cleaner":
+		gOpts.cleaner = e.val
+	case "announce":
+		gOpts.announce = e.val
+	case "imagepreview":
+		if !isImageMethod(e.val) {
+			msg := "imagepreview should either be 'auto', 'none', 'sixel', 'kitty' or 'ueberzug'"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.imagepreview = e.val
+	case "previewer":
+		gOpts.previewer = e.val
+	case "colors":
+		gOpts.colors = e.val
+		gColors = nil
+	case "theme":
+		if _, ok := gThemes[e.val]; !ok {
+			msg := "theme should either be 'default', 'light' or 'mono'"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.theme = e.val
+		gColors = nil
+	case "cachedir":
+		gOpts.cachedir = e.val
+	case "msgtimeout":
+		n, err := strconv.Atoi(e.val)
+		if err != nil {
+			msg := fmt.Sprintf("msgtimeout: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if n < 0 {
+			msg := "msgtimeout: value should be a non-negative number"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.msgtimeout = n
+	case "cachesize":
+		n, err := strconv.Atoi(e.val)
+		if err != nil {
+			msg := fmt.Sprintf("cachesize: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if n < 0 {
+			msg := "cachesize: value should be a non-negative number"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.cachesize = n
+	case "clipsize":
+		n, err := strconv.Atoi(e.val)
+		if err != nil {
+			msg := fmt.Sprintf("clipsize: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if n < 0 {
+			msg := "clipsize: value should be a non-negative number"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.clipsize = n
+	case "treedepth":
+		n, err := strconv.Atoi(e.val)
+		if err != nil {
+			msg := fmt.Sprintf("treedepth: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if n <= 0 {
+			msg := "treedepth: value should be a positive number"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.treedepth = n
+		app.nav.renew(app.nav.height)
+	case "flattendepth":
+		n, err := strconv.Atoi(e.val)
+		if err != nil {
+			msg := fmt.Sprintf("flattendepth: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if n <= 0 {
+			msg := "flattendepth: value should be a positive number"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.flattendepth = n
+		app.nav.renew(app.nav.height)
+	case "history":
+		n, err := strconv.Atoi(e.val)
+		if err != nil {
+			msg := fmt.Sprintf("history: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if n < 0 {
+			msg := "history: value should be a non-negative number"
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.history = n
+	case "hiddenfiles":
+		toks := strings.Split(e.val, ":")
+		for _, s := range toks {
+			if _, err := filepath.Match(s, ""); err != nil {
+				msg := fmt.Sprintf("hiddenfiles: %s: %s", err, s)
+				app.ui.message = msg
+				log.Print(msg)
+				return
+			}
+		}
+		gOpts.hiddenfiles = toks
+		app.nav.reload()
+	case "ruler":
+		var toks []string
+		if e.val != "" {
+			toks = strings.Split(e.val, ":")
+		}
+		for _, s := range toks {
+			if !isRulerSegment(s) {
+				msg := fmt.Sprintf("ruler: unknown segment: %s (should be one of %s or %%{NAME})", s, strings.Join(gRulerSegments, ", "))
+				app.ui.message = msg
+				log.Print(msg)
+				return
+			}
+		}
+		gOpts.ruler = toks
+	case "protect":
+		var toks []string
+		for _, s := range strings.Split(e.val, ":") {
+			if s == "" {
+				continue
+			}
+			if _, err := path.Match(s, ""); err != nil {
+				msg := fmt.Sprintf("protect: %s: %s", err, s)
+				app.ui.message = msg
+				log.Print(msg)
+				return
+			}
+			toks = append(toks, s)
+		}
+		gOpts.protect = toks
 	case "ratios":
 		toks := strings.Split(e.val, ":")
 		var rats []int
@@ -92,10 +506,18 @@ func (e *SetExpr) eval(app *App, args []string) {
 				log.Print(msg)
 				return
 			}
+			if i <= 0 {
+				msg := "ratios: values should be positive numbers"
+				app.ui.message = msg
+				log.Print(msg)
+				return
+			}
 			rats = append(rats, i)
 		}
 		gOpts.ratios = rats
-		app.ui = newUI()
+		// panes are recreated keeping the rest of the ui (e.g. tabs) and
+		// directories are kept up to the root so added panes show parents
+		app.ui.renew()
 	default:
 		msg := fmt.Sprintf("unknown option: %s", e.opt)
 		app.ui.message = msg
@@ -104,95 +526,335 @@ func (e *SetExpr) eval(app *App, args []string) {
 }
 
 func (e *MapExpr) eval(app *App, args []string) {
-	gOpts.keys[e.keys] = e.expr
+	keys, err := normKeys(e.keys)
+	if err != nil {
+		msg := fmt.Sprintf("map: %s", err)
+		app.ui.message = msg
+		log.Print(msg)
+		return
+	}
+	gOpts.keys[keys] = e.expr
 }
 
-func (e *CmdExpr) eval(app *App, args []string) {
-	gOpts.cmds[e.name] = e.expr
+func (e *RemapExpr) eval(app *App, args []string) {
+	name := "noremap"
+	if e.remap {
+		name = "remap"
+	}
+	keys, err := normKeys(e.keys)
+	if err != nil {
+		msg := fmt.Sprintf("%s: %s", name, err)
+		app.ui.message = msg
+		log.Print(msg)
+		return
+	}
+	to, err := normKeys(e.to)
+	if err != nil {
+		msg := fmt.Sprintf("%s: %s", name, err)
+		app.ui.message = msg
+		log.Print(msg)
+		return
+	}
+	gOpts.keys[keys] = &KeysExpr{to, e.remap}
 }
 
-func (e *CallExpr) eval(app *App, args []string) {
-	// TODO: check for extra toks in each case
-	switch e.name {
-	case "quit":
-		gExitFlag = true
-	case "echo":
-		app.ui.message = strings.Join(e.args, " ")
-	case "down":
-		app.nav.down()
-		app.ui.echoFileInfo(app.nav)
-	case "up":
-		app.nav.up()
-		app.ui.echoFileInfo(app.nav)
-	case "updir":
-		if err := app.nav.updir(); err != nil {
-			app.ui.message = err.Error()
-			log.Print(err)
-			return
-		}
-		app.ui.echoFileInfo(app.nav)
-	case "open":
-		dir := app.nav.currDir()
+// Maximum depth of key sequences expanding to other key sequences.
+const gMaxRemapDepth = 16
 
-		if len(dir.fi) == 0 {
-			return
-		}
+func (e *KeysExpr) eval(app *App, args []string) {
+	if err := app.feedKeys(e.keys, e.remap, 0); err != nil {
+		msg := fmt.Sprintf("keys: %s", err)
+		app.ui.message = msg
+		log.Print(msg)
+	}
+}
 
-		path := app.nav.currPath()
+// Number of lines scrolled with each turn of the mouse wheel.
+const gWheelLines = 3
 
-		f, err := os.Stat(path)
-		if err != nil {
-			msg := fmt.Sprintf("open: %s", err)
-			app.ui.message = msg
-			log.Print(msg)
+// This function handles mouse events. Clicking an entry in any directory pane
+// moves the cursor to the entry, going up to the directory of the pane if
+// needed, and clicking an entry of a directory preview enters the directory.
+// Double clicks open the current file. Wheel moves the cursor or scrolls the
+// preview pane when it shows a file.
+func (e *MouseExpr) eval(app *App, args []string) {
+	ui, nav := app.ui, app.nav
+
+	i, line := ui.paneAt(e.x, e.y)
+	if i < 0 {
+		return
+	}
+
+	preview, woff, doff, length := ui.panes(nav)
+	onPreview := preview && i == len(ui.wins)-1
+
+	// preview pane shows a directory or a file only when there is a current file
+	if onPreview && len(nav.currDir().fi) == 0 {
+		return
+	}
+
+	switch e.key {
+	case termbox.MouseWheelUp, termbox.MouseWheelDown:
+		n := gWheelLines
+		if e.key == termbox.MouseWheelUp {
+			n = -n
+		}
+		if onPreview {
+			if !nav.currFile().IsDir() {
+				ui.prevOff = max(ui.prevOff+n, 0)
+			}
+			return
+		}
+		nav.move(nav.currDir().ind + n)
+		ui.echoFileInfo(nav)
+	case termbox.MouseLeft:
+		if ui.doubleClick(e.x, e.y) {
+			(&CallExpr{"open", nil}).eval(app, nil)
 			return
 		}
 
-		if f.IsDir() {
-			if err := app.nav.open(); err != nil {
+		if onPreview {
+			if f, err := statPath(nav.currPath()); err != nil || !isBrowsable(nav.currPath(), f) {
+				return
+			}
+			if err := nav.open(); err != nil {
 				app.ui.message = err.Error()
 				log.Print(err)
 				return
 			}
-			app.ui.echoFileInfo(app.nav)
-			return
-		}
-
-		if gSelectionPath != "" {
-			out, err := os.Create(gSelectionPath)
-			if err != nil {
-				log.Printf("opening selection file: %s", err)
-			}
-			defer out.Close()
-
-			if len(app.nav.marks) != 0 {
-				marks := app.nav.currMarks()
-				path = strings.Join(marks, "\n")
+		} else {
+			if i < woff || i >= woff+length {
+				return
 			}
-
-			_, err = out.WriteString(path)
-			if err != nil {
-				log.Printf("writing selection file: %s", err)
+			for n := len(nav.dirs) - 1 - (doff + i - woff); n > 0; n-- {
+				if err := nav.updir(); err != nil {
+					app.ui.message = err.Error()
+					log.Print(err)
+					return
+				}
 			}
+		}
 
-			gExitFlag = true
+		dir := nav.currDir()
+		if dir.loading {
 			return
 		}
+		if ind := max(dir.ind-dir.pos, 0) + line; ind < len(dir.fi) {
+			nav.move(ind)
+		}
+		ui.echoFileInfo(nav)
+	}
+}
 
-		if len(app.nav.marks) == 0 {
-			app.runShell(fmt.Sprintf("%s '%s'", gOpts.opener, path), nil, false, false)
-		} else {
+func (e *CmdExpr) eval(app *App, args []string) {
+	// 'cmd name' without a body removes the command
+	if e.expr == nil {
+		delete(gOpts.cmds, e.name)
+		return
+	}
+	gOpts.cmds[e.name] = e.expr
+}
+
+// This function returns the initial text of the prompt for read commands.
+// Arguments are joined with a trailing space to continue typing (e.g. 'map M
+// read-shell mkdir' opens the prompt with 'mkdir ').
+func initText(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return strings.Join(args, " ") + " "
+}
+
+// This function checks whether a dry run is requested in the arguments of
+// file operations (e.g. 'paste -n' or 'paste --dry-run'). Dry runs list the
+// operations in the pager instead of doing them.
+func isDryRun(args []string) bool {
+	for _, a := range args {
+		if a == "-n" || a == "--dry-run" {
+			return true
+		}
+	}
+	return false
+}
+
+// Commands modifying files are not allowed when 'readonly' option is set.
+// Shell commands are not allowed either since they may modify files.
+var gMutatingCmds = map[string]bool{
+	"delete":           true,
+	"paste":            true,
+	"rename":           true,
+	"rename!":          true,
+	"bulkrename":       true,
+	"copyto":           true,
+	"moveto":           true,
+	"sendto":           true,
+	"retry":            true,
+	"shell":            true,
+	"read-shell":       true,
+	"read-shell-pipe":  true,
+	"read-shell-wait":  true,
+	"read-shell-async": true,
+}
+
+func (e *OpenExpr) eval(app *App, args []string) {
+	gOpts.openers = append(gOpts.openers, Handler{e.glob, e.expr})
+}
+
+func (e *PrevExpr) eval(app *App, args []string) {
+	if _, ok := e.expr.(*ExecExpr); !ok {
+		msg := fmt.Sprintf("previewer: %s: should be a shell command", e.glob)
+		app.ui.message = msg
+		log.Print(msg)
+		return
+	}
+	gOpts.prevs = append(gOpts.prevs, Handler{e.glob, e.expr})
+}
+
+func (e *DirExpr) eval(app *App, args []string) {
+	gOpts.cmddirs[e.name] = e.dir
+}
+
+// This function returns the working directory of shell commands run by the
+// given user command. It is the current directory unless another one is
+// given with 'cmddir' as 'parent' for the directory in the parent pane or as
+// a path. Empty string is returned for the current directory.
+func cmdDir(nav *Nav, name string) string {
+	switch dir := gOpts.cmddirs[name]; dir {
+	case "", "current":
+		return ""
+	case "parent":
+		return path.Dir(nav.currDir().path)
+	default:
+		return nav.absPath(dir)
+	}
+}
+
+// This function returns the count given as the argument of movement commands
+// (e.g. 'down 5') or 1 when it is not given.
+func countArg(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid count: %s", args[0])
+	}
+	return n, nil
+}
+
+func (e *CallExpr) eval(app *App, args []string) {
+	if gOpts.readonly && gMutatingCmds[e.name] {
+		msg := fmt.Sprintf("%s: not allowed in readonly mode", e.name)
+		app.ui.message = msg
+		log.Print(msg)
+		return
+	}
+
+	// TODO: check for extra toks in each case
+	switch e.name {
+	case "quit":
+		gExitFlag = true
+	case "echo":
+		app.ui.echo(strings.Join(e.args, " "))
+	case "dump":
+		app.dumpOpts()
+	case "down", "up":
+		n, err := countArg(e.args)
+		if err != nil {
+			msg := fmt.Sprintf("%s: %s", e.name, err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if e.name == "up" {
+			n = -n
+		}
+		app.nav.move(app.nav.currDir().ind + n)
+		app.ui.echoFileInfo(app.nav)
+	case "updir":
+		if err := app.nav.updir(); err != nil {
+			app.ui.message = err.Error()
+			log.Print(err)
+			return
+		}
+		app.ui.echoFileInfo(app.nav)
+	case "open":
+		dir := app.nav.currDir()
+
+		if len(dir.fi) == 0 {
+			return
+		}
+
+		path := app.nav.currPath()
+
+		f, err := statPath(path)
+		if err != nil {
+			msg := fmt.Sprintf("open: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+
+		if isBrowsable(path, f) {
+			if err := app.nav.open(); err != nil {
+				app.ui.message = err.Error()
+				log.Print(err)
+				return
+			}
+			app.ui.echoFileInfo(app.nav)
+			return
+		}
+
+		if gSelectionPath != "" {
+			if err := writeSelection(app.nav.currSelection()); err != nil {
+				msg := fmt.Sprintf("open: %s", err)
+				app.ui.message = msg
+				log.Print(msg)
+				return
+			}
+			gExitFlag = true
+			return
+		}
+
+		// files inside archives are opened from their extracted copies
+		list, err := archiveSources(app.nav.currSelection(), true)
+		if err != nil {
+			msg := fmt.Sprintf("open: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+
+		if len(app.nav.marks) == 0 {
+			path = list[0]
+			if expr := findHandler(gOpts.openers, path); expr != nil {
+				expr.eval(app, []string{path})
+				return
+			}
+			app.runShell(fmt.Sprintf("%s '%s'", gOpts.opener, path), nil, false, false)
+		} else {
 			s := gOpts.opener
-			for m := range app.nav.marks {
+			for _, m := range list {
 				s += fmt.Sprintf(" '%s'", m)
 			}
 			app.runShell(s, nil, false, false)
 		}
-	case "bot":
-		app.nav.bot()
-		app.ui.echoFileInfo(app.nav)
-	case "top":
-		app.nav.top()
+	case "bot", "top":
+		// a count moves to the line with the given number as in vim
+		if len(e.args) != 0 {
+			n, err := countArg(e.args)
+			if err != nil {
+				msg := fmt.Sprintf("%s: %s", e.name, err)
+				app.ui.message = msg
+				log.Print(msg)
+				return
+			}
+			app.nav.move(n - 1)
+		} else if e.name == "bot" {
+			app.nav.bot()
+		} else {
+			app.nav.top()
+		}
 		app.ui.echoFileInfo(app.nav)
 	case "cd":
 		if err := app.nav.cd(e.args[0]); err != nil {
@@ -201,8 +863,70 @@ func (e *CallExpr) eval(app *App, args []string) {
 			return
 		}
 		app.ui.echoFileInfo(app.nav)
+	case "mark-save", "mark-load":
+		marks, err := loadMarks()
+		if err != nil {
+			msg := fmt.Sprintf("%s: %s", e.name, err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		var key string
+		if len(e.args) != 0 {
+			key = e.args[0]
+		} else if key = app.ui.readMark(marks); key == "" {
+			return
+		}
+		if !isMarkKey(key) {
+			msg := fmt.Sprintf("%s: invalid mark: %s", e.name, key)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if e.name == "mark-save" {
+			if err := saveMark(key, app.nav.currDir().path); err != nil {
+				msg := fmt.Sprintf("mark-save: %s", err)
+				app.ui.message = msg
+				log.Print(msg)
+				return
+			}
+			app.ui.echo(fmt.Sprintf("mark saved: %s", key))
+			return
+		}
+		p, ok := marks[key]
+		if !ok {
+			msg := fmt.Sprintf("mark-load: no such mark: %s", key)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if err := app.nav.cd(p); err != nil {
+			msg := fmt.Sprintf("mark-load: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		app.ui.echoFileInfo(app.nav)
+	case "select":
+		if len(e.args) == 0 {
+			return
+		}
+		p := app.nav.absPath(strings.Join(e.args, " "))
+		if _, err := os.Lstat(p); err != nil {
+			msg := fmt.Sprintf("select: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if err := app.nav.find(p); err != nil {
+			msg := fmt.Sprintf("select: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		app.ui.echoFileInfo(app.nav)
 	case "read":
-		s := app.ui.prompt(":")
+		s := app.ui.promptText(":", initText(e.args), len(initText(e.args)), 0, 0, nil)
 		if len(s) == 0 {
 			app.ui.echoFileInfo(app.nav)
 			return
@@ -217,58 +941,673 @@ func (e *CallExpr) eval(app *App, args []string) {
 			log.Print(p.err)
 		}
 	case "read-shell":
-		s := app.ui.prompt("$")
+		s := app.ui.promptText("$", initText(e.args), len(initText(e.args)), 0, 0, nil)
 		log.Printf("shell: %s", s)
 		app.runShell(s, nil, false, false)
+	case "read-shell-pipe":
+		s := app.ui.promptText("%", initText(e.args), len(initText(e.args)), 0, 0, nil)
+		log.Printf("shell-pipe: %s", s)
+		app.runPipe(s, nil)
 	case "read-shell-wait":
-		s := app.ui.prompt("!")
+		s := app.ui.promptText("!", initText(e.args), len(initText(e.args)), 0, 0, nil)
 		log.Printf("shell-wait: %s", s)
 		app.runShell(s, nil, true, false)
 	case "read-shell-async":
-		s := app.ui.prompt("&")
+		s := app.ui.promptText("&", initText(e.args), len(initText(e.args)), 0, 0, nil)
 		log.Printf("shell-async: %s", s)
 		app.runShell(s, nil, false, true)
-	case "search":
-		s := app.ui.prompt("/")
-		log.Printf("search: %s", s)
-		app.ui.message = "sorry, search is not implemented yet!"
-		// TODO: implement
-	case "search-back":
-		s := app.ui.prompt("?")
-		log.Printf("search-back: %s", s)
-		app.ui.message = "sorry, search-back is not implemented yet!"
-		// TODO: implement
+	case "search", "search-back":
+		back := e.name == "search-back"
+		pref := "/"
+		if back {
+			pref = "?"
+		}
+		// cursor is moved to the first match while typing
+		dir := app.nav.currDir()
+		ind, pos := dir.ind, dir.pos
+		s := app.ui.promptText(pref, "", 0, 0, 0, func(s string) error {
+			dir.ind, dir.pos = ind, pos
+			found := s == "" || app.nav.searchFrom(s, back, 0)
+			app.ui.draw(app.nav)
+			if !found {
+				return fmt.Errorf("pattern not found")
+			}
+			return nil
+		})
+		log.Printf("%s: %s", e.name, s)
+		if s == "" {
+			dir.ind, dir.pos = ind, pos
+			app.ui.echoFileInfo(app.nav)
+			return
+		}
+		app.nav.search, app.nav.back = s, back
+		if !app.nav.searchFrom(s, back, 0) {
+			msg := fmt.Sprintf("%s: pattern not found: %s", e.name, s)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		app.ui.echoFileInfo(app.nav)
+	case "search-next", "search-prev":
+		if err := app.nav.searchNext(e.name == "search-prev"); err != nil {
+			msg := fmt.Sprintf("%s: %s", e.name, err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		app.ui.echoFileInfo(app.nav)
+	case "sort":
+		s := nextSortType(gOpts.sortby)
+		if len(e.args) != 0 {
+			s = e.args[0]
+		}
+		if !isSortType(s) {
+			msg := fmt.Sprintf("sort: unknown sorting type: %s", s)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		gOpts.sortby = s
+		app.nav.reload()
+		app.ui.echo("sortby: " + s)
+	case "filter":
+		dir := app.nav.currDir()
+		s := strings.Join(e.args, " ")
+		if len(e.args) == 0 {
+			s = app.ui.promptText("filter: ", dir.filter, len([]rune(dir.filter)), 0, 0, nil)
+		}
+		log.Printf("filter: %s", s)
+		dir.setFilter(s, app.nav.height)
+		app.ui.echoFileInfo(app.nav)
+	case "filter-type":
+		dir := app.nav.currDir()
+		var ftype string
+		if len(e.args) != 0 {
+			ftype = e.args[0]
+		}
+		if ftype != "" && !isFilterType(ftype) {
+			msg := fmt.Sprintf("filter-type: should be one of %s", strings.Join(gFilterTypes, ", "))
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		// the same type again shows all entries
+		if ftype == dir.ftype {
+			ftype = ""
+		}
+		log.Printf("filter-type: %s", ftype)
+		dir.setType(ftype, app.nav.height)
+		app.ui.echoFileInfo(app.nav)
+	case "tree":
+		if app.nav.currDir().loading {
+			return
+		}
+		app.nav.toggleTree()
+		app.ui.echoFileInfo(app.nav)
+	case "tree-expand":
+		if err := app.nav.treeExpand(); err != nil {
+			msg := fmt.Sprintf("tree-expand: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		app.ui.echoFileInfo(app.nav)
+	case "tree-collapse":
+		app.nav.treeCollapse()
+		app.ui.echoFileInfo(app.nav)
+	case "flatten":
+		if app.nav.currDir().loading {
+			return
+		}
+		app.nav.toggleFlatten()
+		app.ui.echoFileInfo(app.nav)
+	case "du":
+		if app.nav.currDir().loading {
+			return
+		}
+		if err := app.nav.toggleUsage(); err != nil {
+			msg := fmt.Sprintf("du: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		app.ui.echoFileInfo(app.nav)
+	case "shell":
+		app.runTerminal()
+	case "copy-path":
+		if len(app.nav.currDir().fi) == 0 {
+			return
+		}
+		sep, quote := "\n", false
+		for _, arg := range e.args {
+			if arg == "-q" {
+				quote = true
+				continue
+			}
+			s, err := strconv.Unquote(`"` + arg + `"`)
+			if err != nil {
+				msg := fmt.Sprintf("copy-path: invalid separator: %s", arg)
+				app.ui.message = msg
+				log.Print(msg)
+				return
+			}
+			sep = s
+		}
+		if err := app.copyPaths(sep, quote); err != nil {
+			msg := fmt.Sprintf("copy-path: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		app.ui.echo("copied path(s) to clipboard")
+	case "copy-content":
+		if len(app.nav.currDir().fi) == 0 {
+			return
+		}
+		if err := app.copyContent(); err != nil {
+			msg := fmt.Sprintf("copy-content: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		app.ui.echo(fmt.Sprintf("copied content of %s to clipboard", app.nav.currFile().Name()))
+	case "results":
+		if len(e.args) == 0 {
+			return
+		}
+		s := strings.Join(e.args, " ")
+		out, err := exec.Command(envShell, "-c", s).Output()
+		if err != nil {
+			msg := fmt.Sprintf("results: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		var items []string
+		for _, line := range strings.Split(string(out), "\n") {
+			if line != "" {
+				items = append(items, line)
+			}
+		}
+		if len(items) == 0 {
+			app.ui.message = "results: no results"
+			return
+		}
+		l := newList(s, items)
+		l.keys["o"] = "open"
+		l.keys["t"] = "tab"
+		app.ui.runList(l, func(i int, action string) bool {
+			if i < 0 {
+				return true
+			}
+			p := items[i]
+			if !path.IsAbs(p) {
+				p = path.Join(app.nav.currDir().path, p)
+			}
+			if action == "tab" {
+				(&CallExpr{"open-dir-in-tab", []string{p}}).eval(app, nil)
+				return true
+			}
+			if err := app.nav.find(p); err != nil {
+				msg := fmt.Sprintf("results: %s", err)
+				app.ui.message = msg
+				log.Print(msg)
+				return true
+			}
+			if action == "open" {
+				(&CallExpr{"open", nil}).eval(app, nil)
+				return true
+			}
+			app.ui.echoFileInfo(app.nav)
+			return true
+		})
+	case "file-info":
+		if len(app.nav.currDir().fi) == 0 {
+			return
+		}
+		title := path.Base(app.nav.currPath())
+		details := fileDetails(app.nav.currFile(), app.nav.currPath())
+		// marked files are summarized instead
+		if n := len(app.nav.marks); n != 0 {
+			title = fmt.Sprintf("%d marked files", n)
+			details = selectionDetails(app.nav.currMarks())
+		}
+		var lines []string
+		for _, d := range details {
+			lines = append(lines, fmt.Sprintf("%-9s %s", d[0], d[1]))
+		}
+		app.ui.popup(title, lines)
+	case "selection-list":
+		var items []string
+		for p := range app.nav.marks {
+			items = append(items, p)
+		}
+		if len(items) == 0 {
+			app.ui.message = "selection-list: no marked files"
+			return
+		}
+		sort.Strings(items)
+		l := newList(fmt.Sprintf("selection (%d)", len(items)), items)
+		l.keys["d"] = "unselect"
+		l.keys["<space>"] = "unselect"
+		app.ui.runList(l, func(i int, action string) bool {
+			if i < 0 {
+				return true
+			}
+			p := l.items[i]
+			if action == "unselect" {
+				delete(app.nav.marks, p)
+				l.items = append(l.items[:i:i], l.items[i+1:]...)
+				l.ind, l.beg = min(i, len(l.items)-1), 0
+				l.title = fmt.Sprintf("selection (%d)", len(l.items))
+				// the panes are drawn again to show the removed mark
+				app.ui.draw(app.nav)
+				return len(l.items) == 0
+			}
+			if err := app.nav.find(p); err != nil {
+				msg := fmt.Sprintf("selection-list: %s", err)
+				app.ui.message = msg
+				log.Print(msg)
+				return true
+			}
+			app.ui.echoFileInfo(app.nav)
+			return true
+		})
+	case "rename", "rename!":
+		force := e.name == "rename!"
+
+		dir := app.nav.currDir()
+
+		if len(dir.fi) == 0 {
+			return
+		}
+
+		curr := app.nav.currFile()
+		name := curr.Name()
+
+		// select the name without the extension
+		n := utf8.RuneCountInString(name)
+		if !curr.IsDir() {
+			n -= utf8.RuneCountInString(path.Ext(name))
+		}
+
+		check := func(s string) error {
+			if s == name || force {
+				return nil
+			}
+			if _, err := os.Lstat(path.Join(dir.path, s)); err == nil {
+				return errors.New("file exists, use rename! to overwrite")
+			}
+			return nil
+		}
+
+		pref := "rename: "
+		if force {
+			pref = "rename!: "
+		}
+
+		s := app.ui.promptText(pref, name, n, 0, n, check)
+		if s == "" || s == name {
+			app.ui.echoFileInfo(app.nav)
+			return
+		}
+
+		if err := app.nav.rename(name, s, force); err != nil {
+			flag := "-n"
+			if force {
+				flag = "-f"
+			}
+			if os.IsPermission(err) && app.escalate([]string{"mv", flag, path.Join(dir.path, name), path.Join(dir.path, s)}) {
+				app.nav.renew(app.nav.height)
+				dir.load(dir.ind, dir.pos, app.nav.height, s)
+				return
+			}
+			msg := fmt.Sprintf("%s: %s", e.name, err)
+			app.ui.message = msg
+			log.Print(msg)
+			app.ui.bell()
+			return
+		}
+
+		// cursor is kept on the renamed file even if it replaced another one
+		app.nav.renew(app.nav.height)
+		dir.load(dir.ind, dir.pos, app.nav.height, s)
+	case "bulkrename":
+		if len(app.nav.currDir().fi) == 0 {
+			return
+		}
+		if err := app.bulkRename(isDryRun(e.args)); err != nil {
+			msg := fmt.Sprintf("bulkrename: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			app.ui.bell()
+		}
 	case "toggle":
 		app.nav.toggle()
-	case "yank":
+	case "invert":
+		app.nav.invert()
+	case "unselect":
+		app.nav.marks = make(map[string]bool)
+	case "glob-select", "glob-unselect":
+		s := strings.Join(e.args, " ")
+		if len(e.args) == 0 {
+			s = app.ui.prompt(e.name + ": ")
+		}
+		if s == "" {
+			return
+		}
+		n, err := app.nav.globSelect(s, e.name == "glob-select")
+		if err != nil {
+			msg := fmt.Sprintf("%s: %s: %s", e.name, err, s)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if n == 0 {
+			app.ui.message = fmt.Sprintf("%s: no matches: %s", e.name, s)
+		}
+	case "visual":
+		app.nav.visual()
+	case "visual-cancel":
+		app.nav.visualCancel()
+	case "copy", "yank":
+		// 'yank' is kept for configurations written before 'copy'
 		if err := app.nav.save(true); err != nil {
-			msg := fmt.Sprintf("yank: %s", err)
+			msg := fmt.Sprintf("%s: %s", e.name, err)
 			app.ui.message = msg
-			log.Printf(msg)
+			log.Print(msg)
+			app.ui.bell()
 			return
 		}
 		app.nav.marks = make(map[string]bool)
-	case "delete":
+	case "cut":
 		if err := app.nav.save(false); err != nil {
+			msg := fmt.Sprintf("cut: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			app.ui.bell()
+			return
+		}
+		app.nav.marks = make(map[string]bool)
+	case "delete":
+		if len(app.nav.currDir().fi) == 0 {
+			return
+		}
+		list := app.nav.currSelection()
+		for _, f := range list {
+			if err := checkArchive(f); err != nil {
+				msg := fmt.Sprintf("delete: %s", err)
+				app.ui.message = msg
+				log.Print(msg)
+				app.ui.bell()
+				return
+			}
+		}
+		if err := checkProtect(list, gOpts.protect); err != nil {
 			msg := fmt.Sprintf("delete: %s", err)
 			app.ui.message = msg
-			log.Printf(msg)
+			log.Print(msg)
+			app.ui.bell()
 			return
 		}
+		if isDryRun(e.args) {
+			var plan []string
+			for _, f := range list {
+				plan = append(plan, "delete "+f)
+			}
+			app.runPager(strings.Join(plan, "\n") + "\n")
+			return
+		}
+		// small deletions are not confirmed when 'confirmdelete' is set
+		if len(list) > gOpts.confirmdelete {
+			ans := app.ui.prompt(fmt.Sprintf("delete %d files? [y/N] ", len(list)))
+			if ans != "y" && ans != "Y" {
+				app.ui.echoFileInfo(app.nav)
+				return
+			}
+		}
+		startJob(&Job{op: "delete", list: list})
 		app.nav.marks = make(map[string]bool)
+	case "paste-cancel":
+		id := 0
+		if len(e.args) != 0 {
+			n, err := strconv.Atoi(e.args[0])
+			if err != nil {
+				msg := fmt.Sprintf("paste-cancel: %s", err)
+				app.ui.message = msg
+				log.Print(msg)
+				return
+			}
+			id = n
+		}
+		if n := cancelJobs(id); n == 0 {
+			app.ui.message = "paste-cancel: no running jobs"
+		} else {
+			app.ui.echo(fmt.Sprintf("paste-cancel: canceled %d jobs", n))
+		}
 	case "paste":
+		if isDryRun(e.args) {
+			plan, err := app.nav.pastePlan()
+			if err != nil {
+				msg := fmt.Sprintf("paste: %s", err)
+				app.ui.message = msg
+				log.Print(msg)
+				return
+			}
+			app.runPager(strings.Join(plan, "\n") + "\n")
+			return
+		}
+		if size, n := pasteSize(); gOpts.confirmpaste > 0 && size > int64(gOpts.confirmpaste)*1000*1000 {
+			ans := app.ui.prompt(fmt.Sprintf("paste %d items (%s)? [y/N] ", n, humanize(size)))
+			if ans != "y" && ans != "Y" {
+				app.ui.echoFileInfo(app.nav)
+				return
+			}
+		}
+		names := app.nav.currDir().names()
 		if err := app.nav.paste(); err != nil {
+			if os.IsPermission(err) {
+				args, e := app.nav.pasteArgs()
+				if e == nil && app.escalate(args) {
+					app.nav.renew(app.nav.height)
+					app.nav.follow(names)
+					saveFiles(nil, false)
+					return
+				}
+			}
 			msg := fmt.Sprintf("paste: %s", err)
 			app.ui.message = msg
-			log.Printf(msg)
+			log.Print(msg)
+			app.ui.bell()
 			return
 		}
-		app.nav.renew(app.nav.height)
-		app.nav.save(false)
 		saveFiles(nil, false)
+	case "retry":
+		id := 0
+		if len(e.args) != 0 {
+			n, err := strconv.Atoi(e.args[0])
+			if err != nil {
+				msg := fmt.Sprintf("retry: %s", err)
+				app.ui.message = msg
+				log.Print(msg)
+				return
+			}
+			id = n
+		}
+		job := findJob(id)
+		if job == nil {
+			app.ui.message = "retry: no failed or canceled job"
+			return
+		}
+		gJobsMutex.Lock()
+		list := job.failed
+		gJobsMutex.Unlock()
+		if len(list) == 0 {
+			app.ui.message = fmt.Sprintf("retry: no failed files in job %d", job.id)
+			return
+		}
+		if job.op == "delete" {
+			retry := &Job{op: job.op, list: list}
+			startJob(retry)
+			app.ui.echo(fmt.Sprintf("retry: job %d started for %d files", retry.id, len(list)))
+			return
+		}
+		if err := checkWrite(job.dst); err != nil {
+			args := append(append(shellOp(job.op == "copy"), list...), job.dst)
+			if os.IsPermission(err) && app.escalate(args) {
+				app.nav.renew(app.nav.height)
+				return
+			}
+			msg := fmt.Sprintf("retry: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			app.ui.bell()
+			return
+		}
+		retry := &Job{op: job.op, list: list, dst: job.dst}
+		if app.nav.currDir().path == job.dst {
+			retry.names = app.nav.currDir().names()
+		}
+		startJob(retry)
+		app.ui.echo(fmt.Sprintf("retry: job %d started for %d files", retry.id, len(list)))
+	case "jobs":
+		jobs := listJobs()
+		if len(jobs) == 0 {
+			app.ui.message = "jobs: no jobs"
+			return
+		}
+		var items []string
+		for _, job := range jobs {
+			items = append(items, job.String())
+		}
+		sel := -1
+		app.ui.runList(newList("jobs", items), func(i int, action string) bool {
+			sel = i
+			return true
+		})
+		if sel >= 0 {
+			app.runPager(jobs[sel].detail())
+		}
+	case "copyto", "moveto":
+		if len(app.nav.currDir().fi) == 0 {
+			return
+		}
+		dst := strings.Join(e.args, " ")
+		if dst == "" {
+			dst = strings.TrimSpace(app.ui.prompt(e.name + ": "))
+			if dst == "" {
+				return
+			}
+		}
+		app.copyTo(e.name, app.nav.absPath(dst), e.name == "copyto")
+	case "bookmark":
+		p := app.nav.currDir().path
+		if err := addBookmark(p); err != nil {
+			msg := fmt.Sprintf("bookmark: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		app.ui.echo(fmt.Sprintf("bookmarked: %s", p))
+	case "sendto":
+		if len(app.nav.currDir().fi) == 0 {
+			return
+		}
+		marks, err := loadBookmarks()
+		if err != nil {
+			msg := fmt.Sprintf("sendto: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if len(marks) == 0 {
+			app.ui.message = "sendto: no bookmarks"
+			return
+		}
+		l := newList("sendto", marks)
+		l.keys["<cr>"] = "move"
+		l.keys["l"] = "move"
+		l.keys["m"] = "move"
+		l.keys["c"] = "copy"
+		app.ui.runList(l, func(i int, action string) bool {
+			if i < 0 {
+				return true
+			}
+			if action == "select" {
+				action = "move"
+			}
+			app.copyTo("sendto", marks[i], action == "copy")
+			return true
+		})
+	case "reload":
+		app.nav.reload()
+		app.ui.echoFileInfo(app.nav)
+		app.checkRemoved()
+	case "sync":
+		if err := app.syncMarks(); err != nil {
+			msg := fmt.Sprintf("sync: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+		}
 	case "redraw":
 		app.ui.renew()
 		app.nav.renew(app.ui.wins[0].h)
+	case "tab-new":
+		nav := newNav(app.ui.wins[0].h)
+		if len(app.nav.currDir().fi) != 0 {
+			if err := nav.find(app.nav.currPath()); err != nil {
+				log.Printf("tab-new: %s", err)
+			}
+		}
+		app.tabs.add(nav)
+		app.switchTab()
+	case "open-dir-in-tab", "open-dir-other":
+		var p string
+		if len(e.args) != 0 {
+			p = app.nav.absPath(strings.Join(e.args, " "))
+		} else if len(app.nav.currDir().fi) != 0 {
+			p = app.nav.currPath()
+		} else {
+			return
+		}
+		p, err := targetPath(p)
+		if err != nil {
+			msg := fmt.Sprintf("%s: %s", e.name, err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		nav := app.tabs.next()
+		if e.name == "open-dir-in-tab" || nav == nil {
+			nav = newNav(app.ui.wins[0].h)
+		}
+		if err := nav.find(p); err != nil {
+			msg := fmt.Sprintf("%s: %s", e.name, err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		if nav == app.tabs.next() {
+			app.tabs.move(1)
+		} else {
+			app.tabs.add(nav)
+		}
+		app.switchTab()
+		app.ui.echoFileInfo(app.nav)
+	case "tab-close":
+		if err := app.tabs.close(); err != nil {
+			msg := fmt.Sprintf("tab-close: %s", err)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		app.switchTab()
+	case "tab-next":
+		app.tabs.move(1)
+		app.switchTab()
+	case "tab-prev":
+		app.tabs.move(-1)
+		app.switchTab()
 	default:
 		cmd, ok := gOpts.cmds[e.name]
 		if !ok {
@@ -277,29 +1616,64 @@ func (e *CallExpr) eval(app *App, args []string) {
 			log.Print(msg)
 			return
 		}
+		defer func(dir string) { app.dir = dir }(app.dir)
+		if dir := cmdDir(app.nav, e.name); dir != "" {
+			app.dir = dir
+		}
 		cmd.eval(app, e.args)
 	}
 }
 
+// Names of shell commands for their prefixes used in messages.
+var gShellNames = map[string]string{
+	"$": "shell",
+	"%": "shell-pipe",
+	"!": "shell-wait",
+	"&": "shell-async",
+}
+
 func (e *ExecExpr) eval(app *App, args []string) {
+	if name, ok := gShellNames[e.pref]; ok && gOpts.readonly {
+		msg := fmt.Sprintf("%s: not allowed in readonly mode", name)
+		app.ui.message = msg
+		log.Print(msg)
+		return
+	}
+
+	s := e.expr
+	if e.tmpl && e.pref != "/" && e.pref != "?" {
+		s = expandPlaceholders(s, app.placeholders())
+	}
+
 	switch e.pref {
 	case "$":
 		log.Printf("shell: %s -- %s", e, args)
 		app.ui.clearMsg()
-		app.runShell(e.expr, args, false, false)
+		names := app.nav.currDir().names()
+		app.runShell(s, args, false, false)
+		app.nav.follow(names)
 		app.ui.echoFileInfo(app.nav)
+	case "%":
+		log.Printf("shell-pipe: %s -- %s", e, args)
+		app.runPipe(s, args)
 	case "!":
 		log.Printf("shell-wait: %s -- %s", e, args)
-		app.runShell(e.expr, args, true, false)
+		names := app.nav.currDir().names()
+		app.runShell(s, args, true, false)
+		app.nav.follow(names)
 	case "&":
 		log.Printf("shell-async: %s -- %s", e, args)
-		app.runShell(e.expr, args, false, true)
-	case "/":
+		app.runShell(s, args, false, true)
+	case "/", "?":
 		log.Printf("search: %s -- %s", e, args)
-		// TODO: implement
-	case "?":
-		log.Printf("search-back: %s -- %s", e, args)
-		// TODO: implement
+		app.nav.search, app.nav.back = e.expr, e.pref == "?"
+		if !app.nav.searchFrom(e.expr, app.nav.back, 1) {
+			msg := fmt.Sprintf("search: pattern not found: %s", e.expr)
+			app.ui.message = msg
+			log.Print(msg)
+			return
+		}
+		app.ui.echoFileInfo(app.nav)
 	default:
 		log.Printf("unknown execution prefix: %q", e.pref)
 	}
@@ -0,0 +1,1442 @@
+//go:build headless
+// +build headless
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// This function starts the ui on the fake screen in a temporary directory
+// with the given files. It returns a function to clean up afterwards.
+func startHeadless(t *testing.T, files []string) (*App, func()) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+
+	for _, f := range files {
+		if err := ioutil.WriteFile(path.Join(dir, f), []byte(f), 0644); err != nil {
+			t.Fatalf("writing file: %s", err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getting current directory: %s", err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("changing directory: %s", err)
+	}
+
+	// history and bookmarks are kept out of the listed directory
+	hist, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	histPath, bookmarksPath := gHistoryPath, gBookmarksPath
+	gHistoryPath = path.Join(hist, "history")
+	gBookmarksPath = path.Join(hist, "bookmarks")
+
+	screenInit()
+
+	ui := newUI()
+	nav := newNav(ui.wins[0].h)
+	app := &App{ui: ui, nav: nav, tabs: newTabs(nav), exprChan: make(chan Expr, 100)}
+	ui.tabs = app.tabs
+
+	app.ui.draw(app.nav)
+
+	return app, func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+		os.RemoveAll(hist)
+		gHistoryPath, gBookmarksPath = histPath, bookmarksPath
+	}
+}
+
+// This function types the given keys and evaluates the resulting commands as
+// in the main loop until all keys are read.
+func typeKeys(app *App, keys string) {
+	screenFeed(keys)
+	readEvents(app)
+}
+
+// This function evaluates the commands of the queued events until all events
+// are read.
+func readEvents(app *App) {
+	for len(screenEvents()) != 0 {
+		e := app.ui.getExpr()
+		if e == nil {
+			app.ui.expireFlash()
+			continue
+		}
+		e.eval(app, nil)
+		app.shareMarks()
+		app.waitDirs()
+		app.ui.draw(app.nav)
+		waitPreviews(app)
+	}
+}
+
+// This function waits for the previews and images loading in the background
+// and draws the ui again when they are ready as in the main loop.
+func waitPreviews(app *App) {
+	for {
+		gPreviewMutex.Lock()
+		gImagesMutex.Lock()
+		pending := len(gPreviewPending) != 0 || len(gImagesPending) != 0
+		gImagesMutex.Unlock()
+		gPreviewMutex.Unlock()
+
+		if pending {
+			<-gPreviewChan
+			app.ui.draw(app.nav)
+			continue
+		}
+
+		select {
+		case <-gPreviewChan:
+			app.ui.draw(app.nav)
+		default:
+			return
+		}
+	}
+}
+
+// These functions return the state of the ui compared in headless tests. The
+// directory the ui is started in is given to show paths relative to it.
+
+func relPath(wd, p string) string {
+	if p == wd {
+		return "."
+	}
+	return strings.TrimPrefix(p, wd+"/")
+}
+
+func currName(app *App, wd string) string {
+	if len(app.nav.currDir().fi) == 0 {
+		return ""
+	}
+	return app.nav.currFile().Name()
+}
+
+func currMessage(app *App, wd string) string {
+	return app.ui.message
+}
+
+func dirNames(app *App, wd string) string {
+	var names []string
+	for _, f := range app.nav.currDir().fi {
+		names = append(names, f.Name())
+	}
+	return strings.Join(names, " ")
+}
+
+func markNames(app *App, wd string) string {
+	var marks []string
+	for p := range app.nav.marks {
+		marks = append(marks, relPath(wd, p))
+	}
+	sort.Strings(marks)
+	return strings.Join(marks, " ")
+}
+
+// This function returns the ruler at the right of the message line.
+func currRuler(app *App, wd string) string {
+	lines := screenLines()
+	last := lines[len(lines)-1]
+	if i := strings.Index(last, "["); i >= 0 {
+		return last[i:]
+	}
+	return ""
+}
+
+// This function returns the window of the pane showing the current directory.
+func currWin(app *App) *Win {
+	_, woff, _, length := app.ui.panes(app.nav)
+	return app.ui.wins[woff+length-1]
+}
+
+// This function returns the given line of the given window as drawn with
+// trailing spaces removed.
+func winLine(win *Win, i int) string {
+	line := []rune(screenLines()[win.y+i])
+	if len(line) <= win.x {
+		return ""
+	}
+	return strings.TrimRight(string(line[win.x:min(len(line), win.x+win.w)]), " ")
+}
+
+// This function types the keys of each step in order on the fake screen and
+// compares the state returned by 'got' with the expected value afterwards.
+// Each case starts in a new temporary directory with the given files and the
+// options are restored at the end.
+func TestHeadless(t *testing.T) {
+	type step struct {
+		keys string
+		exp  string
+	}
+
+	tests := []struct {
+		name  string
+		files []string
+		setup func(t *testing.T, app *App, wd string)
+		got   func(app *App, wd string) string
+		steps []step
+	}{
+		{
+			name:  "navigation",
+			files: []string{"foo", "bar", "baz"},
+			got:   currName,
+			steps: []step{
+				{"", "bar"},
+				{"j", "baz"},
+				{"jj", "foo"},
+				{"k", "baz"},
+				{"gg", "bar"},
+				{"G", "foo"},
+				{"/ba<cr>", "bar"},
+				{"n", "baz"},
+				{"N", "bar"},
+				{"?fo<cr>", "foo"},
+			},
+		},
+		{
+			// long names are truncated in the current pane
+			name:  "render",
+			files: []string{"foo", strings.Repeat("x", 100)},
+			got: func(app *App, wd string) string {
+				lines := strings.Join(screenLines(), "\n")
+				return fmt.Sprintf("%t %t %s", strings.Contains(lines, wd), strings.Contains(lines, strings.Repeat("x", 100)), winLine(currWin(app), 0))
+			},
+			steps: []step{
+				{"", "true false   foo"},
+			},
+		},
+		{
+			// counts are read in the keys of mappings and before them
+			name:  "mappings with counts",
+			files: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m"},
+			got:   currName,
+			steps: []step{
+				{":noremap J 5j<cr>", "a"},
+				{"J", "f"},
+				{":noremap K 2k<cr>2K", "b"},
+				{":noremap D jj<cr>3D", "h"},
+				{":remap L 2J<cr>L", "m"},
+			},
+		},
+		{
+			name:  "unknown mapping",
+			files: []string{"foo"},
+			got:   currMessage,
+			steps: []step{
+				{"gq", "unknown mapping: gq (did you mean gg, gT, gt?)"},
+			},
+		},
+		{
+			// entries are moved to the end of the history as they are used
+			name:  "history",
+			files: []string{"foo"},
+			got:   currMessage,
+			steps: []step{
+				{":echo foo<cr>:echo bar<cr>$true<cr>:echo baz<cr>", "baz"},
+				{":<up><cr>", "baz"},
+				{":<up><up><cr>", "bar"},
+				{":<up><up><up><down><cr>", "baz"},
+				{":echo qux<up><down><cr>", "qux"},
+				{":<c-r>fo<cr>", "foo"},
+				{":<c-r>echo<c-r><cr>", "qux"},
+				{":<c-r>ba<esc><bs>x<cr>", "bax"},
+			},
+		},
+		{
+			name:  "prompt",
+			files: []string{"foo"},
+			got:   currMessage,
+			steps: []step{
+				{":echo bar<cr>", "bar"},
+				{":echo ar<left><left>b<cr>", "bar"},
+				{":echo bar<c-a><right><right><right><right><right>x<end>y<cr>", "xbary"},
+				{":echo foo bar<c-w>baz<cr>", "foo baz"},
+				{":foo<c-u>echo bar<cr>", "bar"},
+				{":echo xbar<home><right><right><right><right><right><delete><cr>", "bar"},
+				{":echo bar<c-e><left><bs>x<cr>", "bxr"},
+			},
+		},
+		{
+			// control characters and invalid bytes are drawn escaped
+			name:  "escaped names",
+			files: []string{"a\nb", "c\033[31md", "e\xfff"},
+			got: func(app *App, wd string) string {
+				win := currWin(app)
+				return fmt.Sprintf("%s,%s,%s %q", winLine(win, 0), winLine(win, 1), winLine(win, 2), markNames(app, wd))
+			},
+			steps: []step{
+				{"", `  a\nb,  c\x1b[31md,  e\xfff ""`},
+				{"<space><space>", `  a\nb,  c\x1b[31md,  e\xfff "a\nb c\x1b[31md"`},
+			},
+		},
+		{
+			// cursor and marks are kept separately in each tab
+			name:  "tabs",
+			files: []string{"foo", "bar"},
+			setup: func(t *testing.T, app *App, wd string) {
+				if err := os.Mkdir(path.Join(wd, "baz"), 0755); err != nil {
+					t.Fatalf("creating directory: %s", err)
+				}
+			},
+			got: func(app *App, wd string) string {
+				cwd, _ := os.Getwd()
+				header := strings.HasSuffix(screenLines()[0], " 1  2")
+				return fmt.Sprintf("%d/%d %s:%s %d marks in %s %t", app.tabs.ind+1, len(app.tabs.navs),
+					relPath(wd, app.nav.currDir().path), currName(app, wd), len(app.nav.marks), relPath(wd, cwd), header)
+			},
+			steps: []step{
+				{"<c-l>jj:tab-new<cr>", "2/2 .:foo 0 marks in . true"},
+				{"k<space>gT", "1/2 .:foo 0 marks in . true"},
+				{"ggl", "1/2 baz: 0 marks in baz true"},
+				{"gt", "2/2 .:foo 1 marks in . true"},
+				{":tab-close<cr>", "1/1 baz: 0 marks in baz false"},
+			},
+		},
+		{
+			name:  "last tab",
+			files: []string{"foo"},
+			got:   currMessage,
+			steps: []step{
+				{":tab-close<cr>", "tab-close: cannot close the last tab"},
+			},
+		},
+		{
+			name:  "marks",
+			files: []string{"foo"},
+			setup: func(t *testing.T, app *App, wd string) {
+				if err := os.Mkdir(path.Join(wd, "sub"), 0755); err != nil {
+					t.Fatalf("creating directory: %s", err)
+				}
+			},
+			got: func(app *App, wd string) string {
+				return fmt.Sprintf("%s: %s", relPath(wd, app.nav.currDir().path), app.ui.message)
+			},
+			steps: []step{
+				{"<c-l>gglma", "sub: mark saved: a"},
+				{"h'a", "sub: "},
+				{"'b", "sub: mark-load: no such mark: b"},
+			},
+		},
+		{
+			// escape sequences are skipped and tabs are expanded in previews
+			name: "preview controls",
+			setup: func(t *testing.T, app *App, wd string) {
+				junk := "\033]0;title\033\\foo\033[2J\tbar\033[31mbaz\033(Bqux\rquux\n"
+				if err := ioutil.WriteFile(path.Join(wd, "junk"), []byte(junk), 0644); err != nil {
+					t.Fatalf("writing file: %s", err)
+				}
+			},
+			got: func(app *App, wd string) string {
+				return winLine(app.ui.wins[len(app.ui.wins)-1], 0)
+			},
+			steps: []step{
+				{"<c-l>", "  foo     barbazqux^Mquux"},
+			},
+		},
+		{
+			// images are drawn after the screen is flushed and erased when
+			// the cursor is moved to another file
+			name:  "image preview",
+			files: []string{"a"},
+			setup: func(t *testing.T, app *App, wd string) {
+				gOpts.imagepreview = "kitty"
+
+				f, err := os.Create(path.Join(wd, "b.png"))
+				if err != nil {
+					t.Fatalf("creating image: %s", err)
+				}
+				defer f.Close()
+				if err := png.Encode(f, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+					t.Fatalf("encoding image: %s", err)
+				}
+
+				screenRawOutput()
+			},
+			got: func(app *App, wd string) string {
+				// kitty sequences are compared up to their image data
+				var raw []string
+				for _, s := range screenRawOutput() {
+					if strings.HasPrefix(s, "\033_G") {
+						s = strings.SplitN(s, ",", 2)[0]
+					}
+					raw = append(raw, s)
+				}
+				return fmt.Sprintf("%q", raw)
+			},
+			steps: []step{
+				{"<c-l>j", `["\x1b_Ga=T"]`},
+				{"<c-l>", `[]`},
+				{"k", `["\x1b_Ga=d"]`},
+				{"k", `[]`},
+				{"j", `["\x1b_Ga=T"]`},
+				{":set imagepreview sixel<cr><c-l>", `["\x1b_Ga=d" "\x1bP0;1q\"1;1;4;4#0;2;0;0;0#0!4N$-\x1b\\"]`},
+			},
+		},
+		{
+			// images failed to load are previewed as text and the cleaner
+			// is run when images are erased
+			name:  "image preview fallback",
+			files: []string{"a", "b.png"},
+			setup: func(t *testing.T, app *App, wd string) {
+				gOpts.imagepreview = "kitty"
+
+				f, err := os.Create(path.Join(wd, "c.png"))
+				if err != nil {
+					t.Fatalf("creating image: %s", err)
+				}
+				defer f.Close()
+				if err := png.Encode(f, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+					t.Fatalf("encoding image: %s", err)
+				}
+
+				dir := t.TempDir()
+				gOpts.cleaner = path.Join(dir, "cleaner")
+				script := fmt.Sprintf("#!/bin/sh\necho \"$1\" >> %s/cleaned\n", dir)
+				if err := ioutil.WriteFile(gOpts.cleaner, []byte(script), 0755); err != nil {
+					t.Fatalf("writing cleaner: %s", err)
+				}
+
+				screenRawOutput()
+			},
+			got: func(app *App, wd string) string {
+				var raw []string
+				for _, s := range screenRawOutput() {
+					raw = append(raw, strings.SplitN(s, ",", 2)[0])
+				}
+				cleaned, _ := ioutil.ReadFile(path.Join(path.Dir(gOpts.cleaner), "cleaned"))
+				return fmt.Sprintf("%s|%s|%q|%s", winLine(app.ui.wins[len(app.ui.wins)-1], 0),
+					app.ui.message, raw, strings.Replace(string(cleaned), wd+"/", "", -1))
+			},
+			steps: []step{
+				{":reload<cr>j", "  b.png|loading image: decoding image: image: unknown format|[]|"},
+				{"j", `||["\x1b_Ga=T"]|`},
+				{"k", "  b.png||[\"\\x1b_Ga=d\"]|c.png\n"},
+			},
+		},
+		{
+			// previewers are used for images as well when they are set
+			name:  "image previewer",
+			files: []string{"a"},
+			setup: func(t *testing.T, app *App, wd string) {
+				gOpts.imagepreview = "kitty"
+
+				if err := ioutil.WriteFile(path.Join(wd, "b.png"), nil, 0644); err != nil {
+					t.Fatalf("writing file: %s", err)
+				}
+
+				gOpts.previewer = path.Join(t.TempDir(), "previewer")
+				if err := ioutil.WriteFile(gOpts.previewer, []byte("#!/bin/sh\necho preview\n"), 0755); err != nil {
+					t.Fatalf("writing previewer: %s", err)
+				}
+
+				screenRawOutput()
+			},
+			got: func(app *App, wd string) string {
+				return fmt.Sprintf("%s %q", winLine(app.ui.wins[len(app.ui.wins)-1], 0), screenRawOutput())
+			},
+			steps: []step{
+				{"j", "  preview []"},
+			},
+		},
+		{
+			// cleaner is run once when the previewed file changes and not on
+			// redraws
+			name:  "cleaner",
+			files: []string{"a", "b"},
+			setup: func(t *testing.T, app *App, wd string) {
+				dir := t.TempDir()
+				gOpts.previewer = path.Join(dir, "previewer")
+				if err := ioutil.WriteFile(gOpts.previewer, []byte("#!/bin/sh\necho preview\n"), 0755); err != nil {
+					t.Fatalf("writing previewer: %s", err)
+				}
+				gOpts.cleaner = path.Join(dir, "cleaner")
+				script := fmt.Sprintf("#!/bin/sh\necho \"$1\" >> %s/cleaned\n", dir)
+				if err := ioutil.WriteFile(gOpts.cleaner, []byte(script), 0755); err != nil {
+					t.Fatalf("writing cleaner: %s", err)
+				}
+			},
+			got: func(app *App, wd string) string {
+				// cleaner is run in the background
+				time.Sleep(100 * time.Millisecond)
+				cleaned, _ := ioutil.ReadFile(path.Join(path.Dir(gOpts.cleaner), "cleaned"))
+				return fmt.Sprintf("%s|%s", winLine(app.ui.wins[len(app.ui.wins)-1], 0),
+					strings.Replace(string(cleaned), wd+"/", "", -1))
+			},
+			steps: []step{
+				{"<c-l>", "  preview|"},
+				{"<c-l><c-l>", "  preview|"},
+				{"j", "  preview|a\n"},
+				{":set nopreview<cr>", "  a|a\nb\n"},
+			},
+		},
+		{
+			// options are sent to the server with broadcast unless they are
+			// sent by the server
+			name:  "broadcast",
+			files: []string{"a"},
+			setup: func(t *testing.T, app *App, wd string) {
+				sock := gSocketPath
+				gSocketPath = path.Join(t.TempDir(), "sock")
+				t.Cleanup(func() { gSocketPath = sock })
+
+				l, err := net.Listen("unix", gSocketPath)
+				if err != nil {
+					t.Fatalf("listening: %s", err)
+				}
+				t.Cleanup(func() { l.Close() })
+
+				gBroadcastSent = make(chan string, 10)
+				go func() {
+					for {
+						c, err := l.Accept()
+						if err != nil {
+							return
+						}
+						s := bufio.NewScanner(c)
+						for s.Scan() {
+							gBroadcastSent <- s.Text()
+						}
+						c.Close()
+					}
+				}()
+
+				gOpts.broadcast = true
+
+				(&RemoteExpr{&SetExpr{"reverse", ""}}).eval(app, nil)
+			},
+			got: func(app *App, wd string) string {
+				var sent string
+				select {
+				case sent = <-gBroadcastSent:
+				case <-time.After(200 * time.Millisecond):
+				}
+				return fmt.Sprintf("%t %t %q", gOpts.reverse, gOpts.hidden, sent)
+			},
+			steps: []step{
+				{"", `true false ""`},
+				{":set hidden<cr>", `true false "send set hidden "`},
+				{":set nobroadcast<cr>:set hidden<cr>", `true true ""`},
+			},
+		},
+		{
+			// marks are only shared with sharemarks and switching tabs does
+			// not share the marks of the new tab
+			name:  "share marks",
+			files: []string{"a", "b"},
+			setup: func(t *testing.T, app *App, wd string) {
+				sock := gSocketPath
+				gSocketPath = path.Join(t.TempDir(), "sock")
+				t.Cleanup(func() { gSocketPath = sock })
+
+				l, err := net.Listen("unix", gSocketPath)
+				if err != nil {
+					t.Fatalf("listening: %s", err)
+				}
+				t.Cleanup(func() { l.Close() })
+
+				gMarkList = nil
+				t.Cleanup(func() { gMarkList = nil })
+				go func() {
+					for {
+						c, err := l.Accept()
+						if err != nil {
+							return
+						}
+						handleConn(c)
+					}
+				}()
+			},
+			got: func(app *App, wd string) string {
+				marks, err := loadMarkList()
+				if err != nil {
+					return err.Error()
+				}
+				var names []string
+				for _, m := range marks {
+					names = append(names, path.Base(m))
+				}
+				return fmt.Sprintf("%v [%s]", names, markNames(app, wd))
+			},
+			steps: []step{
+				{"<space>", "[] [a]"},
+				{":set sharemarks<cr>", "[] [a]"},
+				{"<space>", "[a b] [a b]"},
+				{":tab-new<cr>", "[a b] []"},
+				{":tab-next<cr>", "[a b] [a b]"},
+			},
+		},
+		{
+			// visual bell is switched back from the main loop after a moment
+			name:  "visual bell",
+			files: []string{"a", "b"},
+			setup: func(t *testing.T, app *App, wd string) {
+				gOpts.bell = "visual"
+			},
+			got: func(app *App, wd string) string {
+				flash := screenRawOutput()
+				time.Sleep(2 * gFlashTime)
+				readEvents(app)
+				return fmt.Sprintf("%q %q", flash, screenRawOutput())
+			},
+			steps: []step{
+				{"j", `[] []`},
+				{":paste<cr>", `["\x1b[?5h"] ["\x1b[?5l"]`},
+			},
+		},
+		{
+			// indicators follow the path with multibyte characters
+			name: "header",
+			setup: func(t *testing.T, app *App, wd string) {
+				if err := os.Mkdir(path.Join(wd, "äö"), 0755); err != nil {
+					t.Fatalf("creating directory: %s", err)
+				}
+				ioutil.WriteFile(path.Join(wd, "äö", "x"), nil, 0644)
+			},
+			got: func(app *App, wd string) string {
+				header := strings.TrimSpace(screenLines()[0])
+				return header[strings.LastIndex(header, "/")+1:]
+			},
+			steps: []step{
+				{":reload<cr>l", "äö"},
+				{":filter x<cr>", "äö [filter: x]"},
+			},
+		},
+		{
+			// showinfo is an alias of info and none clears the columns
+			name:  "info",
+			files: []string{"a"},
+			got: func(app *App, wd string) string {
+				return fmt.Sprintf("%q %s", gOpts.info, app.ui.message)
+			},
+			steps: []step{
+				{":set info size,time<cr>", `["size" "time"] `},
+				{":set info none<cr>", `[] `},
+				{":set showinfo size<cr>", `["size"] `},
+				{":set showinfo none<cr>", `[] `},
+				{":set info size<cr>:set info<cr>", `[] `},
+				{":set showinfo foo<cr>", `[] showinfo: unknown type: foo (should be one of size, time, perm, user, group, link-target)`},
+			},
+		},
+		{
+			// digits pick completion candidates unless they continue one
+			name:  "completion digits",
+			files: []string{"a2", "a21", "ab"},
+			got:   currMessage,
+			steps: []step{
+				{":echo a<tab>3<cr>", "ab"},
+				{":echo a<tab>2<cr>", "a2"},
+				{":echo a2<tab>1<cr>", "a21"},
+			},
+		},
+		{
+			// shell commands may modify files so they are not allowed
+			name:  "readonly",
+			files: []string{"a"},
+			got: func(app *App, wd string) string {
+				_, err := os.Stat(path.Join(wd, "b"))
+				return fmt.Sprintf("%s %t", app.ui.message, os.IsNotExist(err))
+			},
+			steps: []step{
+				{":set readonly<cr>:$touch b<cr>", "shell: not allowed in readonly mode true"},
+				{":&touch b<cr>", "shell-async: not allowed in readonly mode true"},
+				{":cmd mk $touch b<cr>:mk<cr>", "shell: not allowed in readonly mode true"},
+				{"$", "read-shell: not allowed in readonly mode true"},
+				{":delete<cr>", "delete: not allowed in readonly mode true"},
+			},
+		},
+		{
+			name:  "cmd",
+			files: []string{"a", "b", "c"},
+			got:   currName,
+			steps: []step{
+				{":cmd skip :down; down<cr>:map x skip<cr>x", "c"},
+			},
+		},
+		{
+			name:  "removed cmd",
+			files: []string{"a"},
+			got:   currMessage,
+			steps: []step{
+				{":cmd skip :down; down<cr>:cmd skip<cr>:skip<cr>", "command not found: skip"},
+			},
+		},
+		{
+			name:  "tree",
+			files: []string{"a"},
+			setup: writeTree,
+			got: func(app *App, wd string) string {
+				return fmt.Sprintf("%s (%s)", dirNames(app, wd), currName(app, wd))
+			},
+			steps: []step{
+				{"<c-l>ggzo", "sub sub/inner sub/x a (sub)"},
+				{"jzo", "sub sub/inner sub/inner/y sub/x a (sub/inner)"},
+				{"jzc", "sub sub/inner sub/x a (sub/inner)"},
+				{"jzc", "sub a (sub)"},
+				{"zojzt", "sub a (sub)"},
+			},
+		},
+		{
+			name:  "tree drawing",
+			files: []string{"a"},
+			setup: writeTree,
+			got: func(app *App, wd string) string {
+				return winLine(currWin(app), 1)
+			},
+			steps: []step{
+				{"<c-l>ggzo", "    + inner"},
+			},
+		},
+		{
+			name:  "tree depth",
+			files: []string{"a"},
+			setup: func(t *testing.T, app *App, wd string) {
+				writeTree(t, app, wd)
+				gOpts.treedepth = 1
+			},
+			got: currMessage,
+			steps: []step{
+				{"<c-l>ggzoj:tree-expand<cr>", "tree-expand: tree depth limit reached: 1"},
+			},
+		},
+		{
+			// opening an expanded entry opens its parents as well
+			name:  "tree open",
+			files: []string{"a"},
+			setup: writeTree,
+			got: func(app *App, wd string) string {
+				n := len(app.nav.dirs)
+				return relPath(wd, app.nav.dirs[n-2].path) + " " + relPath(wd, app.nav.dirs[n-1].path)
+			},
+			steps: []step{
+				{"<c-l>ggzojl", "sub sub/inner"},
+			},
+		},
+		{
+			name:  "flatten",
+			files: []string{"a"},
+			setup: func(t *testing.T, app *App, wd string) {
+				writeTree(t, app, wd)
+				os.Mkdir(path.Join(wd, "sub", "inner", "deep"), 0755)
+				ioutil.WriteFile(path.Join(wd, "sub", "inner", "deep", "z"), nil, 0644)
+				gOpts.flattendepth = 2
+			},
+			got: func(app *App, wd string) string {
+				return fmt.Sprintf("%s (%s) [%s]", dirNames(app, wd), currName(app, wd), markNames(app, wd))
+			},
+			steps: []step{
+				{"<c-l>:flatten<cr>", "a sub/inner/y sub/x (a) []"},
+				{"j<space>", "a sub/inner/y sub/x (sub/x) [sub/inner/y]"},
+				{":flatten<cr>", "sub a (sub) [sub/inner/y]"},
+			},
+		},
+		{
+			// notations are typed as keys in the prompt so bindings are read
+			// as in the configuration file
+			name:  "key notation",
+			files: []string{"a", "b", "c"},
+			setup: func(t *testing.T, app *App, wd string) {
+				p := newParser(strings.NewReader("map <a-j> bot\nmap <f2> top\nmap <c-h> down\n"))
+				for p.parse() {
+					p.expr.eval(app, nil)
+				}
+			},
+			got: currName,
+			steps: []step{
+				{"<a-j>", "c"},
+				{"<f2>", "a"},
+				{"<bs>", "b"},
+			},
+		},
+		{
+			name:  "unknown key notation",
+			files: []string{"a"},
+			setup: func(t *testing.T, app *App, wd string) {
+				(&MapExpr{"<c-foo>", &CallExpr{"top", nil}}).eval(app, nil)
+			},
+			got: currMessage,
+			steps: []step{
+				{"", "map: unknown key: <c-foo>"},
+			},
+		},
+		{
+			name:  "count",
+			files: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"},
+			got:   currName,
+			steps: []step{
+				{"5j", "f"},
+				{"2k", "d"},
+				{"3G", "c"},
+				{"gg10j", "k"},
+				{"2gg", "b"},
+				{"99j", "l"},
+			},
+		},
+		{
+			name:  "count repeat",
+			files: []string{"a", "b", "c"},
+			got:   markNames,
+			steps: []step{
+				{"gg2<space>", "a b"},
+			},
+		},
+		{
+			// marks are listed sorted by their paths
+			name:  "selection list",
+			files: []string{"a", "b"},
+			setup: func(t *testing.T, app *App, wd string) {
+				os.Mkdir(path.Join(wd, "sub"), 0755)
+				ioutil.WriteFile(path.Join(wd, "sub", "x"), nil, 0644)
+			},
+			got: func(app *App, wd string) string {
+				return fmt.Sprintf("%s [%s]", relPath(wd, app.nav.currPath()), markNames(app, wd))
+			},
+			steps: []step{
+				{"<c-l>ggj<space><space>ggl<space>h", "sub [a b sub/x]"},
+				{":selection-list<cr>jd<cr>", "sub/x [a sub/x]"},
+			},
+		},
+		{
+			// entries in the range are drawn with the background of the
+			// visual style
+			name:  "visual drawing",
+			files: []string{"a", "b", "c", "d", "e"},
+			got: func(app *App, wd string) string {
+				win := currWin(app)
+				bg := getColors().ui("visual").bg
+				var s string
+				for i := 0; i < 5; i++ {
+					if screenCell(win.x+1, win.y+i).Bg == bg {
+						s += "v"
+					} else {
+						s += "-"
+					}
+				}
+				return s
+			},
+			steps: []step{
+				{"<c-l>ggjvjj", "-vvv-"},
+				{"v", "-----"},
+			},
+		},
+		{
+			name:  "visual",
+			files: []string{"a", "b", "c", "d", "e"},
+			got: func(app *App, wd string) string {
+				return fmt.Sprintf("[%s] (%q)", markNames(app, wd), app.nav.currDir().visual)
+			},
+			steps: []step{
+				{"<c-l>ggjvjj", `[] ("b")`},
+				{"v", `[b c d] ("")`},
+				{"vk<esc>", `[b c d] ("")`},
+			},
+		},
+		{
+			name:  "glob select",
+			files: []string{"a.go", "b.go", "c.txt"},
+			got:   markNames,
+			steps: []step{
+				{":glob-select *.go<cr>", "a.go b.go"},
+				{":invert<cr>", "c.txt"},
+				{":glob-select [ab]*<cr>", "a.go b.go c.txt"},
+				{":glob-unselect *.txt<cr>", "a.go b.go"},
+				{":unselect<cr>", ""},
+			},
+		},
+		{
+			// file status is shown at the left when there is no message
+			name:  "status line",
+			files: []string{"a", "b", "c"},
+			got: func(app *App, wd string) string {
+				lines := screenLines()
+				return strings.Fields(lines[len(lines)-1])[0] + " " + currRuler(app, wd)
+			},
+			steps: []step{
+				{"<c-l>gg", "-rw-r--r-- [normal][natural][1/3]"},
+				{"<space>", "-rw-r--r-- [normal][1 marked][natural][2/3]"},
+				{":filter c<cr>", "-rw-r--r-- [normal][1 marked][filter][natural][1/1]"},
+				{":set reverse<cr>", "-rw-r--r-- [normal][1 marked][filter][natural↓][1/1]"},
+			},
+		},
+		{
+			name:  "ruler",
+			files: []string{"a", "b", "c"},
+			setup: func(t *testing.T, app *App, wd string) {
+				t.Setenv("LF_TEST_RULER", "[main]")
+			},
+			got: currRuler,
+			steps: []step{
+				{"<c-l>gg<space>k:set ruler position:%{LF_TEST_RULER}:ind<cr>", "[1/3][main][natural]"},
+				{":set ruler selection:filter<cr>", "[1 marked]"},
+				{":set ruler mode:position<cr>v", "[visual][1/3]"},
+				{"v", "[normal][1/3]"},
+			},
+		},
+		{
+			name:  "unknown ruler segment",
+			files: []string{"a"},
+			got: func(app *App, wd string) string {
+				return strings.Join(gOpts.ruler, ":") + " " + strings.SplitN(app.ui.message, " (", 2)[0]
+			},
+			steps: []step{
+				{":set ruler position<cr>:set ruler position:foo<cr>", "position ruler: unknown segment: foo"},
+			},
+		},
+		{
+			// panes are recreated keeping tabs and invalid ratios are refused
+			name:  "ratios",
+			files: []string{"a"},
+			got: func(app *App, wd string) string {
+				return fmt.Sprintf("%d panes %t", len(app.ui.wins), app.ui.tabs != nil)
+			},
+			steps: []step{
+				{":set ratios 1:1:1:2<cr>", "4 panes true"},
+				{":set ratios 1:3<cr>", "2 panes true"},
+				{":set ratios 0:1<cr>", "2 panes true"},
+				{":set ratios 1:x<cr>", "2 panes true"},
+			},
+		},
+		{
+			// panes added at the left show the parents of the current directory
+			name:  "ratios parents",
+			files: []string{"a"},
+			got: func(app *App, wd string) string {
+				_, _, doff, length := app.ui.panes(app.nav)
+				return fmt.Sprintf("%d %t", length, app.nav.dirs[doff].path == path.Dir(path.Dir(wd)))
+			},
+			steps: []step{
+				{":set ratios 1:1:1:2<cr>", "3 true"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(o Opts) { gOpts = o }(gOpts)
+
+			// bindings and commands are copied so that they are restored
+			keys, cmds := make(map[string]Expr), make(map[string]Expr)
+			for k, e := range gOpts.keys {
+				keys[k] = e
+			}
+			for k, e := range gOpts.cmds {
+				cmds[k] = e
+			}
+			gOpts.keys, gOpts.cmds = keys, cmds
+
+			app, cleanup := startHeadless(t, test.files)
+			defer cleanup()
+
+			wd := app.nav.currDir().path
+
+			if test.setup != nil {
+				test.setup(t, app, wd)
+			}
+
+			for _, s := range test.steps {
+				typeKeys(app, s.keys)
+				if got := test.got(app, wd); got != s.exp {
+					t.Errorf("at input '%s' expected '%s' but got '%s'", s.keys, s.exp, got)
+				}
+			}
+		})
+	}
+}
+
+// Lines received by the fake server in broadcast cases.
+var gBroadcastSent chan string
+
+// This function creates a subdirectory with files for tree mode cases.
+func writeTree(t *testing.T, app *App, wd string) {
+	if err := os.MkdirAll(path.Join(wd, "sub", "inner"), 0755); err != nil {
+		t.Fatalf("creating directory: %s", err)
+	}
+	ioutil.WriteFile(path.Join(wd, "sub", "x"), nil, 0644)
+	ioutil.WriteFile(path.Join(wd, "sub", "inner", "y"), nil, 0644)
+}
+
+func TestHeadlessRemovedDir(t *testing.T) {
+	app, cleanup := startHeadless(t, []string{"foo"})
+	defer cleanup()
+
+	wd := app.nav.currDir().path
+	sub := path.Join(wd, "sub", "subsub")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatalf("creating directory: %s", err)
+	}
+
+	if err := app.nav.cd(sub); err != nil {
+		t.Fatalf("changing directory: %s", err)
+	}
+	app.waitDirs()
+
+	if err := os.RemoveAll(path.Join(wd, "sub")); err != nil {
+		t.Fatalf("removing directory: %s", err)
+	}
+
+	if msg := app.nav.checkRemoved(); msg == "" {
+		t.Errorf("at removed directory expected a message but got none")
+	}
+
+	if p := app.nav.currDir().path; p != wd {
+		t.Errorf("at removed directory expected '%s' but got '%s'", wd, p)
+	}
+
+	if msg := app.nav.checkRemoved(); msg != "" {
+		t.Errorf("at existing directory expected no message but got '%s'", msg)
+	}
+
+	// removal is noticed right away on reloads
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatalf("creating directory: %s", err)
+	}
+	if err := app.nav.cd(sub); err != nil {
+		t.Fatalf("changing directory: %s", err)
+	}
+	app.waitDirs()
+	if err := os.RemoveAll(path.Join(wd, "sub")); err != nil {
+		t.Fatalf("removing directory: %s", err)
+	}
+
+	typeKeys(app, ":reload<cr>")
+
+	if p := app.nav.currDir().path; p != wd || !strings.HasPrefix(app.ui.message, "directory removed") {
+		t.Errorf("at reload expected '%s' but got '%s' (%s)", wd, p, app.ui.message)
+	}
+}
+
+func TestHeadlessMouse(t *testing.T) {
+	app, cleanup := startHeadless(t, []string{"foo", "bar", "baz"})
+	defer cleanup()
+
+	_, woff, _, length := app.ui.panes(app.nav)
+	win := app.ui.wins[woff+length-1]
+
+	// mouse events are ignored unless the option is set
+	screenFeedMouse(termbox.MouseLeft, win.x+2, win.y+2)
+	readEvents(app)
+	if name := app.nav.currFile().Name(); name != "bar" {
+		t.Errorf("at click without mouse option expected 'bar' but got '%s'", name)
+	}
+
+	typeKeys(app, ":set mouse<cr>")
+
+	tests := []struct {
+		key  termbox.Key
+		line int
+		exp  string
+	}{
+		{termbox.MouseLeft, 2, "foo"},
+		{termbox.MouseLeft, 1, "baz"},
+		{termbox.MouseLeft, 5, "baz"},
+		{termbox.MouseWheelUp, 0, "bar"},
+		{termbox.MouseWheelDown, 0, "foo"},
+	}
+
+	for _, test := range tests {
+		screenFeedMouse(test.key, win.x+2, win.y+test.line)
+		readEvents(app)
+		app.ui.clicked = time.Time{}
+		if name := app.nav.currFile().Name(); name != test.exp {
+			t.Errorf("at mouse key %d on line %d expected '%s' but got '%s'", test.key, test.line, test.exp, name)
+		}
+	}
+}
+
+func TestHeadlessArchive(t *testing.T) {
+	app, cleanup := startHeadless(t, nil)
+	defer cleanup()
+	defer cleanArchives()
+
+	wd := app.nav.currDir().path
+	writeTestArchives(t, wd)
+
+	tests := []struct {
+		keys  string
+		dir   string
+		names string
+	}{
+		{"<c-l>l", path.Join(wd, "test.tar.gz"), "dir foo"},
+		{"l", path.Join(wd, "test.tar.gz", "dir"), "sub bar"},
+		{"h", path.Join(wd, "test.tar.gz"), "dir foo"},
+		{"hjl", path.Join(wd, "test.zip"), "dir foo"},
+		{"h", wd, "test.tar.gz test.zip"},
+	}
+
+	for _, test := range tests {
+		typeKeys(app, test.keys)
+
+		// directories are read in the background
+		dir := app.nav.currDir()
+		for dir.loading {
+			app.dirLoaded(<-gDirChan)
+		}
+
+		var names []string
+		for _, f := range dir.fi {
+			names = append(names, f.Name())
+		}
+
+		if dir.path != test.dir || strings.Join(names, " ") != test.names {
+			t.Errorf("at input '%s' expected '%s' with '%s' but got '%s' with '%s'",
+				test.keys, test.dir, test.names, dir.path, strings.Join(names, " "))
+		}
+	}
+
+	typeKeys(app, "l")
+	if err := app.nav.rename("foo", "bar", false); err != errReadOnly {
+		t.Errorf("at rename expected read-only error but got: %v", err)
+	}
+}
+
+func TestHeadlessScreenReader(t *testing.T) {
+	app, cleanup := startHeadless(t, []string{"a", "b"})
+	defer cleanup()
+
+	wd := app.nav.currDir().path
+	out := path.Join(wd, "announce")
+
+	defer func(o Opts) { gOpts = o }(gOpts)
+	defer closeAnnounce()
+	gOpts.screenreader = true
+	gOpts.announce = out
+
+	typeKeys(app, "j")
+
+	win := app.ui.wins[len(app.ui.wins)-2]
+	if !gOpts.preview || len(app.ui.wins) < 2 {
+		win = app.ui.wins[len(app.ui.wins)-1]
+	}
+	if gScreen.cx != win.x+1 || gScreen.cy != win.y+1 {
+		t.Errorf("at cursor expected '%d,%d' but got '%d,%d'", win.x+1, win.y+1, gScreen.cx, gScreen.cy)
+	}
+
+	typeKeys(app, "k")
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading announce file: %s", err)
+	}
+
+	var files []string
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	for _, l := range lines {
+		if strings.HasSuffix(l, "/2") {
+			files = append(files, l)
+		}
+	}
+
+	if lines[0] != wd {
+		t.Errorf("at first announcement expected '%s' but got '%s'", wd, lines[0])
+	}
+
+	if exp := "b 2/2,a 1/2"; strings.Join(files, ",") != exp {
+		t.Errorf("at announcements expected '%s' but got '%s'", exp, strings.Join(files, ","))
+	}
+}
+
+func TestHeadlessShellPipe(t *testing.T) {
+	app, cleanup := startHeadless(t, []string{"a"})
+	defer cleanup()
+
+	app.dir = app.nav.currDir().path
+
+	(&ExecExpr{"%", "echo foo; echo bar >b", false}).eval(app, nil)
+
+	// output lines are sent to the main loop followed by a redraw
+	for {
+		e := <-app.exprChan
+		e.eval(app, nil)
+		if e.String() == (&CallExpr{"redraw", nil}).String() {
+			break
+		}
+	}
+
+	if app.ui.message != "foo" {
+		t.Errorf("at message expected 'foo' but got '%s'", app.ui.message)
+	}
+
+	if n := len(app.nav.currDir().fi); n != 2 {
+		t.Errorf("at files expected 2 but got %d", n)
+	}
+}
+
+func TestHeadlessCopyContent(t *testing.T) {
+	app, cleanup := startHeadless(t, []string{"a"})
+	defer cleanup()
+
+	wd := app.nav.currDir().path
+	clip := path.Join(os.TempDir(), "lf-test-clip")
+	defer os.Remove(clip)
+
+	defer func(o Opts) { gOpts = o }(gOpts)
+	gOpts.clipboard = "cat >" + shellQuote(clip)
+	gOpts.clipsize = 1
+
+	ioutil.WriteFile(path.Join(wd, "big"), make([]byte, 2048), 0644)
+	ioutil.WriteFile(path.Join(wd, "bin"), []byte("foo\x00bar"), 0644)
+
+	tests := []struct {
+		name string
+		msg  string
+	}{
+		{"a", "copied content of a to clipboard"},
+		{"big", "copy-content: file is larger than 1K: big"},
+		{"bin", "copy-content: binary file: bin"},
+	}
+
+	typeKeys(app, "<c-l>")
+
+	for _, test := range tests {
+		os.Remove(clip)
+
+		dir := app.nav.currDir()
+		dir.load(dir.ind, dir.pos, app.nav.height, test.name)
+
+		(&CallExpr{"copy-content", nil}).eval(app, nil)
+
+		if app.ui.message != test.msg {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", test.name, test.msg, app.ui.message)
+		}
+
+		b, err := ioutil.ReadFile(clip)
+		if copied := err == nil; copied != (test.name == "a") {
+			t.Errorf("at input '%s' expected copied %t but got '%s' (%v)", test.name, test.name == "a", b, err)
+		}
+	}
+}
+
+func TestHeadlessOpenDirInTab(t *testing.T) {
+	app, cleanup := startHeadless(t, []string{"a"})
+	defer cleanup()
+
+	wd := app.nav.currDir().path
+	sub := path.Join(wd, "sub")
+	os.Mkdir(sub, 0755)
+	ioutil.WriteFile(path.Join(sub, "b"), nil, 0644)
+	ioutil.WriteFile(path.Join(sub, "c"), nil, 0644)
+	os.Symlink(path.Join(sub, "c"), path.Join(wd, "link"))
+
+	typeKeys(app, "<c-l>")
+
+	dir := app.nav.currDir()
+	dir.load(dir.ind, dir.pos, app.nav.height, "link")
+
+	tests := []struct {
+		cmd  *CallExpr
+		tabs int
+		ind  int
+		path string
+	}{
+		{&CallExpr{"open-dir-in-tab", nil}, 2, 1, path.Join(sub, "c")},
+		{&CallExpr{"tab-prev", nil}, 2, 0, path.Join(wd, "link")},
+		{&CallExpr{"open-dir-other", []string{path.Join(sub, "b")}}, 2, 1, path.Join(sub, "b")},
+		{&CallExpr{"open-dir-other", []string{"../a"}}, 2, 0, path.Join(wd, "a")},
+	}
+
+	for _, test := range tests {
+		test.cmd.eval(app, nil)
+
+		if len(app.tabs.navs) != test.tabs || app.tabs.ind != test.ind || app.nav.currPath() != test.path {
+			t.Errorf("at input '%s' expected tab %d/%d at '%s' but got %d/%d at '%s'", test.cmd,
+				test.ind, test.tabs, test.path, app.tabs.ind, len(app.tabs.navs), app.nav.currPath())
+		}
+	}
+}
+
+func TestHeadlessReadConfig(t *testing.T) {
+	app, cleanup := startHeadless(t, []string{"a"})
+	defer cleanup()
+
+	defer func(o Opts) { gOpts = o }(gOpts)
+
+	wd := app.nav.currDir().path
+
+	good := path.Join(wd, "good")
+	ioutil.WriteFile(good, []byte("set nohidden\ncmd greet :echo hi\n"), 0644)
+
+	if err := app.readConfig(good); err != nil {
+		t.Errorf("at good config expected no error but got '%s'", err)
+	}
+	if _, ok := gOpts.cmds["greet"]; !ok {
+		t.Errorf("at good config expected command 'greet' to be defined")
+	}
+
+	// files with syntax errors are not evaluated at all
+	bad := path.Join(wd, "bad")
+	ioutil.WriteFile(bad, []byte("set hidden\ncmd broken ${{\n\techo\n"), 0644)
+
+	exp := bad + ":2:13: unterminated '{{'"
+	if err := app.readConfig(bad); err == nil || err.Error() != exp {
+		t.Errorf("at bad config expected '%s' but got '%v'", exp, err)
+	}
+	if gOpts.hidden {
+		t.Errorf("at bad config expected hidden to be unchanged")
+	}
+}
+
+func TestHeadlessSelectionPath(t *testing.T) {
+	app, cleanup := startHeadless(t, []string{"a", "b", "c"})
+	defer cleanup()
+
+	wd := app.nav.currDir().path
+	out := path.Join(os.TempDir(), "lf-test-selection")
+	defer os.Remove(out)
+
+	defer func(p string) { gSelectionPath, gExitFlag = p, false }(gSelectionPath)
+	gSelectionPath = out
+
+	typeKeys(app, "jj<space>gg<space>l")
+
+	if !gExitFlag {
+		t.Errorf("at open expected exit")
+	}
+
+	exp := path.Join(wd, "a") + "\n" + path.Join(wd, "c") + "\n"
+	if b, err := ioutil.ReadFile(out); err != nil || string(b) != exp {
+		t.Errorf("at selection expected '%s' but got '%s' (%v)", exp, b, err)
+	}
+
+	defer func() { gSelection = "" }()
+	gSelectionPath, gExitFlag = "-", false
+	app.nav.marks = make(map[string]bool)
+
+	typeKeys(app, "gg<c-l>l")
+
+	if exp := path.Join(wd, "a") + "\n"; gSelection != exp {
+		t.Errorf("at stdout selection expected '%s' but got '%s'", exp, gSelection)
+	}
+}
+
+func TestHeadlessDiskUsage(t *testing.T) {
+	app, cleanup := startHeadless(t, []string{"a"})
+	defer cleanup()
+
+	wd := app.nav.currDir().path
+	os.Mkdir(path.Join(wd, "big"), 0755)
+	os.Mkdir(path.Join(wd, "small"), 0755)
+	ioutil.WriteFile(path.Join(wd, "big", "x"), make([]byte, 100000), 0644)
+	ioutil.WriteFile(path.Join(wd, "small", "x"), make([]byte, 10000), 0644)
+
+	typeKeys(app, "<c-l>:du<cr>")
+
+	// sizes of directories are sent to the main loop when they are ready
+	for i := 0; i < 2; i++ {
+		app.nav.usageLoaded(<-gDirSizeChan)
+	}
+
+	var names []string
+	for _, f := range app.nav.currDir().fi {
+		names = append(names, f.Name())
+	}
+	if s := strings.Join(names, " "); s != "big small a" {
+		t.Errorf("at du expected 'big small a' but got '%s'", s)
+	}
+
+	app.ui.draw(app.nav)
+
+	if lines := strings.Join(screenLines(), "\n"); !strings.Contains(lines, "[##########] big") {
+		t.Errorf("at du expected a full bar for 'big' but got:\n%s", lines)
+	}
+
+	typeKeys(app, ":du<cr>")
+
+	if f := app.nav.currDir().fi[0].Name(); f != "big" || app.nav.currDir().du {
+		t.Errorf("at du off expected 'big' first but got '%s'", f)
+	}
+}
+
+func TestHeadlessConfirmThresholds(t *testing.T) {
+	app, cleanup := startHeadless(t, []string{"a", "b"})
+	defer cleanup()
+
+	defer func(o Opts) { gOpts = o }(gOpts)
+
+	wd := app.nav.currDir().path
+	ioutil.WriteFile(path.Join(wd, "big"), make([]byte, 2000000), 0644)
+
+	typeKeys(app, "<c-l>gg:delete<cr>n<cr>")
+
+	if _, err := os.Stat(path.Join(wd, "a")); err != nil {
+		t.Errorf("at delete expected confirmation but got '%s'", err)
+	}
+
+	// deletions up to the threshold run without confirmation
+	gOpts.confirmdelete = 1
+	typeKeys(app, ":delete<cr>")
+	app.jobDone(<-gJobDone)
+
+	if _, err := os.Stat(path.Join(wd, "a")); !os.IsNotExist(err) {
+		t.Errorf("at delete expected 'a' to be removed but got '%v'", err)
+	}
+
+	gOpts.confirmpaste = 1
+	os.Mkdir(path.Join(wd, "sub"), 0755)
+	jobs := len(gJobs)
+	typeKeys(app, "<c-l>:select big<cr>y:cd sub<cr>pn<cr>")
+
+	if n := len(gJobs); n != jobs {
+		t.Errorf("at paste expected confirmation but got %d new jobs", n-jobs)
+	}
+}
+
+func TestHeadlessReloadLoading(t *testing.T) {
+	app, cleanup := startHeadless(t, []string{"a"})
+	defer cleanup()
+
+	wd := app.nav.currDir().path
+	sub := path.Join(wd, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("creating directory: %s", err)
+	}
+	if err := ioutil.WriteFile(path.Join(sub, "b"), nil, 0644); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+
+	// directory is reloaded while it is still being read in the background
+	dir := app.nav.loadDir(sub)
+	app.nav.dirs = append(app.nav.dirs, dir)
+	app.nav.reload()
+
+	for dir.loading {
+		select {
+		case d := <-gDirChan:
+			app.dirLoaded(d)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("at reload expected '%s' to be loaded", sub)
+		}
+	}
+
+	if len(dir.fi) != 1 || dir.fi[0].Name() != "b" {
+		t.Errorf("at reload expected 'b' in '%s' but got %d files", sub, len(dir.fi))
+	}
+
+	// the read started by reload is drained so that it is not left for other tests
+	select {
+	case d := <-gDirChan:
+		app.dirLoaded(d)
+	case <-time.After(5 * time.Second):
+	}
+}
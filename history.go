@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+)
+
+// History of commands read from the prompt is kept in a file with an entry on
+// each line starting with the prefix of the prompt (e.g. ':set hidden' or
+// '$make') so that it is shared between clients. Oldest entries are dropped
+// when there are more than 'history' entries.
+func loadHistory() ([]string, error) {
+	f, err := os.Open(gHistoryPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var hist []string
+
+	s := bufio.NewScanner(f)
+
+	for s.Scan() {
+		if line := s.Text(); line != "" {
+			hist = append(hist, line)
+		}
+	}
+
+	return hist, s.Err()
+}
+
+// This function reports whether inputs read with the given prompt prefix are
+// kept in the history.
+func isHistoryPrefix(pref string) bool {
+	switch pref {
+	case ":", "$", "%", "!", "&":
+		return true
+	}
+	return false
+}
+
+// This function returns the entries of the history read with the given prompt
+// prefix, oldest first. Shell prompts share their entries.
+func prefixHistory(hist []string, pref string) []string {
+	var entries []string
+	for _, h := range hist {
+		if h[:1] == pref || pref != ":" && h[:1] != ":" {
+			entries = append(entries, h[1:])
+		}
+	}
+	return entries
+}
+
+// This function adds the given input read with the given prompt prefix to the
+// history. Earlier occurrences of the same entry are removed.
+func addHistory(pref, s string) error {
+	if gOpts.history == 0 || strings.TrimSpace(s) == "" || strings.ContainsRune(s, '\n') {
+		return nil
+	}
+
+	hist, err := loadHistory()
+	if err != nil {
+		return err
+	}
+
+	entry := pref + s
+
+	var entries []string
+	for _, h := range hist {
+		if h != entry {
+			entries = append(entries, h)
+		}
+	}
+	entries = append(entries, entry)
+
+	if len(entries) > gOpts.history {
+		entries = entries[len(entries)-gOpts.history:]
+	}
+
+	if err := os.MkdirAll(path.Dir(gHistoryPath), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(gHistoryPath)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, h := range entries {
+		w.WriteString(h)
+		w.WriteByte('\n')
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// This function returns the index of the most recent entry before the given
+// index containing the given pattern or -1 if there is none.
+func searchHistory(entries []string, pattern string, ind int) int {
+	for i := min(ind, len(entries)) - 1; i >= 0; i-- {
+		if strings.Contains(entries[i], pattern) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(p string) { gHistoryPath = p }(gHistoryPath)
+	gHistoryPath = path.Join(dir, "lf", "history")
+
+	defer func(n int) { gOpts.history = n }(gOpts.history)
+	gOpts.history = 3
+
+	for _, s := range [][2]string{{":", "a"}, {"$", "b"}, {":", "c"}, {":", "a"}, {"!", "d"}, {":", " "}} {
+		if err := addHistory(s[0], s[1]); err != nil {
+			t.Fatalf("adding history: %s", err)
+		}
+	}
+
+	hist, err := loadHistory()
+	if err != nil {
+		t.Fatalf("loading history: %s", err)
+	}
+
+	if exp := []string{":c", ":a", "!d"}; !reflect.DeepEqual(hist, exp) {
+		t.Errorf("expected '%v' but got '%v'", exp, hist)
+	}
+
+	tests := []struct {
+		pref string
+		exp  []string
+	}{
+		{":", []string{"c", "a"}},
+		{"$", []string{"d"}},
+		{"&", []string{"d"}},
+	}
+
+	for _, test := range tests {
+		if entries := prefixHistory(hist, test.pref); !reflect.DeepEqual(entries, test.exp) {
+			t.Errorf("at input '%s' expected '%v' but got '%v'", test.pref, test.exp, entries)
+		}
+	}
+}
+
+func TestSearchHistory(t *testing.T) {
+	entries := []string{"echo foo", "set hidden", "echo bar"}
+
+	tests := []struct {
+		pattern string
+		ind     int
+		exp     int
+	}{
+		{"echo", 3, 2},
+		{"echo", 2, 0},
+		{"echo", 0, -1},
+		{"hid", 3, 1},
+		{"qux", 3, -1},
+		{"", 3, 2},
+	}
+
+	for _, test := range tests {
+		if i := searchHistory(entries, test.pattern, test.ind); i != test.exp {
+			t.Errorf("at input '%s' %d expected '%d' but got '%d'", test.pattern, test.ind, test.exp, i)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// IconMap is used to keep glyphs shown before file names when 'icons' option
+// is set. Keys are either file types as in 'LS_COLORS' (e.g. 'di' for
+// directories) or extension patterns (e.g. '*.go'). Default glyphs are taken
+// from nerd fonts and they can be overridden in the icons file with lines
+// consisting of a key and a glyph seperated with whitespace.
+type IconMap map[string]string
+
+var gIcons IconMap
+
+func defaultIcons() IconMap {
+	return IconMap{
+		"di":     "",
+		"fi":     "",
+		"ln":     "",
+		"ex":     "",
+		"pi":     "",
+		"so":     "",
+		"bd":     "",
+		"*.go":   "",
+		"*.c":    "",
+		"*.py":   "",
+		"*.js":   "",
+		"*.sh":   "",
+		"*.md":   "",
+		"*.txt":  "",
+		"*.pdf":  "",
+		"*.jpg":  "",
+		"*.png":  "",
+		"*.gif":  "",
+		"*.mp3":  "",
+		"*.mp4":  "",
+		"*.zip":  "",
+		"*.tar":  "",
+		"*.gz":   "",
+		"*.xz":   "",
+		"*.bz2":  "",
+		"*.7z":   "",
+		"*.rar":  "",
+		"*.html": "",
+		"*.css":  "",
+		"*.json": "",
+	}
+}
+
+// Icons are loaded on first use to avoid slowing down the startup when the
+// option is not set.
+func getIcons() IconMap {
+	if gIcons == nil {
+		gIcons = defaultIcons()
+		gIcons.load(gIconsPath)
+	}
+	return gIcons
+}
+
+func (im IconMap) load(filename string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("opening icons file: %s", err)
+		}
+		return
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		toks := strings.Fields(line)
+		if len(toks) != 2 {
+			log.Printf("invalid line in icons file: %s", line)
+			continue
+		}
+
+		im[toks[0]] = toks[1]
+	}
+
+	if s.Err() != nil {
+		log.Printf("reading icons file: %s", s.Err())
+	}
+}
+
+func (im IconMap) get(f os.FileInfo) string {
+	switch {
+	case f.IsDir():
+		return im["di"]
+	case f.Mode()&os.ModeSymlink != 0:
+		return im["ln"]
+	case f.Mode()&os.ModeNamedPipe != 0:
+		return im["pi"]
+	case f.Mode()&os.ModeSocket != 0:
+		return im["so"]
+	case f.Mode()&os.ModeDevice != 0:
+		return im["bd"]
+	}
+
+	if ext := path.Ext(f.Name()); ext != "" {
+		if icon, ok := im["*"+strings.ToLower(ext)]; ok {
+			return icon
+		}
+	}
+
+	if f.Mode()&0111 != 0 {
+		return im["ex"]
+	}
+
+	return im["fi"]
+}
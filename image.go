@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Methods used to draw images in the preview pane.
+var gImageMethods = []string{"auto", "none", "sixel", "kitty", "ueberzug"}
+
+func isImageMethod(s string) bool {
+	for _, m := range gImageMethods {
+		if s == m {
+			return true
+		}
+	}
+	return false
+}
+
+// Extensions of files previewed as images. Other files are previewed as text.
+var gImageExts = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+}
+
+func isImage(path string) bool {
+	return gImageExts[strings.ToLower(filepath.Ext(path))]
+}
+
+// This function returns the method used to draw images in the preview pane.
+// It returns an empty string when images are not supported so that image
+// files are previewed as text instead.
+func imageMethod() string {
+	switch gOpts.imagepreview {
+	case "none":
+		return ""
+	case "auto":
+		return detectImageMethod(os.Getenv, exec.LookPath)
+	}
+	return gOpts.imagepreview
+}
+
+// This function detects the image protocol supported by the terminal using
+// environment variables since terminals can not be queried while termbox is
+// reading the input. Ueberzug is used on other terminals under X when it is
+// installed.
+func detectImageMethod(getenv func(string) string, lookPath func(string) (string, error)) string {
+	term := getenv("TERM")
+
+	if getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" {
+		return "kitty"
+	}
+
+	for _, s := range []string{"sixel", "mlterm", "foot", "yaft"} {
+		if strings.Contains(term, s) {
+			return "sixel"
+		}
+	}
+
+	if getenv("DISPLAY") != "" {
+		if _, err := lookPath("ueberzug"); err == nil {
+			return "ueberzug"
+		}
+	}
+
+	return ""
+}
+
+// Image is an image drawn in the preview pane at the given cell position and
+// size.
+type Image struct {
+	method     string
+	path       string
+	x, y, w, h int
+}
+
+// Pixel size of a cell used when the terminal does not report it.
+const gCellWidth, gCellHeight = 8, 16
+
+// This function returns the pixel size of a cell reported by the terminal.
+func cellSize() (int, int) {
+	var ws struct {
+		row, col, xpixel, ypixel uint16
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdout.Fd(),
+		uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+
+	if errno != 0 || ws.col == 0 || ws.row == 0 || ws.xpixel == 0 || ws.ypixel == 0 {
+		return gCellWidth, gCellHeight
+	}
+
+	return int(ws.xpixel / ws.col), int(ws.ypixel / ws.row)
+}
+
+// This function decodes the given image file and scales it down to fit in
+// the given number of pixels keeping the aspect ratio. Images are never
+// scaled up.
+func loadImage(path string, w, h int) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening image: %s", err)
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %s", err)
+	}
+
+	return scaleImage(img, w, h), nil
+}
+
+// This function scales the given image down to fit in the given size with
+// nearest neighbor sampling.
+func scaleImage(img image.Image, w, h int) image.Image {
+	b := img.Bounds()
+	iw, ih := b.Dx(), b.Dy()
+
+	if iw <= w && ih <= h {
+		return img
+	}
+
+	// width and height are scaled by the same factor
+	sw, sh := w, ih*w/iw
+	if sh > h {
+		sw, sh = iw*h/ih, h
+	}
+	sw, sh = max(sw, 1), max(sh, 1)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, sw, sh))
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			dst.Set(x, y, img.At(b.Min.X+x*iw/sw, b.Min.Y+y*ih/sh))
+		}
+	}
+
+	return dst
+}
+
+// This function returns the sixel sequence drawing the given image. Colors
+// are reduced to a fixed palette of 6 levels for each channel. Transparent
+// pixels are left as they are.
+func encodeSixel(img image.Image) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// palette indices of pixels or -1 for transparent pixels
+	pix := make([]int, w*h)
+	used := make([]bool, 216)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			if a < 0x8000 {
+				pix[y*w+x] = -1
+				continue
+			}
+			c := int(r*5/0xffff)*36 + int(g*5/0xffff)*6 + int(bl*5/0xffff)
+			pix[y*w+x] = c
+			used[c] = true
+		}
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "\x1bP0;1q\"1;1;%d;%d", w, h)
+	for c := range used {
+		if used[c] {
+			fmt.Fprintf(&buf, "#%d;2;%d;%d;%d", c, c/36*20, c/6%6*20, c%6*20)
+		}
+	}
+
+	sixels := make([]byte, w)
+	for band := 0; band < h; band += 6 {
+		inBand := make(map[int]bool)
+		for y := band; y < min(band+6, h); y++ {
+			for x := 0; x < w; x++ {
+				if c := pix[y*w+x]; c >= 0 {
+					inBand[c] = true
+				}
+			}
+		}
+
+		for c := range used {
+			if !inBand[c] {
+				continue
+			}
+
+			for x := 0; x < w; x++ {
+				var bits byte
+				for y := band; y < min(band+6, h); y++ {
+					if pix[y*w+x] == c {
+						bits |= 1 << uint(y-band)
+					}
+				}
+				sixels[x] = '?' + bits
+			}
+
+			fmt.Fprintf(&buf, "#%d", c)
+			writeSixels(&buf, sixels)
+			buf.WriteByte('$')
+		}
+
+		buf.WriteByte('-')
+	}
+
+	buf.WriteString("\x1b\\")
+
+	return buf.String()
+}
+
+// This function writes the given sixels with repeated runs compressed.
+func writeSixels(buf *bytes.Buffer, sixels []byte) {
+	for i := 0; i < len(sixels); {
+		j := i
+		for j < len(sixels) && sixels[j] == sixels[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(buf, "!%d%c", n, sixels[i])
+		} else {
+			buf.Write(sixels[i:j])
+		}
+		i = j
+	}
+}
+
+// Maximum size of the payload in a single kitty graphics sequence.
+const gKittyChunk = 4096
+
+// This function returns the kitty graphics sequences drawing the given image
+// at the cursor. Image is sent as png data split into chunks. Cursor is not
+// moved after the image is drawn.
+func encodeKitty(img image.Image) (string, error) {
+	var data bytes.Buffer
+	if err := png.Encode(&data, img); err != nil {
+		return "", fmt.Errorf("encoding image: %s", err)
+	}
+
+	enc := base64.StdEncoding.EncodeToString(data.Bytes())
+
+	var buf bytes.Buffer
+	for i := 0; i < len(enc); i += gKittyChunk {
+		end := min(i+gKittyChunk, len(enc))
+
+		more := 0
+		if end < len(enc) {
+			more = 1
+		}
+
+		if i == 0 {
+			fmt.Fprintf(&buf, "\x1b_Ga=T,f=100,q=2,C=1,m=%d;%s\x1b\\", more, enc[i:end])
+		} else {
+			fmt.Fprintf(&buf, "\x1b_Gm=%d;%s\x1b\\", more, enc[i:end])
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// Ueberzug is a process drawing images in a window on top of the terminal.
+// Commands are sent to its standard input as json messages.
+type Ueberzug struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+}
+
+// Ueberzug process started when the first image is drawn.
+var gUeberzug *Ueberzug
+
+func startUeberzug() (*Ueberzug, error) {
+	cmd := exec.Command("ueberzug", "layer", "--silent", "--parser", "json")
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("ueberzug stdin: %s", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting ueberzug: %s", err)
+	}
+
+	return &Ueberzug{cmd: cmd, in: in}, nil
+}
+
+func (u *Ueberzug) send(msg map[string]interface{}) error {
+	return json.NewEncoder(u.in).Encode(msg)
+}
+
+// This function stops the ueberzug process if it is started.
+func stopUeberzug() {
+	if gUeberzug == nil {
+		return
+	}
+
+	gUeberzug.in.Close()
+	if err := gUeberzug.cmd.Wait(); err != nil {
+		log.Printf("stopping ueberzug: %s", err)
+	}
+
+	gUeberzug = nil
+}
+
+// This function returns the escape sequence drawing the given image with
+// sixel or kitty methods. Images are decoded and encoded in the background
+// since it may take a while for large images.
+func encodeImage(img Image) (string, error) {
+	if img.method != "sixel" && img.method != "kitty" {
+		return "", nil
+	}
+
+	cw, ch := cellSize()
+
+	m, err := loadImage(img.path, img.w*cw, img.h*ch)
+	if err != nil {
+		return "", err
+	}
+
+	if img.method == "sixel" {
+		return encodeSixel(m), nil
+	}
+
+	return encodeKitty(m)
+}
+
+type imageResult struct {
+	seq string
+	err error
+}
+
+// Number of encoded images kept in memory.
+const gMaxImages = 16
+
+var (
+	gImages        = make(map[Image]imageResult)
+	gImagesPending = make(map[Image]bool)
+	gImagesMutex   sync.Mutex
+)
+
+// This function returns the escape sequence drawing the given image if it is
+// ready. Otherwise it encodes the image in the background and returns false.
+// The main loop is notified with 'gPreviewChan' when the image is ready as it
+// is for previewers.
+func asyncImage(img Image) (seq string, ok bool, err error) {
+	gImagesMutex.Lock()
+	defer gImagesMutex.Unlock()
+
+	if res, ok := gImages[img]; ok {
+		return res.seq, true, res.err
+	}
+
+	if gImagesPending[img] {
+		return "", false, nil
+	}
+	gImagesPending[img] = true
+
+	go func() {
+		seq, err := encodeImage(img)
+
+		gImagesMutex.Lock()
+		if len(gImages) >= gMaxImages {
+			gImages = make(map[Image]imageResult)
+		}
+		gImages[img] = imageResult{seq, err}
+		delete(gImagesPending, img)
+		gImagesMutex.Unlock()
+
+		gPreviewChan <- img.path
+		screenInterrupt()
+	}()
+
+	return "", false, nil
+}
+
+// This function draws the given image with the sequence returned by
+// 'encodeImage'. It is called after the screen is flushed since images are
+// drawn over the cells of the preview pane.
+func drawImage(img *Image, seq string) error {
+	switch img.method {
+	case "sixel", "kitty":
+		screenRaw(img.x, img.y, seq)
+	case "ueberzug":
+		if gUeberzug == nil {
+			u, err := startUeberzug()
+			if err != nil {
+				return err
+			}
+			gUeberzug = u
+		}
+
+		return gUeberzug.send(map[string]interface{}{
+			"action":     "add",
+			"identifier": "lf-preview",
+			"path":       img.path,
+			"x":          img.x,
+			"y":          img.y,
+			"max_width":  img.w,
+			"max_height": img.h,
+		})
+	}
+
+	return nil
+}
+
+// This function erases the given image. It is called before the screen is
+// flushed so that the cells drawn afterwards are not erased.
+func eraseImage(img *Image) error {
+	switch img.method {
+	case "sixel":
+		// sixels are erased by drawing over them and the cells under the
+		// image are known to be empty on the screen
+		blank := strings.Repeat(" ", img.w)
+		for y := 0; y < img.h; y++ {
+			screenRaw(img.x, img.y+y, "\x1b[0m"+blank)
+		}
+	case "kitty":
+		screenRaw(img.x, img.y, "\x1b_Ga=d,q=2\x1b\\")
+	case "ueberzug":
+		if gUeberzug != nil {
+			return gUeberzug.send(map[string]interface{}{
+				"action":     "remove",
+				"identifier": "lf-preview",
+			})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestDetectImageMethod(t *testing.T) {
+	tests := []struct {
+		env      map[string]string
+		ueberzug bool
+		exp      string
+	}{
+		{map[string]string{"TERM": "xterm-256color"}, false, ""},
+		{map[string]string{"TERM": "xterm-kitty"}, false, "kitty"},
+		{map[string]string{"TERM": "xterm-256color", "KITTY_WINDOW_ID": "1"}, false, "kitty"},
+		{map[string]string{"TERM": "mlterm"}, false, "sixel"},
+		{map[string]string{"TERM": "foot"}, false, "sixel"},
+		{map[string]string{"TERM": "xterm-sixel"}, true, "sixel"},
+		{map[string]string{"TERM": "xterm", "DISPLAY": ":0"}, true, "ueberzug"},
+		{map[string]string{"TERM": "xterm", "DISPLAY": ":0"}, false, ""},
+		{map[string]string{"TERM": "xterm"}, true, ""},
+	}
+
+	for _, test := range tests {
+		getenv := func(key string) string { return test.env[key] }
+		lookPath := func(file string) (string, error) {
+			if test.ueberzug {
+				return "/usr/bin/" + file, nil
+			}
+			return "", errors.New("not found")
+		}
+
+		if got := detectImageMethod(getenv, lookPath); got != test.exp {
+			t.Errorf("at input '%v' expected '%s' but got '%s'", test.env, test.exp, got)
+		}
+	}
+}
+
+func TestScaleImage(t *testing.T) {
+	tests := []struct {
+		iw, ih int
+		w, h   int
+		expw   int
+		exph   int
+	}{
+		{10, 10, 20, 20, 10, 10},
+		{100, 50, 20, 20, 20, 10},
+		{50, 100, 20, 20, 10, 20},
+		{100, 100, 40, 20, 20, 20},
+		{1000, 1, 10, 10, 10, 1},
+	}
+
+	for _, test := range tests {
+		img := scaleImage(image.NewGray(image.Rect(0, 0, test.iw, test.ih)), test.w, test.h)
+		if b := img.Bounds(); b.Dx() != test.expw || b.Dy() != test.exph {
+			t.Errorf("at input '%dx%d' in '%dx%d' expected '%dx%d' but got '%dx%d'",
+				test.iw, test.ih, test.w, test.h, test.expw, test.exph, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestWriteSixels(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp string
+	}{
+		{"", ""},
+		{"???", "???"},
+		{"????", "!4?"},
+		{"@@@@@A~~", "!5@A~~"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		writeSixels(&buf, []byte(test.s))
+		if got := buf.String(); got != test.exp {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", test.s, test.exp, got)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Info types shown next to file names which can be given in 'info' option.
+var gInfoTypes = []string{"size", "time", "perm", "user", "group", "link-target"}
+
+// Maximum number of columns used for a single info type. Longer values (e.g.
+// link targets) are cut with a trailing '~'.
+const gMaxInfoWidth = 20
+
+func isInfoType(s string) bool {
+	for _, t := range gInfoTypes {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Names of users and groups are cached since they are looked up for each file
+// drawn with 'user' or 'group' info.
+var (
+	gUserNames  = make(map[uint32]string)
+	gGroupNames = make(map[uint32]string)
+)
+
+func userName(uid uint32) string {
+	if name, ok := gUserNames[uid]; ok {
+		return name
+	}
+
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+
+	gUserNames[uid] = name
+	return name
+}
+
+func groupName(gid uint32) string {
+	if name, ok := gGroupNames[gid]; ok {
+		return name
+	}
+
+	name := strconv.FormatUint(uint64(gid), 10)
+	if g, err := user.LookupGroupId(name); err == nil {
+		name = g.Name
+	}
+
+	gGroupNames[gid] = name
+	return name
+}
+
+// This function returns the info of the given type for the given file in the
+// given path. Empty string is returned when the info is not available (e.g.
+// link target of a regular file).
+func fileInfo(f os.FileInfo, p, typ string) string {
+	var s string
+
+	switch typ {
+	case "size":
+		s = humanize(f.Size())
+	case "time":
+		s = f.ModTime().Format(gOpts.infotimefmt)
+	case "perm":
+		s = f.Mode().String()
+	case "user":
+		if st, ok := f.Sys().(*syscall.Stat_t); ok {
+			s = userName(st.Uid)
+		}
+	case "group":
+		if st, ok := f.Sys().(*syscall.Stat_t); ok {
+			s = groupName(st.Gid)
+		}
+	case "link-target":
+		if f.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Readlink(p); err == nil {
+				s = "-> " + escapeName(target)
+			}
+		}
+	}
+
+	if r := []rune(s); len(r) > gMaxInfoWidth {
+		s = string(r[:gMaxInfoWidth-1]) + "~"
+	}
+
+	return s
+}
+
+// This function returns the info columns for the given files with values
+// right aligned in each column. Columns are dropped from the end of the list
+// until the remaining ones fit in the given width.
+func infoColumns(infos [][]string, fits func(width int) bool) []string {
+	if len(infos) == 0 {
+		return nil
+	}
+
+	ncol := len(infos[0])
+	widths := make([]int, ncol)
+	for _, info := range infos {
+		for i, s := range info {
+			widths[i] = max(widths[i], len([]rune(s)))
+		}
+	}
+
+	for ncol > 0 {
+		total := ncol - 1
+		for _, w := range widths[:ncol] {
+			total += w
+		}
+		if fits(total) {
+			break
+		}
+		ncol--
+	}
+
+	cols := make([]string, len(infos))
+	if ncol == 0 {
+		return cols
+	}
+
+	for j, info := range infos {
+		var r []rune
+		for i, s := range info[:ncol] {
+			if i > 0 {
+				r = append(r, ' ')
+			}
+			for n := widths[i] - len([]rune(s)); n > 0; n-- {
+				r = append(r, ' ')
+			}
+			r = append(r, []rune(s)...)
+		}
+		cols[j] = string(r)
+	}
+
+	return cols
+}
+
+// Time format used in file details where the full time is shown.
+const gDetailTimeFmt = "2006-01-02 15:04:05 -0700"
+
+// This function returns the details of the given file in the given path as
+// pairs of labels and values to be shown with 'file-info' command. Details
+// which are not available (e.g. owners of files inside archives) are left out.
+func fileDetails(f os.FileInfo, p string) [][2]string {
+	details := [][2]string{
+		{"path", escapeName(p)},
+		{"size", fmt.Sprintf("%d (%s)", f.Size(), humanize(f.Size()))},
+		{"perm", fmt.Sprintf("%s (%04o)", f.Mode(), f.Mode().Perm())},
+		{"modified", f.ModTime().Format(gDetailTimeFmt)},
+	}
+
+	if st, ok := f.Sys().(*syscall.Stat_t); ok {
+		if atime, ctime, ok := statTimes(st); ok {
+			details = append(details,
+				[2]string{"accessed", atime.Format(gDetailTimeFmt)},
+				[2]string{"changed", ctime.Format(gDetailTimeFmt)})
+		}
+		details = append(details, [2]string{"owner", fmt.Sprintf("%s:%s (%d:%d)",
+			userName(st.Uid), groupName(st.Gid), st.Uid, st.Gid)})
+	}
+
+	if f.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Readlink(p); err == nil {
+			details = append(details, [2]string{"link", escapeName(target)})
+		}
+	}
+
+	if typ := mimeType(f, p); typ != "" {
+		details = append(details, [2]string{"type", typ})
+	}
+
+	return details
+}
+
+// This function returns the summary of the given files to be shown with
+// 'file-info' command when files are marked. Sizes of directories include
+// their contents as in 'diskUsage' and files which can not be read are
+// counted separately.
+func selectionDetails(list []string) [][2]string {
+	var dirs, files, links, others, missing int
+	var size int64
+	var newest, oldest os.FileInfo
+	var newestPath, oldestPath string
+
+	for _, p := range list {
+		var f os.FileInfo
+		var err error
+		if checkArchive(p) != nil {
+			f, err = statPath(p)
+		} else {
+			f, err = os.Lstat(p)
+		}
+		if err != nil {
+			missing++
+			continue
+		}
+
+		switch {
+		case f.Mode()&os.ModeSymlink != 0:
+			links++
+		case f.IsDir():
+			dirs++
+		case f.Mode().IsRegular():
+			files++
+		default:
+			others++
+		}
+
+		if f.IsDir() && checkArchive(p) == nil {
+			size += diskUsage(p)
+		} else {
+			size += f.Size()
+		}
+
+		if newest == nil || f.ModTime().After(newest.ModTime()) {
+			newest, newestPath = f, p
+		}
+		if oldest == nil || f.ModTime().Before(oldest.ModTime()) {
+			oldest, oldestPath = f, p
+		}
+	}
+
+	var counts []string
+	for _, c := range []struct {
+		n            int
+		name, plural string
+	}{
+		{dirs, "directory", "directories"},
+		{files, "file", "files"},
+		{links, "link", "links"},
+		{others, "other", "others"},
+		{missing, "unreadable", "unreadable"},
+	} {
+		switch {
+		case c.n == 1:
+			counts = append(counts, "1 "+c.name)
+		case c.n > 1:
+			counts = append(counts, fmt.Sprintf("%d %s", c.n, c.plural))
+		}
+	}
+
+	details := [][2]string{
+		{"count", fmt.Sprintf("%d (%s)", len(list), strings.Join(counts, ", "))},
+		{"size", fmt.Sprintf("%d (%s)", size, humanize(size))},
+	}
+
+	if newest != nil {
+		details = append(details,
+			[2]string{"newest", fmt.Sprintf("%s %s", newest.ModTime().Format(gDetailTimeFmt), escapeName(newestPath))},
+			[2]string{"oldest", fmt.Sprintf("%s %s", oldest.ModTime().Format(gDetailTimeFmt), escapeName(oldestPath))})
+	}
+
+	return details
+}
+
+// This function returns the mime type of the given file detected from its
+// contents. Type is guessed from the extension when the file can not be read
+// (e.g. files inside archives).
+func mimeType(f os.FileInfo, p string) string {
+	switch {
+	case f.IsDir():
+		return "inode/directory"
+	case f.Mode()&os.ModeSymlink != 0:
+		return "inode/symlink"
+	case !f.Mode().IsRegular():
+		return ""
+	case f.Size() == 0:
+		return "inode/x-empty"
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return mime.TypeByExtension(path.Ext(p))
+	}
+	defer file.Close()
+
+	// only the first 512 bytes are considered for detection
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return mime.TypeByExtension(path.Ext(p))
+	}
+
+	return http.DetectContentType(buf[:n])
+}
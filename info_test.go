@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfoColumns(t *testing.T) {
+	infos := [][]string{
+		{"1.2K", "-rw-r--r--", "root"},
+		{"12", "drwxr-xr-x", "nobody"},
+	}
+
+	tests := []struct {
+		width int
+		exp   []string
+	}{
+		{30, []string{"1.2K -rw-r--r--   root", "  12 drwxr-xr-x nobody"}},
+		{20, []string{"1.2K -rw-r--r--", "  12 drwxr-xr-x"}},
+		{10, []string{"1.2K", "  12"}},
+		{3, []string{"", ""}},
+	}
+
+	for _, test := range tests {
+		cols := infoColumns(infos, func(w int) bool { return w <= test.width })
+		if !reflect.DeepEqual(cols, test.exp) {
+			t.Errorf("at width %d expected '%v' but got '%v'", test.width, test.exp, cols)
+		}
+	}
+}
+
+func TestFileInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := func(name string) string { return path.Join(dir, name) }
+
+	if err := ioutil.WriteFile(p("foo"), []byte("foo"), 0644); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+	if err := os.Chmod(p("foo"), 0644); err != nil {
+		t.Fatalf("changing permissions: %s", err)
+	}
+	if err := os.Symlink("foo", p("bar")); err != nil {
+		t.Fatalf("linking file: %s", err)
+	}
+	if err := os.Symlink(strings.Repeat("x", 30), p("baz")); err != nil {
+		t.Fatalf("linking file: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		typ  string
+		exp  string
+	}{
+		{"foo", "size", "3"},
+		{"foo", "perm", "-rw-r--r--"},
+		{"foo", "link-target", ""},
+		{"bar", "link-target", "-> foo"},
+		{"baz", "link-target", "-> " + strings.Repeat("x", 16) + "~"},
+	}
+
+	for _, test := range tests {
+		f, err := os.Lstat(p(test.name))
+		if err != nil {
+			t.Fatalf("getting file information: %s", err)
+		}
+		if s := fileInfo(f, p(test.name), test.typ); s != test.exp {
+			t.Errorf("at input '%s' '%s' expected '%s' but got '%s'", test.name, test.typ, test.exp, s)
+		}
+	}
+}
+
+func TestFileDetails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := func(name string) string { return path.Join(dir, name) }
+
+	if err := ioutil.WriteFile(p("foo"), []byte("foo"), 0644); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+	if err := os.Chmod(p("foo"), 0644); err != nil {
+		t.Fatalf("changing permissions: %s", err)
+	}
+	if err := os.Symlink("foo", p("bar")); err != nil {
+		t.Fatalf("linking file: %s", err)
+	}
+
+	tests := []struct {
+		name  string
+		label string
+		exp   string
+	}{
+		{"foo", "path", p("foo")},
+		{"foo", "size", "3 (3)"},
+		{"foo", "perm", "-rw-r--r-- (0644)"},
+		{"foo", "type", "text/plain; charset=utf-8"},
+		{"foo", "link", ""},
+		{"bar", "link", "foo"},
+		{"bar", "type", "inode/symlink"},
+		{"", "type", "inode/directory"},
+	}
+
+	for _, test := range tests {
+		f, err := os.Lstat(p(test.name))
+		if err != nil {
+			t.Fatalf("getting file information: %s", err)
+		}
+		var s string
+		for _, d := range fileDetails(f, p(test.name)) {
+			if d[0] == test.label {
+				s = d[1]
+			}
+		}
+		if s != test.exp {
+			t.Errorf("at input '%s' '%s' expected '%s' but got '%s'", test.name, test.label, test.exp, s)
+		}
+	}
+}
+
+func TestSelectionDetails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := func(name string) string { return path.Join(dir, name) }
+
+	if err := ioutil.WriteFile(p("foo"), []byte("foo"), 0644); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+	if err := os.Mkdir(p("sub"), 0755); err != nil {
+		t.Fatalf("creating directory: %s", err)
+	}
+	if err := ioutil.WriteFile(p("sub/x"), make([]byte, 10), 0644); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+	if err := os.Symlink("foo", p("bar")); err != nil {
+		t.Fatalf("linking file: %s", err)
+	}
+
+	old := time.Date(2001, 1, 1, 0, 0, 0, 0, time.Local)
+	if err := os.Chtimes(p("foo"), old, old); err != nil {
+		t.Fatalf("changing times: %s", err)
+	}
+
+	size := 3 + 3 + diskUsage(p("sub"))
+
+	tests := []struct {
+		label string
+		exp   string
+	}{
+		{"count", "4 (1 directory, 1 file, 1 link, 1 unreadable)"},
+		{"size", fmt.Sprintf("%d (%s)", size, humanize(size))},
+		{"oldest", old.Format(gDetailTimeFmt) + " " + p("foo")},
+	}
+
+	details := selectionDetails([]string{p("foo"), p("sub"), p("bar"), p("missing")})
+
+	for _, test := range tests {
+		var s string
+		for _, d := range details {
+			if d[0] == test.label {
+				s = d[1]
+			}
+		}
+		if s != test.exp {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", test.label, test.exp, s)
+		}
+	}
+}
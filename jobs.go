@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Job is a file operation running in the background (e.g. paste). Jobs are
+// either started right away or queued to run one after another when
+// 'sequential' option is set. Running and queued jobs can be canceled with
+// 'paste-cancel'. Finished jobs are sent to the main loop using 'gJobDone'
+// channel to update the ui.
+type Job struct {
+	written int64 // bytes written or removed so far, updated atomically
+
+	id    int
+	op    string   // copy, move or delete
+	list  []string // source files
+	dst   string   // destination directory or empty for delete
+	names map[string]bool
+	state string // queued, running, done, failed or canceled
+	err   error
+	total int64   // total size of source files
+	size  int64   // size written to the destination so far
+	rate  float64 // bytes per second in the last interval
+
+	opts       JobOpts       // options copied when the job is started
+	quit       chan struct{} // closed to cancel the job
+	cancelOnce sync.Once
+
+	// kept to inspect failed jobs in the jobs view
+	errs   bytes.Buffer
+	failed []string // source files which are not transferred by a failed job
+}
+
+// JobOpts are the options used by file operations. They are copied in the main
+// loop when jobs are started since options may be changed with 'set' while
+// jobs are running.
+type JobOpts struct {
+	oplog   string
+	protect []string
+}
+
+func currJobOpts() JobOpts {
+	return JobOpts{
+		oplog:   gOpts.oplog,
+		protect: gOpts.protect,
+	}
+}
+
+var (
+	gJobs      []*Job
+	gJobsMutex sync.Mutex
+	gJobQueue  chan *Job
+	gJobOnce   sync.Once
+	gJobDone   = make(chan *Job, 16)
+)
+
+func (job *Job) String() string {
+	gJobsMutex.Lock()
+	defer gJobsMutex.Unlock()
+
+	files := path.Base(job.list[0])
+	if len(job.list) > 1 {
+		files = fmt.Sprintf("%d files", len(job.list))
+	}
+
+	s := fmt.Sprintf("%d [%s] %s %s", job.id, job.state, job.op, files)
+	if job.dst != "" {
+		s += " -> " + job.dst
+	}
+	if p := job.progress(); job.state == "running" && p != "" {
+		s += " " + p
+	}
+	if job.err != nil {
+		s += ": " + job.err.Error()
+	}
+
+	return s
+}
+
+// This function returns the details of the job including the error output of
+// the command. It is shown in the pager from the jobs view.
+func (job *Job) detail() string {
+	gJobsMutex.Lock()
+	defer gJobsMutex.Unlock()
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "job:         %d\n", job.id)
+	fmt.Fprintf(&b, "operation:   %s\n", job.op)
+	if job.dst != "" {
+		fmt.Fprintf(&b, "destination: %s\n", job.dst)
+	}
+	fmt.Fprintf(&b, "state:       %s\n", job.state)
+
+	if job.err != nil {
+		fmt.Fprintf(&b, "error:       %s\n", job.err)
+	}
+
+	fmt.Fprintf(&b, "\nsources:\n")
+	for _, f := range job.list {
+		fmt.Fprintf(&b, "  %s\n", f)
+	}
+
+	if len(job.failed) != 0 {
+		fmt.Fprintf(&b, "\nfailed:\n")
+		for _, f := range job.failed {
+			fmt.Fprintf(&b, "  %s\n", f)
+		}
+	}
+
+	if job.errs.Len() != 0 {
+		fmt.Fprintf(&b, "\nerrors:\n%s", strings.TrimRight(job.errs.String(), "\n")+"\n")
+	}
+
+	return b.String()
+}
+
+// This function returns the percentage, throughput and estimated time left
+// for the job (e.g. '45% 12M/s 0:42'). It should be called with the lock held.
+func (job *Job) progress() string {
+	if job.total == 0 {
+		return ""
+	}
+
+	pct := min(int(job.size*100/job.total), 100)
+
+	if job.rate <= 0 {
+		return fmt.Sprintf("%d%%", pct)
+	}
+
+	eta := int(float64(job.total-job.size) / job.rate)
+	if eta < 0 {
+		eta = 0
+	}
+
+	return fmt.Sprintf("%d%% %s/s %d:%02d", pct, humanize(int64(job.rate)), eta/60, eta%60)
+}
+
+// This function returns the total size of the given file including the files
+// under it when it is a directory. Links are not followed.
+func diskUsage(name string) int64 {
+	var total int64
+	filepath.Walk(name, func(p string, f os.FileInfo, err error) error {
+		if err == nil {
+			total += f.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// This function reads the number of bytes written by the job in intervals
+// until the given channel is closed. Throughput is computed for each interval
+// and termbox is interrupted to update the indicator in the message line.
+func (job *Job) watch(quit <-chan struct{}) {
+	var total int64
+	for _, f := range job.list {
+		total += diskUsage(f)
+	}
+
+	gJobsMutex.Lock()
+	job.total = total
+	gJobsMutex.Unlock()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	last, prev := time.Now(), int64(0)
+
+	for {
+		select {
+		case <-quit:
+			return
+		case now := <-ticker.C:
+			size := atomic.LoadInt64(&job.written)
+
+			gJobsMutex.Lock()
+			job.size = size
+			job.rate = float64(size-prev) / now.Sub(last).Seconds()
+			gJobsMutex.Unlock()
+
+			last, prev = now, size
+
+			screenInterrupt()
+		}
+	}
+}
+
+func (job *Job) setState(state string) {
+	gJobsMutex.Lock()
+	job.state = state
+	gJobsMutex.Unlock()
+}
+
+// This function cancels the job if it is queued or running. Files which are
+// already transferred are kept and the rest can be transferred later with
+// 'retry'.
+func (job *Job) cancel() {
+	job.cancelOnce.Do(func() { close(job.quit) })
+}
+
+func (job *Job) run() {
+	if canceled(job.quit) {
+		job.finish(errCanceled)
+		return
+	}
+
+	job.setState("running")
+
+	log.Printf("job %d: %s %v -> %s", job.id, job.op, job.list, job.dst)
+
+	quit := make(chan struct{})
+	go job.watch(quit)
+
+	var errs bytes.Buffer
+
+	var err error
+	if job.op == "delete" {
+		err = remove(job.list, job.opts, &job.written, job.quit, &errs)
+	} else {
+		err = transfer(job.list, job.dst, job.op == "copy", job.opts, &job.written, job.quit, &errs)
+	}
+
+	close(quit)
+
+	gJobsMutex.Lock()
+	job.errs = errs
+	gJobsMutex.Unlock()
+
+	job.finish(err)
+}
+
+// This function sets the final state of the job and notifies the main loop.
+func (job *Job) finish(err error) {
+	var failed []string
+	if err != nil {
+		failed = job.leftover()
+	}
+
+	gJobsMutex.Lock()
+	job.failed = failed
+	switch err {
+	case nil:
+		job.state = "done"
+	case errCanceled:
+		job.state = "canceled"
+	default:
+		job.state = "failed"
+		job.err = fmt.Errorf("%s: %s", job.op, err)
+	}
+	gJobsMutex.Unlock()
+
+	gJobDone <- job
+	screenInterrupt()
+}
+
+// This function returns the source files which are not transferred. Moved and
+// deleted files are not transferred when they still exist and copied files are
+// not transferred when their copies are missing or have a different size.
+func (job *Job) leftover() []string {
+	var list []string
+
+	for _, f := range job.list {
+		if job.op == "move" || job.op == "delete" {
+			if _, err := os.Lstat(f); err == nil {
+				list = append(list, f)
+			}
+			continue
+		}
+
+		dst := path.Join(job.dst, path.Base(f))
+		if _, err := os.Lstat(dst); err != nil || diskUsage(dst) != diskUsage(f) {
+			list = append(list, f)
+		}
+	}
+
+	return list
+}
+
+// This function returns the job with the given id or the most recent failed or
+// canceled job when the id is zero.
+func findJob(id int) *Job {
+	gJobsMutex.Lock()
+	defer gJobsMutex.Unlock()
+
+	for i := len(gJobs) - 1; i >= 0; i-- {
+		job := gJobs[i]
+		if job.id == id || (id == 0 && (job.state == "failed" || job.state == "canceled")) {
+			return job
+		}
+	}
+
+	return nil
+}
+
+func jobWorker() {
+	for job := range gJobQueue {
+		job.run()
+	}
+}
+
+// This function adds the given job to the list of jobs and starts it. When
+// 'sequential' option is set, the job is queued instead to be run by a single
+// worker after the earlier jobs are finished, which is faster for devices
+// with slow seeks (e.g. hard disks or network shares).
+func startJob(job *Job) {
+	gJobsMutex.Lock()
+	job.id = len(gJobs) + 1
+	job.state = "queued"
+	job.opts = currJobOpts()
+	job.quit = make(chan struct{})
+	gJobs = append(gJobs, job)
+	gJobsMutex.Unlock()
+
+	if !gOpts.sequential {
+		go job.run()
+		return
+	}
+
+	gJobOnce.Do(func() {
+		gJobQueue = make(chan *Job, 64)
+		go jobWorker()
+	})
+
+	gJobQueue <- job
+}
+
+// This function cancels the job with the given id or all queued and running
+// jobs when the id is zero. It returns the number of canceled jobs.
+func cancelJobs(id int) int {
+	gJobsMutex.Lock()
+	defer gJobsMutex.Unlock()
+
+	n := 0
+	for _, job := range gJobs {
+		if (id == 0 || job.id == id) && (job.state == "queued" || job.state == "running") {
+			job.cancel()
+			n++
+		}
+	}
+
+	return n
+}
+
+// This function returns the current list of jobs, most recent first.
+func listJobs() []*Job {
+	gJobsMutex.Lock()
+	defer gJobsMutex.Unlock()
+
+	jobs := make([]*Job, len(gJobs))
+	for i, job := range gJobs {
+		jobs[len(gJobs)-1-i] = job
+	}
+
+	return jobs
+}
+
+// This function returns the indicator of running jobs shown at the right of
+// the message line with the progress of the oldest running job (e.g. '[2
+// jobs 45% 12M/s 0:42]'). It returns an empty string when there are no
+// running jobs.
+func jobIndicator() string {
+	gJobsMutex.Lock()
+	defer gJobsMutex.Unlock()
+
+	var first *Job
+	n := 0
+	for _, job := range gJobs {
+		if job.state == "running" || job.state == "queued" {
+			if first == nil && job.state == "running" {
+				first = job
+			}
+			n++
+		}
+	}
+
+	if n == 0 {
+		return ""
+	}
+
+	s := "1 job"
+	if n > 1 {
+		s = fmt.Sprintf("%d jobs", n)
+	}
+
+	if first != nil {
+		if p := first.progress(); p != "" {
+			s += " " + p
+		}
+	}
+
+	return "[" + s + "]"
+}
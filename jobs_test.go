@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestJobProgress(t *testing.T) {
+	tests := []struct {
+		total int64
+		size  int64
+		rate  float64
+		exp   string
+	}{
+		{0, 0, 0, ""},
+		{1000, 0, 0, "0%"},
+		{1000, 500, 0, "50%"},
+		{100000, 50000, 1000, "50% 1.0K/s 0:50"},
+		{10000000, 1000000, 100000, "10% 100K/s 1:30"},
+		{1000, 2000, 1000, "100% 1.0K/s 0:00"},
+	}
+
+	for _, test := range tests {
+		job := &Job{total: test.total, size: test.size, rate: test.rate}
+		if p := job.progress(); p != test.exp {
+			t.Errorf("at input '%d/%d' with rate '%f' expected '%s' but got '%s'", test.size, test.total, test.rate, test.exp, p)
+		}
+	}
+}
+
+func TestJobLeftover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, dst := path.Join(dir, "src"), path.Join(dir, "dst")
+	for _, d := range []string{src, dst} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("creating directory: %s", err)
+		}
+	}
+
+	files := map[string]string{
+		path.Join(src, "done"):    "foo",
+		path.Join(src, "partial"): "foobar",
+		path.Join(src, "missing"): "baz",
+		path.Join(dst, "done"):    "foo",
+		path.Join(dst, "partial"): "foo",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("writing file: %s", err)
+		}
+	}
+
+	list := []string{path.Join(src, "done"), path.Join(src, "partial"), path.Join(src, "missing")}
+
+	tests := []struct {
+		op  string
+		exp []string
+	}{
+		{"copy", []string{path.Join(src, "partial"), path.Join(src, "missing")}},
+		{"move", list},
+	}
+
+	for _, test := range tests {
+		job := &Job{op: test.op, list: list, dst: dst}
+		if left := job.leftover(); !reflect.DeepEqual(left, test.exp) {
+			t.Errorf("at operation '%s' expected '%v' but got '%v'", test.op, test.exp, left)
+		}
+	}
+}
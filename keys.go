@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// KeyName is the notation of a special key used in key bindings (e.g.
+// 'map <c-f> search').
+type KeyName struct {
+	key  termbox.Key
+	name string
+}
+
+// Notations of special keys. Terminals send the same code for some keys (e.g.
+// '<c-h>' and '<bs>') so the first notation of a key is used when keys are
+// read and the others are accepted as aliases in bindings.
+var gKeyNames = []KeyName{
+	{termbox.KeySpace, "<space>"},
+	{termbox.KeyEnter, "<cr>"},
+	{termbox.KeyBackspace, "<bs>"},
+	{termbox.KeyBackspace2, "<bs2>"},
+	{termbox.KeyTab, "<tab>"},
+	{termbox.KeyEsc, "<esc>"},
+	{termbox.KeyArrowUp, "<up>"},
+	{termbox.KeyArrowDown, "<down>"},
+	{termbox.KeyArrowLeft, "<left>"},
+	{termbox.KeyArrowRight, "<right>"},
+	{termbox.KeyHome, "<home>"},
+	{termbox.KeyEnd, "<end>"},
+	{termbox.KeyPgup, "<pgup>"},
+	{termbox.KeyPgdn, "<pgdn>"},
+	{termbox.KeyInsert, "<insert>"},
+	{termbox.KeyDelete, "<delete>"},
+	{termbox.KeyF1, "<f1>"},
+	{termbox.KeyF2, "<f2>"},
+	{termbox.KeyF3, "<f3>"},
+	{termbox.KeyF4, "<f4>"},
+	{termbox.KeyF5, "<f5>"},
+	{termbox.KeyF6, "<f6>"},
+	{termbox.KeyF7, "<f7>"},
+	{termbox.KeyF8, "<f8>"},
+	{termbox.KeyF9, "<f9>"},
+	{termbox.KeyF10, "<f10>"},
+	{termbox.KeyF11, "<f11>"},
+	{termbox.KeyF12, "<f12>"},
+	{termbox.KeyCtrlSpace, "<c-space>"},
+	{termbox.KeyCtrlBackslash, "<c-\\>"},
+	{termbox.KeyCtrlRsqBracket, "<c-]>"},
+	{termbox.KeyCtrl6, "<c-^>"},
+	{termbox.KeyCtrlUnderscore, "<c-_>"},
+	{termbox.KeyEsc, "<c-[>"},
+}
+
+func init() {
+	// control keys '<c-a>' to '<c-z>' have the codes 1 to 26
+	for c := 'a'; c <= 'z'; c++ {
+		gKeyNames = append(gKeyNames, KeyName{termbox.Key(c - 'a' + 1), "<c-" + string(c) + ">"})
+	}
+}
+
+// This function returns the notation of the given key event as used in key
+// bindings (e.g. 'a', '<space>' or '<a-x>'). It returns an empty string for
+// keys without a notation.
+func keyString(ev termbox.Event) string {
+	var s string
+
+	if ev.Ch != 0 {
+		s = string(ev.Ch)
+	} else {
+		for _, k := range gKeyNames {
+			if k.key == ev.Key {
+				s = k.name
+				break
+			}
+		}
+		if s == "" {
+			return ""
+		}
+	}
+
+	if ev.Mod&termbox.ModAlt != 0 {
+		return "<a-" + strings.TrimSuffix(strings.TrimPrefix(s, "<"), ">") + ">"
+	}
+
+	return s
+}
+
+// This function returns the key event for the given key notation. It is the
+// inverse of 'keyString' and it returns false for unknown notations.
+func keyEvent(key string) (termbox.Event, bool) {
+	ev := termbox.Event{Type: termbox.EventKey}
+
+	if len(key) > 4 && strings.HasPrefix(key, "<a-") && strings.HasSuffix(key, ">") {
+		ev.Mod = termbox.ModAlt
+		key = key[3 : len(key)-1]
+		if len([]rune(key)) != 1 {
+			key = "<" + key + ">"
+		}
+	}
+
+	if r := []rune(key); len(r) == 1 {
+		ev.Ch = r[0]
+		return ev, true
+	}
+
+	for _, k := range gKeyNames {
+		if k.name == key {
+			ev.Key = k.key
+			return ev, true
+		}
+	}
+
+	return ev, false
+}
+
+// This function returns the given key sequence with the keys written in
+// their first notations (e.g. '<c-h>' as '<bs>') so that bindings match the
+// keys as they are read. It returns an error for unknown notations.
+func normKeys(keys string) (string, error) {
+	var s string
+	for _, key := range splitKeys(keys) {
+		ev, ok := keyEvent(key)
+		if !ok {
+			return "", fmt.Errorf("unknown key: %s", key)
+		}
+		s += keyString(ev)
+	}
+	return s, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
+
+func TestKeyString(t *testing.T) {
+	tests := []struct {
+		ev  termbox.Event
+		exp string
+	}{
+		{termbox.Event{Ch: 'a'}, "a"},
+		{termbox.Event{Key: termbox.KeySpace}, "<space>"},
+		{termbox.Event{Key: termbox.KeyCtrlA}, "<c-a>"},
+		{termbox.Event{Key: termbox.KeyCtrlZ}, "<c-z>"},
+		{termbox.Event{Key: termbox.KeyCtrlH}, "<bs>"},
+		{termbox.Event{Key: termbox.KeyCtrlI}, "<tab>"},
+		{termbox.Event{Key: termbox.KeyCtrlM}, "<cr>"},
+		{termbox.Event{Key: termbox.KeyF5}, "<f5>"},
+		{termbox.Event{Key: termbox.KeyF12}, "<f12>"},
+		{termbox.Event{Key: termbox.KeyPgdn}, "<pgdn>"},
+		{termbox.Event{Key: termbox.KeyInsert}, "<insert>"},
+		{termbox.Event{Ch: 'x', Mod: termbox.ModAlt}, "<a-x>"},
+		{termbox.Event{Key: termbox.KeyArrowUp, Mod: termbox.ModAlt}, "<a-up>"},
+	}
+
+	for _, test := range tests {
+		if got := keyString(test.ev); got != test.exp {
+			t.Errorf("at input '%v' expected '%s' but got '%s'", test.ev, test.exp, got)
+		}
+		if ev, ok := keyEvent(test.exp); !ok || keyString(ev) != test.exp {
+			t.Errorf("at input '%s' expected the same notation but got '%s' (%t)", test.exp, keyString(ev), ok)
+		}
+	}
+}
+
+func TestNormKeys(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp string
+		ok  bool
+	}{
+		{"gg", "gg", true},
+		{"<c-h>", "<bs>", true},
+		{"<c-[>j", "<esc>j", true},
+		{"<a-j><f1>", "<a-j><f1>", true},
+		{"<c-foo>", "", false},
+		{"<f13>", "", false},
+	}
+
+	for _, test := range tests {
+		got, err := normKeys(test.s)
+		if got != test.exp || (err == nil) != test.ok {
+			t.Errorf("at input '%s' expected '%s' (%t) but got '%s' (%v)", test.s, test.exp, test.ok, got, err)
+		}
+	}
+}
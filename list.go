@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nsf/termbox-go"
+)
+
+// List is an overlay drawn above the message line to show a number of items
+// such as key bindings, completion candidates or results of a command. The
+// first nine visible items are numbered so that they can be picked with digit
+// keys. Lists are either only drawn while some other input is read (see
+// 'drawList') or run interactively with their own key bindings (see
+// 'runList').
+type List struct {
+	title string
+	items []string
+	ind   int               // highlighted item or -1 for none
+	beg   int               // first visible item
+	keys  map[string]string // keys to action names passed to the callback
+	plain bool              // items are drawn without numbers
+}
+
+func newList(title string, items []string) *List {
+	return &List{
+		title: title,
+		items: items,
+		ind:   -1,
+		keys: map[string]string{
+			"<cr>":  "select",
+			"l":     "select",
+			"<esc>": "quit",
+			"q":     "quit",
+		},
+	}
+}
+
+// This function returns the number of visible items in the given height.
+func (l *List) height(h int) int {
+	return min(len(l.items), h-1)
+}
+
+// This function scrolls the list to keep the highlighted item visible.
+func (l *List) scroll(h int) {
+	n := l.height(h)
+	if l.ind < 0 {
+		return
+	}
+	if l.ind < l.beg {
+		l.beg = l.ind
+	} else if l.ind >= l.beg+n {
+		l.beg = l.ind - n + 1
+	}
+}
+
+// This function returns the index of the item numbered with the given digit
+// or -1 if there is no such visible item.
+func (l *List) numbered(ch rune, h int) int {
+	n := int(ch - '1')
+	if ch == 0 || n < 0 || n >= min(l.height(h), 9) {
+		return -1
+	}
+	return l.beg + n
+}
+
+// This function draws the list in the menu window with the title as the header
+// line and the highlighted item in reverse.
+func (ui *UI) drawList(l *List) {
+	h := ui.wins[0].h
+	n := l.height(h)
+
+	l.scroll(h)
+
+	ui.menuwin.h = n
+	ui.menuwin.y = h - n
+
+	ui.menuwin.printl(0, 0, termbox.AttrBold, termbox.AttrBold, "  "+l.title)
+	for i, item := range l.items[l.beg : l.beg+n] {
+		fg := termbox.ColorDefault
+		if l.beg+i == l.ind {
+			fg = termbox.AttrReverse
+		}
+
+		num := "  "
+		if i < 9 && !l.plain {
+			num = fmt.Sprintf("%d ", i+1)
+		}
+
+		ui.menuwin.printl(0, i+1, fg, termbox.ColorDefault, num+item)
+	}
+
+	screenFlush()
+}
+
+// This function runs the list until it is closed. Cursor is moved with 'j' and
+// 'k' (or arrow keys) and the list is scrolled as needed. Digits highlight the
+// numbered item and trigger 'select' action. Other keys are looked up in the
+// key bindings of the list and the callback is called with the highlighted
+// item and the bound action. The list is closed when the callback returns true
+// or when 'quit' action is triggered in which case the callback is called with
+// index -1.
+func (ui *UI) runList(l *List, callback func(ind int, action string) bool) {
+	defer ui.clearMenu()
+
+	if l.ind < 0 && len(l.items) > 0 {
+		l.ind = 0
+	}
+
+	for {
+		ui.drawList(l)
+
+		ev := screenPollEvent()
+		if ev.Type != termbox.EventKey {
+			continue
+		}
+
+		if i := l.numbered(ev.Ch, ui.wins[0].h); i >= 0 {
+			l.ind = i
+			if callback(l.ind, "select") {
+				return
+			}
+			continue
+		}
+
+		switch {
+		case ev.Ch == 'j' || ev.Key == termbox.KeyArrowDown:
+			l.ind = min(l.ind+1, len(l.items)-1)
+			continue
+		case ev.Ch == 'k' || ev.Key == termbox.KeyArrowUp:
+			l.ind = max(l.ind-1, 0)
+			continue
+		}
+
+		action, ok := l.keys[keyString(ev)]
+		if !ok {
+			continue
+		}
+
+		if action == "quit" {
+			callback(-1, action)
+			return
+		}
+
+		if callback(l.ind, action) {
+			return
+		}
+	}
+}
+
+// This function shows the given lines in the menu window until a key is
+// pressed. It is used for details which do not fit in the message line.
+func (ui *UI) popup(title string, lines []string) {
+	defer ui.clearMenu()
+
+	l := newList(title, lines)
+	l.plain = true
+
+	ui.drawList(l)
+
+	for screenPollEvent().Type != termbox.EventKey {
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestListNumbered(t *testing.T) {
+	l := newList("title", []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"})
+
+	tests := []struct {
+		beg int
+		ch  rune
+		h   int
+		ind int
+	}{
+		{0, '1', 20, 0},
+		{0, '9', 20, 8},
+		{0, '0', 20, -1},
+		{0, 'a', 20, -1},
+		{0, 0, 20, -1},
+		{0, '4', 4, -1},
+		{0, '3', 4, 2},
+		{3, '1', 20, 3},
+	}
+
+	for _, test := range tests {
+		l.beg = test.beg
+		if ind := l.numbered(test.ch, test.h); ind != test.ind {
+			t.Errorf("at input '%c' with beginning '%d' and height '%d' expected '%d' but got '%d'", test.ch, test.beg, test.h, test.ind, ind)
+		}
+	}
+}
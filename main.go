@@ -7,24 +7,42 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
+	"time"
 )
 
 var (
-	envUser  = os.Getenv("USER")
-	envHome  = os.Getenv("HOME")
-	envHost  = os.Getenv("HOSTNAME")
-	envPath  = os.Getenv("PATH")
-	envShell = os.Getenv("SHELL")
+	envUser   = os.Getenv("USER")
+	envHome   = os.Getenv("HOME")
+	envConfig = os.Getenv("XDG_CONFIG_HOME")
+	envHost   = os.Getenv("HOSTNAME")
+	envPath   = os.Getenv("PATH")
+	envShell  = os.Getenv("SHELL")
+	envPager  = os.Getenv("PAGER")
+	envEditor = os.Getenv("EDITOR")
 )
 
 var (
 	gExitFlag      bool
 	gLastDirPath   string
+	gLastDir       string
+	gPrintLastDir  bool
 	gSelectionPath string
+	gSelection     string
 	gSocketPath    string
 	gLogPath       string
 	gServerLogPath string
-	gConfigPath    string
+	gConfigPaths   []string
+	gIconsPath     string
+	gBookmarksPath string
+	gHistoryPath   string
+	gClientId      int
+	gStartupPath   string
+	gStartPath     string
+	gCommandsPath  string
+	gReadonlyFlag  bool
+	gLevel         int
+	gStartTime     = time.Now()
 )
 
 func init() {
@@ -34,6 +52,12 @@ func init() {
 	if envHome == "" {
 		envHome = "/home/" + envUser
 	}
+	if envPager == "" {
+		envPager = "less"
+	}
+	if envEditor == "" {
+		envEditor = "vi"
+	}
 	if envHost == "" {
 		host, err := os.Hostname()
 		if err != nil {
@@ -50,8 +74,19 @@ func init() {
 	gLogPath = path.Join(tmp, fmt.Sprintf("lf.%s.log", envUser))
 	gServerLogPath = path.Join(tmp, fmt.Sprintf("lf.%s.server.log", envUser))
 
-	// TODO: xdg-config-home etc.
-	gConfigPath = path.Join(envHome, ".config", "lf", "lfrc")
+	if envConfig == "" {
+		envConfig = path.Join(envHome, ".config")
+	}
+
+	// system configuration is read first so that users can override it
+	gConfigPaths = []string{
+		path.Join("/etc", "lf", "lfrc"),
+		path.Join(envConfig, "lf", "lfrc"),
+	}
+
+	gIconsPath = path.Join(envConfig, "lf", "icons")
+	gBookmarksPath = path.Join(envConfig, "lf", "bookmarks")
+	gHistoryPath = path.Join(envConfig, "lf", "history")
 }
 
 func startServer() {
@@ -63,12 +98,21 @@ func startServer() {
 
 func main() {
 	serverMode := flag.Bool("server", false, "start server (automatic)")
+	remoteCmd := flag.String("remote", "", "send remote command to server")
 	flag.StringVar(&gLastDirPath, "last-dir-path", "", "path to the file to write the last dir on exit (to use for cd)")
-	flag.StringVar(&gSelectionPath, "selection-path", "", "path to the file to write selected files on exit (to use as open file dialog)")
+	flag.BoolVar(&gPrintLastDir, "print-last-dir", false, "print the last dir to stdout on exit (to use for cd)")
+	flag.BoolVar(&gReadonlyFlag, "readonly", false, "disable commands modifying files")
+	flag.StringVar(&gStartupPath, "startuptime", "", "path to the file to write startup timing information")
+	flag.StringVar(&gSelectionPath, "selection-path", "", "path to the file to write selected files on exit (to use as open file dialog, '-' for stdout)")
+	flag.StringVar(&gCommandsPath, "commands-file", "", "path to the file to read commands to run at startup ('-' for stdin)")
 
 	flag.Parse()
 
-	if *serverMode {
+	if *remoteCmd != "" {
+		if err := sendRemote(*remoteCmd); err != nil {
+			log.Fatalf("remote command: %s", err)
+		}
+	} else if *serverMode {
 		serve()
 	} else {
 		// TODO: check if the socket is working
@@ -76,6 +120,42 @@ func main() {
 			startServer()
 		}
 
+		gClientId = os.Getpid()
+
+		// nesting level is increased for each lf started from a shell in lf
+		gLevel, _ = strconv.Atoi(os.Getenv("LF_LEVEL"))
+		gLevel++
+		os.Setenv("LF_LEVEL", strconv.Itoa(gLevel))
+
+		gOpts.readonly = gReadonlyFlag
+
+		gStartPath = flag.Arg(0)
+
+		// wrappers can also give the file in the environment and it is not
+		// passed to nested clients
+		if gLastDirPath == "" {
+			gLastDirPath = os.Getenv("LF_LAST_DIR")
+		}
+		os.Unsetenv("LF_LAST_DIR")
+
+		// output of shell commands is sent to the terminal instead since
+		// stdout is read by the shell to change the directory or to get the
+		// selected files
+		out := os.Stdout
+		if gPrintLastDir || gSelectionPath == "-" {
+			if tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
+				os.Stdout = tty
+			}
+		}
+
 		client()
+
+		// printed after the ui is closed so that it is the only output
+		if gPrintLastDir && gLastDir != "" {
+			fmt.Fprintln(out, gLastDir)
+		}
+		if gSelection != "" {
+			fmt.Fprint(out, gSelection)
+		}
 	}
 }
@@ -5,12 +5,85 @@ import (
 	"log"
 	"path"
 	"strconv"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
 func isRoot(name string) bool { return path.Dir(name) == name }
 
+// This function quotes the given string to be used as a single word in shell
+// commands. Single quotes are used so nothing is expanded and each single
+// quote in the string is replaced with a quoted and escaped one.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// This function escapes the characters in the given file name which can not be
+// drawn as they are. Control characters are shown as escape sequences (e.g.
+// '\n' or '\x1b') and bytes of invalid utf-8 sequences as hex escapes (e.g.
+// '\xff') so that such names do not corrupt the screen and are still told
+// apart from each other.
+func escapeName(s string) string {
+	var buf []byte
+
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+
+		switch {
+		case r == utf8.RuneError && n == 1:
+			buf = append(buf, fmt.Sprintf(`\x%02x`, s[i])...)
+		case r == '\n':
+			buf = append(buf, `\n`...)
+		case r == '\t':
+			buf = append(buf, `\t`...)
+		case r == '\r':
+			buf = append(buf, `\r`...)
+		case r < 0x80 && unicode.IsControl(r):
+			buf = append(buf, fmt.Sprintf(`\x%02x`, r)...)
+		case unicode.IsControl(r):
+			buf = append(buf, fmt.Sprintf(`\u%04x`, r)...)
+		default:
+			buf = append(buf, s[i:i+n]...)
+		}
+
+		i += n
+	}
+
+	return string(buf)
+}
+
+// This function replaces placeholders in the given shell command with the
+// given values. Placeholders consist of a percent sign followed by a key
+// (e.g. '%f') and '%%' is replaced with a single percent sign. Other percent
+// signs are left as they are so that commands such as 'date +%Y' still work.
+func expandPlaceholders(s string, vals map[byte]string) string {
+	var buf []byte
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+1 == len(s) {
+			buf = append(buf, s[i])
+			continue
+		}
+
+		if s[i+1] == '%' {
+			buf = append(buf, '%')
+			i++
+			continue
+		}
+
+		if val, ok := vals[s[i+1]]; ok {
+			buf = append(buf, val...)
+			i++
+			continue
+		}
+
+		buf = append(buf, s[i])
+	}
+
+	return string(buf)
+}
+
 // This function converts a size in bytes to a human readable form. For this
 // purpose metric suffixes are used (e.g. 1K = 1000). For values less than 10
 // the first significant digit is shown, otherwise it is hidden. Numbers are
@@ -35,7 +108,7 @@ func humanize(size int64) string {
 	curr := float64(size) / 1000
 	for _, s := range suffix {
 		if curr < 10 {
-			return fmt.Sprintf("%.1f%s", curr-0.0499, s)
+			return strings.Replace(fmt.Sprintf("%.1f%s", curr-0.0499, s), ".", gOpts.decimalsep, 1)
 		} else if curr < 1000 {
 			return fmt.Sprintf("%d%s", int(curr), s)
 		}
@@ -27,6 +27,79 @@ func TestIsRoot(t *testing.T) {
 	}
 }
 
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		s   string
+		out string
+	}{
+		{"", "''"},
+		{"foo", "'foo'"},
+		{"foo bar", "'foo bar'"},
+		{"$foo", "'$foo'"},
+		{"foo's", `'foo'\''s'`},
+		{"foo\nbar", "'foo\nbar'"},
+		{"foo\xffbar", "'foo\xffbar'"},
+	}
+
+	for _, test := range tests {
+		if out := shellQuote(test.s); out != test.out {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", test.s, test.out, out)
+		}
+	}
+}
+
+func TestEscapeName(t *testing.T) {
+	tests := []struct {
+		s   string
+		out string
+	}{
+		{"", ""},
+		{"foo", "foo"},
+		{"foo bar", "foo bar"},
+		{"föö", "föö"},
+		{"foo\nbar", `foo\nbar`},
+		{"foo\tbar\r", `foo\tbar\r`},
+		{"\033[31mfoo", `\x1b[31mfoo`},
+		{"foo\x7f", `foo\x7f`},
+		{"foo\u0085", `foo\u0085`},
+		{"foo\xffbar", `foo\xffbar`},
+		{"\xe2\x82", `\xe2\x82`},
+	}
+
+	for _, test := range tests {
+		if out := escapeName(test.s); out != test.out {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", test.s, test.out, out)
+		}
+	}
+}
+
+func TestExpandPlaceholders(t *testing.T) {
+	vals := map[byte]string{
+		'f': "'/foo/bar'",
+		'd': "'/foo'",
+		's': "'/foo/bar' '/foo/baz'",
+	}
+
+	tests := []struct {
+		s   string
+		out string
+	}{
+		{"", ""},
+		{"echo %f", "echo '/foo/bar'"},
+		{"cd %d && ls", "cd '/foo' && ls"},
+		{"tar czf x.tgz %s", "tar czf x.tgz '/foo/bar' '/foo/baz'"},
+		{"date +%Y", "date +%Y"},
+		{"printf '%%s' %f", "printf '%s' '/foo/bar'"},
+		{"echo 100%", "echo 100%"},
+	}
+
+	for _, test := range tests {
+		if out := expandPlaceholders(test.s, vals); out != test.out {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", test.s, test.out, out)
+		}
+	}
+}
+
 func TestHumanize(t *testing.T) {
 	nums := []struct {
 		i int64
@@ -54,6 +127,13 @@ func TestHumanize(t *testing.T) {
 			t.Errorf("at input '%d' expected '%s' but got '%s'", num.i, num.s, h)
 		}
 	}
+
+	defer func(s string) { gOpts.decimalsep = s }(gOpts.decimalsep)
+	gOpts.decimalsep = ","
+
+	if h := humanize(1500); h != "1,5K" {
+		t.Errorf("at input '1500' with decimal comma expected '1,5K' but got '%s'", h)
+	}
 }
 
 func TestExtractNums(t *testing.T) {
@@ -3,20 +3,32 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path"
+	"path/filepath"
 	"sort"
 	"strings"
+	"syscall"
+	"time"
 )
 
 type Dir struct {
-	ind  int // which entry is highlighted
-	pos  int // which line in the ui highlighted entry is
-	path string
-	fi   []os.FileInfo
+	ind     int // which entry is highlighted
+	pos     int // which line in the ui highlighted entry is
+	path    string
+	fi      []os.FileInfo   // shown entries matching the filter
+	all     []os.FileInfo   // all entries regardless of the filter
+	filter  string          // pattern to show only matching entries if any
+	ftype   string          // type of entries to show only if any
+	loading bool            // entries are being read in the background
+	noPerm  bool            // directory can not be read due to permissions
+	mtime   time.Time       // modification time of the directory when it is read
+	tree    map[string]bool // expanded subdirectories in tree mode, nil when off
+	flat    bool            // files of subdirectories are listed as well
+	du      bool            // entries are sorted by their recursive sizes
+	visual  string          // entry anchoring the range in visual mode if any
 }
 
 type ByName []os.FileInfo
@@ -46,10 +58,16 @@ func (a ByDir) Len() int      { return len(a) }
 func (a ByDir) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 
 func (a ByDir) Less(i, j int) bool {
-	if a[i].IsDir() == a[j].IsDir() {
-		return i < j
-	}
-	return a[i].IsDir()
+	return a[i].IsDir() && !a[j].IsDir()
+}
+
+type ByExt []os.FileInfo
+
+func (a ByExt) Len() int      { return len(a) }
+func (a ByExt) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+func (a ByExt) Less(i, j int) bool {
+	return strings.ToLower(path.Ext(a[i].Name())) < strings.ToLower(path.Ext(a[j].Name()))
 }
 
 type ByNum []os.FileInfo
@@ -85,67 +103,270 @@ func (a ByNum) Less(i, j int) bool {
 	return i < j
 }
 
+// This function reports whether the given file name matches any of the
+// patterns in 'hiddenfiles' option.
+func isHidden(name string) bool {
+	return matchHidden(name, gOpts.hiddenfiles)
+}
+
+func matchHidden(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SortOpts are the options used to hide and sort directory entries. They are
+// copied in the main loop for directories read in the background since
+// options may be changed with 'set' in the meantime.
+type SortOpts struct {
+	hidden      bool
+	reverse     bool
+	dirfirst    bool
+	sortby      string
+	hiddenfiles []string
+}
+
+func currSortOpts() SortOpts {
+	return SortOpts{
+		hidden:      gOpts.hidden,
+		reverse:     gOpts.reverse,
+		dirfirst:    gOpts.dirfirst,
+		sortby:      gOpts.sortby,
+		hiddenfiles: gOpts.hiddenfiles,
+	}
+}
+
 func organizeFiles(fi []os.FileInfo) []os.FileInfo {
-	if !gOpts.hidden {
+	return sortFiles(fi, currSortOpts())
+}
+
+func sortFiles(fi []os.FileInfo, opts SortOpts) []os.FileInfo {
+	if !opts.hidden {
 		var tmp []os.FileInfo
 		for _, f := range fi {
-			if f.Name()[0] != '.' {
+			if !matchHidden(f.Name(), opts.hiddenfiles) {
 				tmp = append(tmp, f)
 			}
 		}
 		fi = tmp
 	}
 
-	switch gOpts.sortby {
+	// files are sorted by name first so that ties are broken by names
+	sort.Sort(ByName(fi))
+
+	switch opts.sortby {
 	case "name":
-		sort.Sort(ByName(fi))
+	case "natural":
+		sort.Stable(ByNum(fi))
 	case "size":
-		sort.Sort(BySize(fi))
+		sort.Stable(BySize(fi))
 	case "time":
-		sort.Sort(ByTime(fi))
+		sort.Stable(ByTime(fi))
+	case "ext":
+		sort.Stable(ByExt(fi))
 	default:
-		log.Printf("unknown sorting type: %s", gOpts.sortby)
+		log.Printf("unknown sorting type: %s", opts.sortby)
+	}
+
+	if opts.reverse {
+		for i, j := 0, len(fi)-1; i < j; i, j = i+1, j-1 {
+			fi[i], fi[j] = fi[j], fi[i]
+		}
 	}
 
-	// TODO: these should be optional
-	sort.Stable(ByNum(fi))
-	sort.Stable(ByDir(fi))
+	if opts.dirfirst {
+		sort.Stable(ByDir(fi))
+	}
 
 	return fi
 }
 
+// Sorting types in the order they are cycled with 'sort' command.
+var gSortTypes = []string{"natural", "name", "size", "time", "ext"}
+
+func isSortType(s string) bool {
+	for _, t := range gSortTypes {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// This function returns the sorting type after the given one in the order
+// they are cycled with 'sort' command.
+func nextSortType(s string) string {
+	for i, t := range gSortTypes {
+		if t == s {
+			return gSortTypes[(i+1)%len(gSortTypes)]
+		}
+	}
+	return gSortTypes[0]
+}
+
 func newDir(path string) *Dir {
-	fi, err := ioutil.ReadDir(path)
+	return newSortedDir(path, currSortOpts())
+}
+
+// This function reads the given directory sorting its entries with the given
+// options. It is used to read directories in the background without
+// accessing the options.
+func newSortedDir(path string, opts SortOpts) *Dir {
+	mtime := dirTime(path)
+
+	fi, err := readEntries(path)
 	if err != nil {
 		log.Printf("reading directory: %s", err)
 	}
 
-	fi = organizeFiles(fi)
+	fi = sortFiles(fi, opts)
 
 	return &Dir{
-		path: path,
-		fi:   fi,
+		path:   path,
+		fi:     fi,
+		all:    fi,
+		noPerm: os.IsPermission(err),
+		mtime:  mtime,
+	}
+}
+
+// This function returns the modification time of the given directory. It is
+// taken before reading the directory so that changes during the read are not
+// missed. Modification time of the archive is used for directories inside
+// archives.
+func dirTime(path string) time.Time {
+	if arch, _, ok := splitArchive(path); ok {
+		path = arch
+	}
+
+	f, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
 	}
+	return f.ModTime()
 }
 
 func (dir *Dir) renew(height int) {
-	fi, err := ioutil.ReadDir(dir.path)
+	dir.mtime = dirTime(dir.path)
+
+	fi, err := readEntries(dir.path)
 	if err != nil {
-		log.Print("reading directory: %s", err)
+		log.Printf("reading directory: %s", err)
+	}
+
+	dir.noPerm = os.IsPermission(err)
+	dir.update(dir.expand(organizeFiles(fi)), height)
+}
+
+// This function replaces the entries of the directory keeping the cursor on
+// the same file if it still exists. Otherwise the cursor is moved to the
+// nearest file still shown, looking below the cursor first.
+func (dir *Dir) update(fi []os.FileInfo, height int) {
+	old := dir.fi
+
+	dir.all = fi
+	dir.fi = filterFiles(fi, dir.filter, dir.ftype)
+
+	var name string
+	if len(old) != 0 {
+		names := dir.names()
+		name = old[dir.ind].Name()
+		for i := 1; !names[name] && (dir.ind+i < len(old) || dir.ind-i >= 0); i++ {
+			if dir.ind+i < len(old) && names[old[dir.ind+i].Name()] {
+				name = old[dir.ind+i].Name()
+			} else if dir.ind-i >= 0 && names[old[dir.ind-i].Name()] {
+				name = old[dir.ind-i].Name()
+			}
+		}
+	}
+
+	dir.load(dir.ind, dir.pos, height, name)
+}
+
+// Types of files which can be shown alone with 'filter-type' command.
+var gFilterTypes = []string{"dir", "file", "exec", "image"}
+
+func isFilterType(s string) bool {
+	for _, t := range gFilterTypes {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// This function reports whether the given file is of the given type. All
+// files match an empty type.
+func matchType(f os.FileInfo, ftype string) bool {
+	switch ftype {
+	case "dir":
+		return f.IsDir()
+	case "file":
+		return f.Mode().IsRegular()
+	case "exec":
+		return f.Mode().IsRegular() && f.Mode()&0111 != 0
+	case "image":
+		return f.Mode().IsRegular() && isImage(f.Name())
 	}
+	return true
+}
+
+// This function returns the entries with names matching the given pattern as
+// in searches and of the given type. All entries are returned when both the
+// pattern and the type are empty.
+func filterFiles(fi []os.FileInfo, pattern, ftype string) []os.FileInfo {
+	if pattern == "" && ftype == "" {
+		return fi
+	}
+
+	var tmp []os.FileInfo
+	for _, f := range fi {
+		if searchMatch(f.Name(), pattern) && matchType(f, ftype) {
+			tmp = append(tmp, f)
+		}
+	}
+
+	return tmp
+}
+
+// This function shows only the entries matching the given pattern or all
+// entries when the pattern is empty. The cursor stays on the same file if it
+// is still shown.
+func (dir *Dir) setFilter(pattern string, height int) {
+	dir.filter = pattern
+	dir.refilter(height)
+}
 
-	fi = organizeFiles(fi)
+// This function shows only the entries of the given type or all entries when
+// the type is empty. It is combined with the filter pattern if any.
+func (dir *Dir) setType(ftype string, height int) {
+	dir.ftype = ftype
+	dir.refilter(height)
+}
 
+func (dir *Dir) refilter(height int) {
 	var name string
 	if len(dir.fi) != 0 {
 		name = dir.fi[dir.ind].Name()
 	}
 
-	dir.fi = fi
+	dir.fi = filterFiles(dir.all, dir.filter, dir.ftype)
 
 	dir.load(dir.ind, dir.pos, height, name)
 }
 
+// This function returns the set of file names in the directory.
+func (dir *Dir) names() map[string]bool {
+	names := make(map[string]bool, len(dir.fi))
+	for _, f := range dir.fi {
+		names[f.Name()] = true
+	}
+	return names
+}
+
 func (dir *Dir) load(ind, pos, height int, name string) {
 	if len(dir.fi) == 0 {
 		dir.ind, dir.pos = 0, 0
@@ -171,14 +392,137 @@ func (dir *Dir) load(ind, pos, height int, name string) {
 }
 
 type Nav struct {
+	search string // last search pattern if any
+	back   bool   // whether the last search is backwards
 	dirs   []*Dir
 	inds   map[string]int
 	poss   map[string]int
 	names  map[string]string
 	marks  map[string]bool
+	cache  map[string]*Dir // directories loaded in the background
 	height int
 }
 
+// Loaded directories are sent to the main loop using this channel.
+var gDirChan = make(chan *Dir, 16)
+
+// Maximum number of directories kept in the cache before it is cleared.
+const gMaxCachedDirs = 64
+
+// This function returns the directory for the given path. Directories are
+// read in the background so that large directories do not block the ui. A
+// placeholder marked as loading is returned until the directory is read and
+// the result is passed to 'dirLoaded' in the main loop. Directories are kept
+// in the cache afterwards and only read again when their modification time
+// is changed.
+func (nav *Nav) loadDir(path string) *Dir {
+	if dir, ok := nav.cache[path]; ok {
+		if !dir.loading && !dirTime(path).Equal(dir.mtime) {
+			dir.mtime = dirTime(path)
+			go readDir(path, currSortOpts())
+		}
+		return dir
+	}
+
+	if len(nav.cache) >= gMaxCachedDirs {
+		// shown directories are kept since they may still be loading
+		cache := make(map[string]*Dir)
+		for _, d := range nav.dirs {
+			cache[d.path] = d
+		}
+		nav.cache = cache
+	}
+
+	dir := &Dir{path: path, loading: true}
+	nav.cache[path] = dir
+
+	go readDir(path, currSortOpts())
+
+	return dir
+}
+
+// This function reads the shown directories again and marks the other cached
+// directories to be read again when they are shown. It is used when changes
+// are not detected with modification times (e.g. changes in file sizes or
+// network file systems) and when sorting options are changed. Directories
+// still loading are read again as well since the pending result may be
+// sorted with the old options.
+func (nav *Nav) reload() {
+	for _, dir := range nav.cache {
+		if !dir.loading {
+			dir.mtime = time.Time{}
+		}
+	}
+
+	for _, d := range nav.dirs {
+		if d.loading {
+			go readDir(d.path, currSortOpts())
+		}
+	}
+
+	nav.renew(nav.height)
+}
+
+func readDir(path string, opts SortOpts) {
+	gDirChan <- newSortedDir(path, opts)
+	screenInterrupt()
+}
+
+// This function fills in the cached directory with the entries read in the
+// background. The cursor is restored from the last visit when the directory
+// is loaded for the first time. Results are also accepted for shown
+// directories which are dropped from the cache in the meantime.
+func (nav *Nav) dirLoaded(d *Dir) {
+	dir, ok := nav.cache[d.path]
+	if !ok {
+		for _, s := range nav.dirs {
+			if s.path == d.path {
+				dir, ok = s, true
+				nav.cache[d.path] = s
+				break
+			}
+		}
+	}
+	if !ok {
+		return
+	}
+
+	dir.mtime = d.mtime
+	dir.noPerm = d.noPerm
+
+	if dir.loading {
+		dir.all = dir.expand(d.all)
+		dir.fi = filterFiles(dir.all, dir.filter, dir.ftype)
+		dir.loading = false
+		dir.load(nav.inds[dir.path], nav.poss[dir.path], nav.height, nav.names[dir.path])
+		return
+	}
+
+	dir.update(dir.expand(d.fi), nav.height)
+}
+
+// Recently visited directories, most recent first. These are suggested first
+// when completing directories.
+var gRecents []string
+
+// Maximum number of recently visited directories to remember.
+const gMaxRecents = 20
+
+// This function remembers the current directory as recently visited. It is
+// called before leaving the directory.
+func (nav *Nav) visit() {
+	p := nav.currDir().path
+
+	recents := []string{p}
+	for _, r := range gRecents {
+		if r != p && len(recents) < gMaxRecents {
+			recents = append(recents, r)
+		}
+	}
+
+	gRecents = recents
+}
+
 func getDirs(wd string, height int) []*Dir {
 	var dirs []*Dir
 
@@ -216,23 +560,43 @@ func newNav(height int) *Nav {
 		poss:   make(map[string]int),
 		names:  make(map[string]string),
 		marks:  make(map[string]bool),
+		cache:  make(map[string]*Dir),
 		height: height,
 	}
 }
 
 func (nav *Nav) renew(height int) {
 	nav.height = height
+
+	// other cached directories are checked when they are shown again
 	for _, d := range nav.dirs {
-		d.renew(nav.height)
+		if !d.loading {
+			d.renew(nav.height)
+		}
 	}
 
 	for m := range nav.marks {
-		if _, err := os.Stat(m); os.IsNotExist(err) {
+		if _, err := statPath(m); os.IsNotExist(err) {
 			delete(nav.marks, m)
 		}
 	}
 }
 
+// This function moves the cursor in the current directory to the first file
+// which is not among the given names. Names are taken before an operation
+// creating files (e.g. mkdir or paste) so that the cursor is placed on the new
+// file after the directory is renewed.
+func (nav *Nav) follow(old map[string]bool) {
+	dir := nav.currDir()
+
+	for _, f := range dir.fi {
+		if !old[f.Name()] {
+			dir.load(dir.ind, dir.pos, nav.height, f.Name())
+			return
+		}
+	}
+}
+
 func (nav *Nav) up() {
 	dir := nav.currDir()
 
@@ -278,9 +642,11 @@ func (nav *Nav) updir() error {
 		nav.names[dir.path] = dir.fi[dir.ind].Name()
 	}
 
+	nav.visit()
+
 	nav.dirs = nav.dirs[:len(nav.dirs)-1]
 
-	if err := os.Chdir(path.Dir(dir.path)); err != nil {
+	if err := chdir(path.Dir(dir.path)); err != nil {
 		return fmt.Errorf("updir: %s", err)
 	}
 
@@ -288,15 +654,30 @@ func (nav *Nav) updir() error {
 }
 
 func (nav *Nav) open() error {
-	path := nav.currPath()
+	curr := nav.currDir().path
+
+	// entries of expanded subdirectories in tree mode are opened through
+	// their parents so that parent panes stay in order
+	parts := strings.Split(nav.currFile().Name(), "/")
 
-	dir := newDir(path)
+	nav.visit()
+
+	for i := range parts {
+		p := path.Join(curr, path.Join(parts[:i+1]...))
+
+		if i+1 < len(parts) {
+			nav.names[p] = parts[i+1]
+		}
 
-	dir.load(nav.inds[path], nav.poss[path], nav.height, nav.names[path])
+		dir := nav.loadDir(p)
+		if !dir.loading && i+1 < len(parts) {
+			dir.load(dir.ind, dir.pos, nav.height, parts[i+1])
+		}
 
-	nav.dirs = append(nav.dirs, dir)
+		nav.dirs = append(nav.dirs, dir)
+	}
 
-	if err := os.Chdir(path); err != nil {
+	if err := chdir(nav.currDir().path); err != nil {
 		return fmt.Errorf("open: %s", err)
 	}
 
@@ -317,17 +698,96 @@ func (nav *Nav) top() {
 	dir.pos = 0
 }
 
-func (nav *Nav) cd(wd string) error {
-	wd = strings.Replace(wd, "~", envHome, -1)
+// This function moves the cursor to the given index scrolling the view as
+// little as possible.
+func (nav *Nav) move(ind int) {
+	dir := nav.currDir()
+
+	maxind := len(dir.fi) - 1
+
+	ind = max(0, min(ind, maxind))
 
-	if !path.IsAbs(wd) {
-		wd = path.Join(nav.currDir().path, wd)
+	pos := dir.pos + ind - dir.ind
+	pos = max(pos, min(gOpts.scrolloff, ind))
+	pos = min(pos, nav.height-min(gOpts.scrolloff, maxind-ind)-1)
+	pos = max(0, min(pos, ind))
+
+	dir.ind = ind
+	dir.pos = pos
+}
+
+// This function reports whether the given name contains the search pattern.
+// Case is ignored when 'ignorecase' option is set unless 'smartcase' option
+// is also set and the pattern has an uppercase letter.
+func searchMatch(name, pattern string) bool {
+	if gOpts.ignorecase && !(gOpts.smartcase && strings.ToLower(pattern) != pattern) {
+		name = strings.ToLower(name)
+		pattern = strings.ToLower(pattern)
+	}
+	return strings.Contains(name, pattern)
+}
+
+// This function moves the cursor to the first file matching the given pattern
+// starting from the given offset to the cursor in the given direction. Search
+// wraps around at the ends of the directory. It returns false when no file
+// matches.
+func (nav *Nav) searchFrom(pattern string, back bool, off int) bool {
+	dir := nav.currDir()
+
+	n := len(dir.fi)
+
+	for i := off; i < n+off; i++ {
+		k := i
+		if back {
+			k = -i
+		}
+		ind := ((dir.ind+k)%n + n) % n
+		if searchMatch(dir.fi[ind].Name(), pattern) {
+			nav.move(ind)
+			return true
+		}
+	}
+
+	return false
+}
+
+// This function moves the cursor to the next file matching the last search
+// pattern. Direction of the last search is reversed when 'prev' is true.
+func (nav *Nav) searchNext(prev bool) error {
+	if nav.search == "" {
+		return fmt.Errorf("no previous search")
+	}
+
+	if !nav.searchFrom(nav.search, nav.back != prev, 1) {
+		return fmt.Errorf("pattern not found: %s", nav.search)
+	}
+
+	return nil
+}
+
+// This function expands the home directory in the given path and joins it to
+// the current directory if it is relative.
+func (nav *Nav) absPath(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		p = envHome + p[1:]
 	}
 
-	if err := os.Chdir(wd); err != nil {
+	if !path.IsAbs(p) {
+		p = path.Join(nav.currDir().path, p)
+	}
+
+	return p
+}
+
+func (nav *Nav) cd(wd string) error {
+	wd = nav.absPath(wd)
+
+	if err := chdir(wd); err != nil {
 		return fmt.Errorf("cd: %s", err)
 	}
 
+	nav.visit()
+
 	nav.dirs = getDirs(wd, nav.height)
 
 	// TODO: save/load ind and pos from the map
@@ -335,6 +795,19 @@ func (nav *Nav) cd(wd string) error {
 	return nil
 }
 
+// This function changes the directory to the parent of the given path and
+// moves the cursor on the file.
+func (nav *Nav) find(p string) error {
+	if err := nav.cd(path.Dir(p)); err != nil {
+		return err
+	}
+
+	dir := nav.currDir()
+	dir.load(dir.ind, dir.pos, nav.height, path.Base(p))
+
+	return nil
+}
+
 func (nav *Nav) toggle() {
 	path := nav.currPath()
 
@@ -347,27 +820,195 @@ func (nav *Nav) toggle() {
 	nav.down()
 }
 
-func (nav *Nav) save(keep bool) error {
+// This function toggles the marks of the shown entries in the current
+// directory.
+func (nav *Nav) invert() {
+	dir := nav.currDir()
+
+	for _, f := range dir.fi {
+		path := path.Join(dir.path, f.Name())
+		if nav.marks[path] {
+			delete(nav.marks, path)
+		} else {
+			nav.marks[path] = true
+		}
+	}
+}
+
+// This function marks or unmarks the shown entries in the current directory
+// with names matching the given pattern. Entries of subdirectories in tree
+// mode and flat view are matched with their own names. It returns the number
+// of matching entries.
+func (nav *Nav) globSelect(pattern string, mark bool) (int, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+
+	dir := nav.currDir()
+
+	n := 0
+	for _, f := range dir.fi {
+		if ok, _ := filepath.Match(pattern, path.Base(f.Name())); !ok {
+			continue
+		}
+		path := path.Join(dir.path, f.Name())
+		if mark {
+			nav.marks[path] = true
+		} else {
+			delete(nav.marks, path)
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// This function returns the range of entries between the anchor and the
+// cursor in visual mode. The cursor is used as the anchor when the anchor is
+// no longer shown. It returns false when visual mode is off.
+func (dir *Dir) visualRange() (int, int, bool) {
+	if dir.visual == "" || len(dir.fi) == 0 {
+		return 0, 0, false
+	}
+
+	anchor := dir.ind
+	for i, f := range dir.fi {
+		if f.Name() == dir.visual {
+			anchor = i
+			break
+		}
+	}
+
+	return min(anchor, dir.ind), max(anchor, dir.ind), true
+}
+
+// This function starts visual mode in the current directory anchored at the
+// current entry. When visual mode is already started, entries in the range
+// are marked and visual mode is ended.
+func (nav *Nav) visual() {
+	dir := nav.currDir()
+
+	beg, end, ok := dir.visualRange()
+	if !ok {
+		if len(dir.fi) != 0 {
+			dir.visual = dir.fi[dir.ind].Name()
+		}
+		return
+	}
+
+	for _, f := range dir.fi[beg : end+1] {
+		nav.marks[path.Join(dir.path, f.Name())] = true
+	}
+
+	dir.visual = ""
+}
+
+// This function ends visual mode in the current directory without marking
+// the entries in the range.
+func (nav *Nav) visualCancel() {
+	nav.currDir().visual = ""
+}
+
+// This function returns the marked files or the current file if there are no
+// marked files.
+func (nav *Nav) currSelection() []string {
 	if len(nav.marks) == 0 {
-		path := nav.currPath()
+		return []string{nav.currPath()}
+	}
+	return nav.currMarks()
+}
 
-		if err := saveFiles([]string{path}, keep); err != nil {
-			return err
+func (nav *Nav) save(keep bool) error {
+	list, err := archiveSources(nav.currSelection(), keep)
+	if err != nil {
+		return err
+	}
+
+	return saveFiles(list, keep)
+}
+
+// This function returns the given files to be copied or moved. Files inside
+// archives are extracted to be copied and they can not be moved.
+func archiveSources(list []string, keep bool) ([]string, error) {
+	srcs := make([]string, 0, len(list))
+	for _, f := range list {
+		if !keep {
+			if err := checkArchive(f); err != nil {
+				return nil, err
+			}
+			if err := checkProtect([]string{f}, gOpts.protect); err != nil {
+				return nil, err
+			}
 		}
-	} else {
-		var fs []string
-		for f := range nav.marks {
-			fs = append(fs, f)
+
+		p, err := extractPath(f)
+		if err != nil {
+			return nil, err
 		}
 
-		if err := saveFiles(fs, keep); err != nil {
-			return err
+		srcs = append(srcs, p)
+	}
+
+	return srcs, nil
+}
+
+// This function returns the list of operations that would be done by paste
+// without doing them. It is used for dry runs.
+func (nav *Nav) pastePlan() ([]string, error) {
+	list, keep, err := loadFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(list) == 0 {
+		return nil, errors.New("no file in copy/cut buffer")
+	}
+
+	op := "move"
+	if keep {
+		op = "copy"
+	}
+
+	dir := nav.currDir()
+
+	var plan []string
+	for _, f := range list {
+		dst := path.Join(dir.path, path.Base(f))
+		line := fmt.Sprintf("%s %s -> %s", op, f, dst)
+		if _, err := os.Lstat(dst); err == nil {
+			line += " (overwrite)"
 		}
+		plan = append(plan, line)
 	}
 
-	return nil
+	return plan, nil
+}
+
+// This function returns the total size and the number of the items in the
+// copy/cut buffer. Directories are counted as single items but their contents
+// are included in the size. Sizes are only calculated when 'confirmpaste' is
+// set since directories are walked to sum the sizes of the files in them.
+func pasteSize() (int64, int) {
+	if gOpts.confirmpaste == 0 {
+		return 0, 0
+	}
+
+	list, _, err := loadFiles()
+	if err != nil {
+		return 0, 0
+	}
+
+	var size int64
+	for _, f := range list {
+		size += diskUsage(f)
+	}
+
+	return size, len(list)
 }
 
+// This function starts a job to copy or move the files in the copy/cut
+// buffer to the current directory. Permission of the directory is checked
+// beforehand so that escalation can be offered right away.
 func (nav *Nav) paste() error {
 	list, keep, err := loadFiles()
 	if err != nil {
@@ -375,31 +1016,456 @@ func (nav *Nav) paste() error {
 	}
 
 	if len(list) == 0 {
-		return errors.New("no file in yank/delete buffer")
+		return errors.New("no file in copy/cut buffer")
 	}
 
 	dir := nav.currDir()
 
-	args := append(list, dir.path)
+	if err := checkWrite(dir.path); err != nil {
+		return err
+	}
+
+	if err := checkProtect(transferPaths(list, dir.path, keep), gOpts.protect); err != nil {
+		return err
+	}
 
-	var sh string
+	op := "move"
 	if keep {
-		sh = "cp"
-	} else {
-		sh = "mv"
+		op = "copy"
+	}
+
+	startJob(&Job{op: op, list: list, dst: dir.path, names: dir.names()})
+
+	return nil
+}
+
+// This function reports whether the given path matches one of the given
+// patterns of 'protect' option. Patterns without a slash are matched against file names as in
+// 'hiddenfiles'. Others are matched against whole paths with a leading '~'
+// expanded and a trailing '/**' also matches everything under the directory
+// (e.g. '~/.ssh/**'). Directories containing the paths matched by such
+// patterns are protected as well (e.g. '/mnt' and '/' for '/mnt/*') since
+// removing or moving them would also affect the protected files.
+func isProtected(p string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(p)); ok {
+				return true
+			}
+			continue
+		}
+
+		if strings.HasPrefix(pattern, "~") {
+			pattern = envHome + pattern[1:]
+		}
+
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+
+		if prefix := literalPrefix(pattern); p == prefix || p == "/" || strings.HasPrefix(prefix, p+"/") {
+			return true
+		}
+
+		if !strings.HasSuffix(pattern, "/**") {
+			continue
+		}
+
+		pattern = strings.TrimSuffix(pattern, "/**")
+		for q := p; q != "/" && q != "."; q = path.Dir(q) {
+			if ok, _ := path.Match(pattern, q); ok {
+				return true
+			}
+		}
 	}
+	return false
+}
 
-	cmd := exec.Command(sh, args...)
+// This function returns the longest directory of the given pattern without
+// wildcards (e.g. '/mnt' for '/mnt/*' or '/mnt/usb' for '/mnt/usb').
+func literalPrefix(pattern string) string {
+	i := strings.IndexAny(pattern, "*?[\\")
+	if i < 0 {
+		return path.Clean(pattern)
+	}
+	if j := strings.LastIndex(pattern[:i], "/"); j > 0 {
+		return pattern[:j]
+	}
+	return "/"
+}
+
+// This function returns an error for the first of the given paths which is
+// protected by the given patterns. It is checked before files are moved,
+// overwritten or removed so that operations are refused as a whole.
+func checkProtect(list []string, patterns []string) error {
+	for _, p := range list {
+		if isProtected(p, patterns) {
+			return fmt.Errorf("protected: %s", p)
+		}
+	}
+	return nil
+}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("%s: %s", sh, err)
+// This function returns the paths modified when the given files are copied or
+// moved to the destination directory. Sources are only modified when they are
+// moved.
+func transferPaths(list []string, dst string, keep bool) []string {
+	var paths []string
+	for _, f := range list {
+		if !keep {
+			paths = append(paths, f)
+		}
+		paths = append(paths, path.Join(dst, path.Base(f)))
 	}
+	return paths
+}
 
-	// TODO: async?
+// This function returns an error if the given directory is not writable.
+// Permission of the destination is checked beforehand so that escalation can
+// be offered before any of the files are transferred.
+func checkWrite(dst string) error {
+	if err := checkArchive(dst); err != nil {
+		return err
+	}
 
+	if err := syscall.Access(dst, accessWrite); err == syscall.EACCES {
+		return &os.PathError{Op: "access", Path: dst, Err: err}
+	}
 	return nil
 }
 
+// This function copies or moves the given files to the destination directory.
+// Each file is tried even if earlier ones fail and errors are written to the
+// given writer unless it is nil. Written bytes are added to the given counter
+// for progress. The first error is returned or 'errCanceled' right away when
+// the quit channel is closed. Operations are logged and checked for protected
+// files with the given options.
+func transfer(list []string, dst string, keep bool, opts JobOpts, written *int64, quit <-chan struct{}, errs io.Writer) error {
+	if err := checkWrite(dst); err != nil {
+		return err
+	}
+
+	if err := checkProtect(transferPaths(list, dst, keep), opts.protect); err != nil {
+		return err
+	}
+
+	op := "move"
+	if keep {
+		op = "copy"
+	}
+
+	var first error
+
+	for _, f := range list {
+		to := path.Join(dst, path.Base(f))
+
+		var err error
+		switch {
+		case to == f:
+			err = fmt.Errorf("%s: same file", f)
+		case strings.HasPrefix(dst+"/", f+"/"):
+			err = fmt.Errorf("%s: cannot %s a directory into itself", f, op)
+		case keep:
+			err = copyPath(f, to, written, quit)
+		default:
+			err = movePath(f, to, written, quit)
+		}
+
+		logOp(opts.oplog, op, f, to, err)
+
+		if err == errCanceled {
+			return err
+		}
+
+		if err != nil {
+			if errs != nil {
+				fmt.Fprintln(errs, err)
+			}
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	return first
+}
+
+// This function removes the given files as in 'transfer'. Sizes of removed
+// files are added to the given counter.
+func remove(list []string, opts JobOpts, removed *int64, quit <-chan struct{}, errs io.Writer) error {
+	if err := checkProtect(list, opts.protect); err != nil {
+		return err
+	}
+
+	var first error
+
+	for _, f := range list {
+		err := removePath(f, removed, quit)
+
+		logOp(opts.oplog, "delete", f, "", err)
+
+		if err == errCanceled {
+			return err
+		}
+
+		if err != nil {
+			if errs != nil {
+				fmt.Fprintln(errs, err)
+			}
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	return first
+}
+
+// This function copies or moves the selected files to the given directory.
+// Marks are cleared afterwards since they are not valid for moved files.
+func (nav *Nav) copyTo(dst string, keep bool) error {
+	f, err := os.Stat(dst)
+	if err != nil {
+		return err
+	}
+
+	if !f.IsDir() {
+		return fmt.Errorf("not a directory: %s", dst)
+	}
+
+	list, err := archiveSources(nav.currSelection(), keep)
+	if err != nil {
+		return err
+	}
+
+	if err := transfer(list, dst, keep, currJobOpts(), nil, nil, nil); err != nil {
+		return err
+	}
+
+	nav.marks = make(map[string]bool)
+
+	return nil
+}
+
+// This function returns the shell command to copy or move files. It is used to
+// rerun operations with escalated privileges.
+func shellOp(keep bool) []string {
+	if keep {
+		return []string{"cp", "-R"}
+	}
+	return []string{"mv"}
+}
+
+// Mode used to check write permission with access system call (i.e. W_OK).
+const accessWrite = 0x2
+
+// This function returns the command line to paste the files in the
+// copy/cut buffer to the current directory. It is used to rerun paste
+// with escalated privileges.
+func (nav *Nav) pasteArgs() ([]string, error) {
+	list, keep, err := loadFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	args := append(shellOp(keep), list...)
+
+	return append(args, nav.currDir().path), nil
+}
+
+// This function renames the given file in the current directory. Existing
+// files are not overwritten unless 'force' is true.
+func (nav *Nav) rename(oldname, newname string, force bool) error {
+	dir := nav.currDir()
+
+	if err := checkArchive(dir.path); err != nil {
+		return err
+	}
+
+	oldpath := path.Join(dir.path, oldname)
+	newpath := path.Join(dir.path, newname)
+
+	if err := checkProtect([]string{oldpath, newpath}, gOpts.protect); err != nil {
+		return err
+	}
+
+	if isCaseRename(oldpath, newpath) {
+		err := renameViaTemp(oldpath, newpath)
+		logOp(gOpts.oplog, "rename", oldpath, newpath, err)
+		return err
+	}
+
+	if _, err := os.Lstat(newpath); err == nil && !force {
+		return fmt.Errorf("file exists: %s", newname)
+	}
+
+	err := os.Rename(oldpath, newpath)
+
+	logOp(gOpts.oplog, "rename", oldpath, newpath, err)
+
+	return err
+}
+
+// This function reports whether the given paths differ only in the case of
+// the name in the same directory and refer to the same file as on
+// case-insensitive file systems. Such renames are not counted as overwriting
+// an existing file.
+func isCaseRename(oldpath, newpath string) bool {
+	if oldpath == newpath || path.Dir(oldpath) != path.Dir(newpath) {
+		return false
+	}
+
+	if !strings.EqualFold(path.Base(oldpath), path.Base(newpath)) {
+		return false
+	}
+
+	fo, err := os.Lstat(oldpath)
+	if err != nil {
+		return false
+	}
+
+	fn, err := os.Lstat(newpath)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(fo, fn)
+}
+
+// This function renames the given file through a temporary name. Some
+// case-insensitive file systems ignore renames changing only the case of the
+// name so these are done in two steps.
+func renameViaTemp(oldpath, newpath string) error {
+	tmp := fmt.Sprintf("%s.lf-rename-%d", oldpath, os.Getpid())
+
+	if err := os.Rename(oldpath, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, newpath); err != nil {
+		if err := os.Rename(tmp, oldpath); err != nil {
+			log.Printf("undoing rename: %s", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+type renameStep struct{ src, dst string }
+
+// This function returns the renames done to rename the given files to the new
+// names in pairs. Files are first renamed to temporary names and then to the
+// new names so that names can be swapped or shifted. An error is returned when
+// new names are not unique or when they belong to existing files which are not
+// renamed, including selected files kept with the same name. Protected files
+// are checked with the given options.
+func renamePlan(olds, news []string, opts JobOpts) ([]renameStep, error) {
+	if len(olds) != len(news) {
+		return nil, fmt.Errorf("expected %d names but got %d", len(olds), len(news))
+	}
+
+	renamed := make(map[string]bool)
+	for i, p := range olds {
+		if news[i] != p {
+			renamed[p] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, p := range news {
+		if p == olds[i] {
+			continue
+		}
+		if seen[p] {
+			return nil, fmt.Errorf("duplicate name: %s", p)
+		}
+		seen[p] = true
+		if _, err := os.Lstat(p); err == nil && !renamed[p] && !isCaseRename(olds[i], p) {
+			return nil, fmt.Errorf("file exists: %s", p)
+		}
+		if err := checkProtect([]string{olds[i], p}, opts.protect); err != nil {
+			return nil, err
+		}
+	}
+
+	var steps, tmps []renameStep
+	for i := range olds {
+		if olds[i] == news[i] {
+			continue
+		}
+		tmp := fmt.Sprintf("%s.lf-rename-%d-%d", olds[i], os.Getpid(), i)
+		steps = append(steps, renameStep{olds[i], tmp})
+		tmps = append(tmps, renameStep{tmp, news[i]})
+	}
+
+	return append(steps, tmps...), nil
+}
+
+// This function renames the given files to the new names as planned in
+// 'renamePlan'. Nothing is renamed when the plan fails and renames done so far
+// are undone when one of them fails.
+func bulkRename(olds, news []string, opts JobOpts) error {
+	steps, err := renamePlan(olds, news, opts)
+	if err != nil {
+		return err
+	}
+
+	var done []renameStep
+	for _, s := range steps {
+		if err := os.Rename(s.src, s.dst); err != nil {
+			for i := len(done) - 1; i >= 0; i-- {
+				if err := os.Rename(done[i].dst, done[i].src); err != nil {
+					log.Printf("undoing rename: %s", err)
+				}
+			}
+			return err
+		}
+		done = append(done, s)
+	}
+
+	for i := range olds {
+		if olds[i] != news[i] {
+			logOp(opts.oplog, "rename", olds[i], news[i], nil)
+		}
+	}
+
+	return nil
+}
+
+// This function moves to the nearest existing ancestor when the current
+// directory is removed (e.g. by another program). Removed directories are
+// dropped from the cache and the ancestor is read again. It returns a message
+// describing the move or an empty string when the current directory exists.
+func (nav *Nav) checkRemoved() string {
+	i := len(nav.dirs) - 1
+	for i > 0 {
+		if f, err := statPath(nav.dirs[i].path); err == nil && isBrowsable(nav.dirs[i].path, f) {
+			break
+		}
+		i--
+	}
+
+	if i == len(nav.dirs)-1 {
+		return ""
+	}
+
+	removed := nav.currDir().path
+
+	for _, d := range nav.dirs[i+1:] {
+		delete(nav.cache, d.path)
+	}
+	nav.dirs = nav.dirs[:i+1]
+
+	dir := nav.currDir()
+	if err := chdir(dir.path); err != nil {
+		log.Printf("changing directory: %s", err)
+	}
+	dir.renew(nav.height)
+
+	return fmt.Sprintf("directory removed: %s, moved to %s", removed, dir.path)
+}
+
 func (nav *Nav) currDir() *Dir {
 	return nav.dirs[len(nav.dirs)-1]
 }
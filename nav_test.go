@@ -0,0 +1,439 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		pattern    string
+		ignorecase bool
+		smartcase  bool
+		exp        bool
+	}{
+		{"foo.go", "foo", false, false, true},
+		{"Foo.go", "foo", false, false, false},
+		{"Foo.go", "foo", true, false, true},
+		{"Foo.go", "Foo", true, true, true},
+		{"foo.go", "Foo", true, true, false},
+		{"foo.go", "Foo", true, false, true},
+		{"foo.go", "bar", true, true, false},
+	}
+
+	defer func(ic, sc bool) { gOpts.ignorecase, gOpts.smartcase = ic, sc }(gOpts.ignorecase, gOpts.smartcase)
+
+	for _, test := range tests {
+		gOpts.ignorecase, gOpts.smartcase = test.ignorecase, test.smartcase
+		if got := searchMatch(test.name, test.pattern); got != test.exp {
+			t.Errorf("at input '%s' and '%s' expected '%t' but got '%t'", test.name, test.pattern, test.exp, got)
+		}
+	}
+}
+
+func TestIsHidden(t *testing.T) {
+	defer func(pats []string) { gOpts.hiddenfiles = pats }(gOpts.hiddenfiles)
+
+	gOpts.hiddenfiles = []string{".*", "*.o", "node_modules"}
+
+	tests := []struct {
+		name string
+		exp  bool
+	}{
+		{".git", true},
+		{"main.o", true},
+		{"main.go", false},
+		{"node_modules", true},
+		{"node_modules.txt", false},
+	}
+
+	for _, test := range tests {
+		if got := isHidden(test.name); got != test.exp {
+			t.Errorf("at input '%s' expected '%t' but got '%t'", test.name, test.exp, got)
+		}
+	}
+}
+
+func TestIsProtected(t *testing.T) {
+	patterns := []string{"~/.ssh/**", "/mnt/*", "*.key"}
+
+	tests := []struct {
+		path string
+		exp  bool
+	}{
+		{path.Join(envHome, ".ssh"), true},
+		{path.Join(envHome, ".ssh", "id_rsa"), true},
+		{path.Join(envHome, ".ssh", "keys", "id_rsa"), true},
+		{path.Join(envHome, ".sshrc"), false},
+		{envHome, true},
+		{path.Dir(envHome), true},
+		{"/", true},
+		{"/mnt/usb", true},
+		{"/mnt/usb/file", false},
+		{"/mnt", true},
+		{"/mntx", false},
+		{"/srv", false},
+		{"/server.key", true},
+		{"/etc/server.key", true},
+		{"/etc/server.pem", false},
+	}
+
+	for _, test := range tests {
+		if got := isProtected(test.path, patterns); got != test.exp {
+			t.Errorf("at input '%s' expected '%t' but got '%t'", test.path, test.exp, got)
+		}
+	}
+}
+
+// This function creates a temporary directory with the given number of files
+// and directories for benchmarks. Names mix letters, numbers and extensions
+// to exercise the sorting types.
+func genDir(b *testing.B, n int) string {
+	dir, err := ioutil.TempDir("", "lf-bench-")
+	if err != nil {
+		b.Fatalf("creating temporary directory: %s", err)
+	}
+
+	exts := []string{"", ".go", ".txt", ".tar.gz", ".jpg"}
+
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("file%d%s", (i*7919)%n, exts[i%len(exts)])
+		if i%10 == 0 {
+			if err := os.Mkdir(path.Join(dir, "dir"+name), 0755); err != nil {
+				b.Fatalf("creating directory: %s", err)
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(path.Join(dir, name), make([]byte, i%4096), 0644); err != nil {
+			b.Fatalf("writing file: %s", err)
+		}
+	}
+
+	return dir
+}
+
+func BenchmarkNewDir(b *testing.B) {
+	dir := genDir(b, 1000)
+	defer os.RemoveAll(dir)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		newDir(dir)
+	}
+}
+
+func BenchmarkOrganizeFiles(b *testing.B) {
+	dir := genDir(b, 1000)
+	defer os.RemoveAll(dir)
+
+	fi, err := ioutil.ReadDir(dir)
+	if err != nil {
+		b.Fatalf("reading directory: %s", err)
+	}
+
+	defer func(s string) { gOpts.sortby = s }(gOpts.sortby)
+
+	for _, s := range gSortTypes {
+		b.Run(s, func(b *testing.B) {
+			gOpts.sortby = s
+			tmp := make([]os.FileInfo, len(fi))
+			for i := 0; i < b.N; i++ {
+				copy(tmp, fi)
+				organizeFiles(tmp)
+			}
+		})
+	}
+}
+
+func BenchmarkDirLoad(b *testing.B) {
+	dir := genDir(b, 1000)
+	defer os.RemoveAll(dir)
+
+	d := newDir(dir)
+	name := d.fi[len(d.fi)-1].Name()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		d.load(0, 0, 40, name)
+	}
+}
+
+func TestBulkRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := func(name string) string { return path.Join(dir, name) }
+
+	for _, name := range []string{"a", "b", "c"} {
+		if err := ioutil.WriteFile(p(name), []byte(name), 0644); err != nil {
+			t.Fatalf("writing file: %s", err)
+		}
+	}
+
+	tests := []struct {
+		olds []string
+		news []string
+		ok   bool
+		exp  map[string]string
+	}{
+		{[]string{"a", "b"}, []string{"b", "a"}, true, map[string]string{"a": "b", "b": "a", "c": "c"}},
+		{[]string{"a", "b"}, []string{"d", "d"}, false, map[string]string{"a": "b", "b": "a", "c": "c"}},
+		{[]string{"a"}, []string{"c"}, false, map[string]string{"a": "b", "b": "a", "c": "c"}},
+		{[]string{"a", "b"}, []string{"b", "b"}, false, map[string]string{"a": "b", "b": "a", "c": "c"}},
+		{[]string{"a", "b", "c"}, []string{"b", "c", "d"}, true, map[string]string{"b": "b", "c": "a", "d": "c"}},
+	}
+
+	for _, test := range tests {
+		var olds, news []string
+		for i := range test.olds {
+			olds = append(olds, p(test.olds[i]))
+			news = append(news, p(test.news[i]))
+		}
+
+		if err := bulkRename(olds, news, JobOpts{}); (err == nil) != test.ok {
+			t.Errorf("at input '%v' to '%v' expected success '%t' but got '%v'", test.olds, test.news, test.ok, err)
+		}
+
+		fi, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("reading directory: %s", err)
+		}
+		if len(fi) != len(test.exp) {
+			t.Errorf("at input '%v' to '%v' expected '%d' files but got '%d'", test.olds, test.news, len(test.exp), len(fi))
+		}
+		for name, data := range test.exp {
+			if b, err := ioutil.ReadFile(p(name)); err != nil || string(b) != data {
+				t.Errorf("at input '%v' to '%v' expected '%s' in '%s' but got '%s'", test.olds, test.news, data, name, b)
+			}
+		}
+	}
+}
+
+func TestRenamePlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := func(name string) string { return path.Join(dir, name) }
+	tmp := func(name string, i int) string { return fmt.Sprintf("%s.lf-rename-%d-%d", p(name), os.Getpid(), i) }
+
+	for _, name := range []string{"a", "b", "c"} {
+		if err := ioutil.WriteFile(p(name), []byte(name), 0644); err != nil {
+			t.Fatalf("writing file: %s", err)
+		}
+	}
+
+	tests := []struct {
+		olds []string
+		news []string
+		exp  []renameStep
+	}{
+		{[]string{"a", "b"}, []string{"a", "b"}, nil},
+		{[]string{"a", "b"}, []string{"b", "a"}, []renameStep{
+			{p("a"), tmp("a", 0)},
+			{p("b"), tmp("b", 1)},
+			{tmp("a", 0), p("b")},
+			{tmp("b", 1), p("a")},
+		}},
+		{[]string{"a", "b"}, []string{"a", "d"}, []renameStep{
+			{p("b"), tmp("b", 1)},
+			{tmp("b", 1), p("d")},
+		}},
+		{[]string{"a"}, []string{"c"}, nil},
+		{[]string{"a", "b"}, []string{"b", "b"}, nil},
+	}
+
+	for _, test := range tests {
+		var olds, news []string
+		for i := range test.olds {
+			olds = append(olds, p(test.olds[i]))
+			news = append(news, p(test.news[i]))
+		}
+
+		// plans are not carried out
+		got, _ := renamePlan(olds, news, JobOpts{})
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("at input '%v' to '%v' expected '%v' but got '%v'", test.olds, test.news, test.exp, got)
+		}
+		if _, err := os.Stat(p("a")); err != nil {
+			t.Errorf("at input '%v' to '%v' expected file 'a' to be kept", test.olds, test.news)
+		}
+	}
+}
+
+func TestAbsPath(t *testing.T) {
+	home := envHome
+	defer func() { envHome = home }()
+	envHome = "/home/user"
+
+	nav := &Nav{dirs: []*Dir{{path: "/foo"}}}
+
+	tests := []struct {
+		s   string
+		exp string
+	}{
+		{"~", "/home/user"},
+		{"~/bar", "/home/user/bar"},
+		{"bar", "/foo/bar"},
+		{"notes.txt~", "/foo/notes.txt~"},
+		{"~bar", "/foo/~bar"},
+		{"/bar/~/baz", "/bar/~/baz"},
+	}
+
+	for _, test := range tests {
+		if got := nav.absPath(test.s); got != test.exp {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", test.s, test.exp, got)
+		}
+	}
+}
+
+func TestNoPerm(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("permissions are not checked for root")
+	}
+
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if d := newDir(dir); d.noPerm {
+		t.Errorf("at readable directory expected permission but got none")
+	}
+
+	if err := os.Chmod(dir, 0); err != nil {
+		t.Fatalf("changing permissions: %s", err)
+	}
+	defer os.Chmod(dir, 0755)
+
+	if d := newDir(dir); !d.noPerm {
+		t.Errorf("at unreadable directory expected no permission but got permission")
+	}
+}
+
+func TestIsCaseRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := func(name string) string { return path.Join(dir, name) }
+
+	for _, name := range []string{"a", "A", "b", "c"} {
+		if err := ioutil.WriteFile(p(name), []byte(name), 0644); err != nil {
+			t.Fatalf("writing file: %s", err)
+		}
+	}
+
+	// hard links to the same file are seen as names differing in case on
+	// case-insensitive file systems
+	for _, name := range []string{"B", "d"} {
+		if err := os.Link(p("b"), p(name)); err != nil {
+			t.Fatalf("linking file: %s", err)
+		}
+	}
+
+	// the same file reached through a directory differing in case is not
+	// renamed in place
+	if err := os.Mkdir(p("s"), 0755); err != nil {
+		t.Fatalf("creating directory: %s", err)
+	}
+	if err := os.Symlink("s", p("S")); err != nil {
+		t.Fatalf("linking directory: %s", err)
+	}
+	for _, name := range []string{"s/x", "s/X"} {
+		if err := os.Link(p("b"), p(name)); err != nil {
+			t.Fatalf("linking file: %s", err)
+		}
+	}
+
+	tests := []struct {
+		old string
+		new string
+		exp bool
+	}{
+		{"a", "A", false},
+		{"b", "B", true},
+		{"b", "b", false},
+		{"b", "d", false},
+		{"c", "C", false},
+		{"s/x", "s/X", true},
+		{"s/x", "S/X", false},
+		{"s/x", "S/x", false},
+	}
+
+	for _, test := range tests {
+		if r := isCaseRename(p(test.old), p(test.new)); r != test.exp {
+			t.Errorf("at input '%s' '%s' expected '%t' but got '%t'", test.old, test.new, test.exp, r)
+		}
+	}
+
+	if err := renameViaTemp(p("b"), p("B")); err != nil {
+		t.Errorf("renaming file: %s", err)
+	}
+
+	if _, err := os.Lstat(p("b")); !os.IsNotExist(err) {
+		t.Errorf("at renamed file expected it to be removed but got '%v'", err)
+	}
+
+	if b, err := ioutil.ReadFile(p("B")); err != nil || string(b) != "b" {
+		t.Errorf("at renamed file expected 'b' in 'B' but got '%s'", b)
+	}
+}
+
+func TestFilterFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lf-test-")
+	if err != nil {
+		t.Fatalf("creating temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(path.Join(dir, "pics"), 0755)
+	ioutil.WriteFile(path.Join(dir, "cat.png"), nil, 0644)
+	ioutil.WriteFile(path.Join(dir, "run.sh"), nil, 0755)
+	ioutil.WriteFile(path.Join(dir, "notes"), nil, 0644)
+
+	fi, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("reading directory: %s", err)
+	}
+
+	tests := []struct {
+		pattern string
+		ftype   string
+		exp     string
+	}{
+		{"", "", "cat.png notes pics run.sh"},
+		{"", "dir", "pics"},
+		{"", "file", "cat.png notes run.sh"},
+		{"", "exec", "run.sh"},
+		{"", "image", "cat.png"},
+		{"o", "file", "notes"},
+		{"p", "dir", "pics"},
+	}
+
+	for _, test := range tests {
+		var names []string
+		for _, f := range filterFiles(fi, test.pattern, test.ftype) {
+			names = append(names, f.Name())
+		}
+		if got := strings.Join(names, " "); got != test.exp {
+			t.Errorf("at input '%s' and '%s' expected '%s' but got '%s'", test.pattern, test.ftype, test.exp, got)
+		}
+	}
+}
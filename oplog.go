@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// OpRecord is a single entry in the operation log. Entries are written as
+// json objects, one per line, so that the log can be processed with common
+// tools to audit or replay operations.
+type OpRecord struct {
+	Time   time.Time `json:"time"`
+	Op     string    `json:"op"`
+	Src    string    `json:"src"`
+	Dst    string    `json:"dst,omitempty"`
+	Result string    `json:"result"`
+}
+
+// This function appends a record of a completed file operation to the given
+// file of 'oplog' option. Nothing is written when the option is empty.
+func logOp(oplog, op, src, dst string, err error) {
+	if oplog == "" {
+		return
+	}
+
+	rec := OpRecord{
+		Time:   time.Now(),
+		Op:     op,
+		Src:    src,
+		Dst:    dst,
+		Result: "ok",
+	}
+
+	if err != nil {
+		rec.Result = err.Error()
+	}
+
+	filename := oplog
+	if strings.HasPrefix(filename, "~") {
+		filename = envHome + filename[1:]
+	}
+
+	f, e := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if e != nil {
+		log.Printf("opening operation log: %s", e)
+		return
+	}
+	defer f.Close()
+
+	if e := json.NewEncoder(f).Encode(rec); e != nil {
+		log.Printf("writing operation log: %s", e)
+	}
+}
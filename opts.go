@@ -1,31 +1,156 @@
 package main
 
+import (
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
 type Opts struct {
-	hidden    bool
-	preview   bool
-	scrolloff int
-	tabstop   int
-	ifs       string
-	showinfo  string
-	sortby    string
-	opener    string
-	ratios    []int
-	keys      map[string]Expr
-	cmds      map[string]Expr
+	autopanes     bool
+	broadcast     bool
+	hidden        bool
+	icons         bool
+	preview       bool
+	readonly      bool
+	sharemarks    bool
+	sequential    bool
+	ignorecase    bool
+	reverse       bool
+	dirfirst      bool
+	smartcase     bool
+	mouse         bool
+	altkeys       bool
+	screenreader  bool
+	scrolloff     int
+	namewidth     int
+	tabstop       int
+	msgtimeout    int
+	cachesize     int
+	clipsize      int
+	treedepth     int
+	flattendepth  int
+	confirmdelete int
+	confirmpaste  int
+	history       int
+	escalate      string
+	ifs           string
+	nested        string
+	markchar      string
+	theme         string
+	timefmt       string
+	infotimefmt   string
+	decimalsep    string
+	markmode      string
+	bell          string
+	markcolor     termbox.Attribute
+	pwdmode       string
+	info          []string
+	ruler         []string
+	sortby        string
+	opener        string
+	clipboard     string
+	cleaner       string
+	announce      string
+	imagepreview  string
+	colors        string
+	previewer     string
+	cachedir      string
+	terminal      string
+	oplog         string
+	ratios        []int
+	hiddenfiles   []string
+	protect       []string
+	keys          map[string]Expr
+	cmds          map[string]Expr
+	cmddirs       map[string]string
+	openers       []Handler
+	prevs         []Handler
 }
 
-var gOpts Opts
+// Handler is used to keep openers and previewers defined for file name
+// patterns in the configuration file.
+type Handler struct {
+	glob string
+	expr Expr
+}
+
+// This function returns the expression of the last handler matching the name
+// of the given file so that later definitions override earlier ones.
+func findHandler(handlers []Handler, name string) Expr {
+	base := path.Base(name)
+	for i := len(handlers) - 1; i >= 0; i-- {
+		if ok, _ := path.Match(handlers[i].glob, base); ok {
+			return handlers[i].expr
+		}
+	}
+	return nil
+}
+
+var (
+	gOpts        Opts
+	gDefaultOpts Opts
+	gDefaultKeys map[string]Expr
+)
 
 func init() {
+	gOpts.autopanes = false
+	gOpts.broadcast = false
 	gOpts.hidden = false
+	gOpts.icons = false
 	gOpts.preview = true
+	gOpts.readonly = false
+	gOpts.sharemarks = false
+	gOpts.sequential = false
+	gOpts.ignorecase = true
+	gOpts.reverse = false
+	gOpts.dirfirst = true
+	gOpts.smartcase = true
+	gOpts.mouse = false
+	gOpts.altkeys = false
+	gOpts.screenreader = false
 	gOpts.scrolloff = 0
+	gOpts.namewidth = 10
 	gOpts.tabstop = 8
+	gOpts.msgtimeout = 0
+	gOpts.escalate = ""
 	gOpts.ifs = ""
-	gOpts.showinfo = "none"
-	gOpts.sortby = "name"
+	gOpts.nested = "allow"
+	gOpts.markchar = " "
+	gOpts.theme = "default"
+	gOpts.timefmt = time.ANSIC
+	gOpts.infotimefmt = "Jan _2 15:04"
+	gOpts.decimalsep = "."
+	gOpts.markmode = "margin"
+	gOpts.bell = "none"
+	gOpts.markcolor = termbox.ColorMagenta
+	gOpts.pwdmode = "logical"
+	gOpts.info = nil
+	gOpts.sortby = "natural"
 	gOpts.opener = "xdg-open"
+	gOpts.oplog = ""
+	gOpts.terminal = ""
+	gOpts.clipboard = "xclip -selection clipboard"
+	gOpts.cleaner = ""
+	gOpts.announce = ""
+	gOpts.imagepreview = "auto"
+	gOpts.colors = ""
+	gOpts.previewer = ""
+	gOpts.cachedir = ""
+	gOpts.cachesize = 100
+	gOpts.clipsize = 1024
+	gOpts.treedepth = 4
+	gOpts.flattendepth = 3
+	gOpts.confirmdelete = 0
+	gOpts.confirmpaste = 0
+	gOpts.history = 1000
 	gOpts.ratios = []int{1, 2, 3}
+	gOpts.hiddenfiles = []string{".*"}
+	gOpts.protect = nil
+	gOpts.ruler = []string{"mode", "acc", "progress", "selection", "filter", "ind", "position"}
 
 	gOpts.keys = make(map[string]Expr)
 
@@ -40,17 +165,113 @@ func init() {
 	gOpts.keys["q"] = &CallExpr{"quit", nil}
 	gOpts.keys["G"] = &CallExpr{"bot", nil}
 	gOpts.keys["gg"] = &CallExpr{"top", nil}
+	gOpts.keys["gt"] = &CallExpr{"tab-next", nil}
+	gOpts.keys["gT"] = &CallExpr{"tab-prev", nil}
 	gOpts.keys[":"] = &CallExpr{"read", nil}
 	gOpts.keys["$"] = &CallExpr{"read-shell", nil}
+	gOpts.keys["%"] = &CallExpr{"read-shell-pipe", nil}
 	gOpts.keys["!"] = &CallExpr{"read-shell-wait", nil}
 	gOpts.keys["&"] = &CallExpr{"read-shell-async", nil}
 	gOpts.keys["/"] = &CallExpr{"search", nil}
 	gOpts.keys["?"] = &CallExpr{"search-back", nil}
+	gOpts.keys["n"] = &CallExpr{"search-next", nil}
+	gOpts.keys["N"] = &CallExpr{"search-prev", nil}
 	gOpts.keys["<space>"] = &CallExpr{"toggle", nil}
-	gOpts.keys["y"] = &CallExpr{"yank", nil}
-	gOpts.keys["d"] = &CallExpr{"delete", nil}
+	gOpts.keys["v"] = &CallExpr{"visual", nil}
+	gOpts.keys["<esc>"] = &CallExpr{"visual-cancel", nil}
+	gOpts.keys["y"] = &CallExpr{"copy", nil}
+	gOpts.keys["d"] = &CallExpr{"cut", nil}
 	gOpts.keys["p"] = &CallExpr{"paste", nil}
+	gOpts.keys["r"] = &CallExpr{"rename", nil}
+	gOpts.keys["w"] = &CallExpr{"shell", nil}
 	gOpts.keys["<c-l>"] = &CallExpr{"redraw", nil}
+	gOpts.keys["zh"] = &SetExpr{"hidden!", ""}
+	gOpts.keys["m"] = &CallExpr{"mark-save", nil}
+	gOpts.keys["'"] = &CallExpr{"mark-load", nil}
+	gOpts.keys["zt"] = &CallExpr{"tree", nil}
+	gOpts.keys["zo"] = &CallExpr{"tree-expand", nil}
+	gOpts.keys["zc"] = &CallExpr{"tree-collapse", nil}
 
 	gOpts.cmds = make(map[string]Expr)
+	gOpts.cmddirs = make(map[string]string)
+
+	gDefaultOpts = gOpts
+
+	gDefaultKeys = make(map[string]Expr)
+	for key, expr := range gOpts.keys {
+		gDefaultKeys[key] = expr
+	}
+}
+
+func fmtBool(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// This function returns the names and values of options as they are written
+// in 'set' commands. It is used to list the current state of options.
+func (opts *Opts) list() [][2]string {
+	var rats []string
+	for _, r := range opts.ratios {
+		rats = append(rats, strconv.Itoa(r))
+	}
+
+	return [][2]string{
+		{"autopanes", fmtBool(opts.autopanes)},
+		{"broadcast", fmtBool(opts.broadcast)},
+		{"hidden", fmtBool(opts.hidden)},
+		{"icons", fmtBool(opts.icons)},
+		{"preview", fmtBool(opts.preview)},
+		{"readonly", fmtBool(opts.readonly)},
+		{"sharemarks", fmtBool(opts.sharemarks)},
+		{"sequential", fmtBool(opts.sequential)},
+		{"ignorecase", fmtBool(opts.ignorecase)},
+		{"reverse", fmtBool(opts.reverse)},
+		{"dirfirst", fmtBool(opts.dirfirst)},
+		{"smartcase", fmtBool(opts.smartcase)},
+		{"mouse", fmtBool(opts.mouse)},
+		{"altkeys", fmtBool(opts.altkeys)},
+		{"screenreader", fmtBool(opts.screenreader)},
+		{"scrolloff", strconv.Itoa(opts.scrolloff)},
+		{"namewidth", strconv.Itoa(opts.namewidth)},
+		{"tabstop", strconv.Itoa(opts.tabstop)},
+		{"msgtimeout", strconv.Itoa(opts.msgtimeout)},
+		{"escalate", opts.escalate},
+		{"ifs", opts.ifs},
+		{"nested", opts.nested},
+		{"markchar", opts.markchar},
+		{"theme", opts.theme},
+		{"timefmt", opts.timefmt},
+		{"infotimefmt", opts.infotimefmt},
+		{"decimalsep", opts.decimalsep},
+		{"markmode", opts.markmode},
+		{"bell", opts.bell},
+		{"markcolor", colorName(opts.markcolor)},
+		{"pwdmode", opts.pwdmode},
+		{"info", strings.Join(opts.info, ",")},
+		{"sortby", opts.sortby},
+		{"opener", opts.opener},
+		{"oplog", opts.oplog},
+		{"terminal", opts.terminal},
+		{"clipboard", opts.clipboard},
+		{"cleaner", opts.cleaner},
+		{"announce", opts.announce},
+		{"imagepreview", opts.imagepreview},
+		{"colors", opts.colors},
+		{"previewer", opts.previewer},
+		{"cachedir", opts.cachedir},
+		{"cachesize", strconv.Itoa(opts.cachesize)},
+		{"clipsize", strconv.Itoa(opts.clipsize)},
+		{"treedepth", strconv.Itoa(opts.treedepth)},
+		{"flattendepth", strconv.Itoa(opts.flattendepth)},
+		{"confirmdelete", strconv.Itoa(opts.confirmdelete)},
+		{"confirmpaste", strconv.Itoa(opts.confirmpaste)},
+		{"history", strconv.Itoa(opts.history)},
+		{"ratios", strings.Join(rats, ":")},
+		{"hiddenfiles", strings.Join(opts.hiddenfiles, ":")},
+		{"protect", strings.Join(opts.protect, ":")},
+		{"ruler", strings.Join(opts.ruler, ":")},
+	}
 }
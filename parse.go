@@ -4,7 +4,11 @@ package main
 //
 // Expr     = SetExpr
 //          | MapExpr
+//          | RemapExpr
 //          | CmdExpr
+//          | OpenExpr
+//          | PrevExpr
+//          | DirExpr
 //          | CallExpr
 //          | ExecExpr
 //          | ListExpr
@@ -13,14 +17,23 @@ package main
 //
 // MapExpr  = 'map' <keys> Expr ';'
 //
+// RemapExpr = 'remap'   <keys> <keys> ';'
+//           | 'noremap' <keys> <keys> ';'
+//
 // CmdExpr  = 'cmd' <name> Expr ';'
 //
+// OpenExpr = 'opener' <glob> Expr ';'
+//
+// PrevExpr = 'previewer' <glob> Expr ';'
+//
+// DirExpr  = 'cmddir' <name> <dir> ';'
+//
 // CallExpr = <name> <args> ';'
 //
 // ExecExpr = Prefix      <expr>      '\n'
 //          | Prefix '{{' <expr> '}}' ';'
 //
-// Prefix   = '$' | '!' | '&' | '/' | '?'
+// Prefix   = '$' | '%' | '!' | '&' | '/' | '?'
 //
 // ListExpr = ':'      ListExpr      '\n'
 //          | ':' '{{' ListRest '}}' ';'
@@ -32,6 +45,8 @@ import (
 	"fmt"
 	"io"
 	"log"
+
+	"github.com/nsf/termbox-go"
 )
 
 type Expr interface {
@@ -55,6 +70,34 @@ type MapExpr struct {
 
 func (e *MapExpr) String() string { return fmt.Sprintf("map %s %s", e.keys, e.expr) }
 
+// RemapExpr binds keys to a sequence of other keys. Keys in the sequence are
+// looked up in the other key sequence bindings as well when 'remap' is true.
+type RemapExpr struct {
+	keys  string
+	to    string
+	remap bool
+}
+
+func (e *RemapExpr) String() string {
+	if e.remap {
+		return fmt.Sprintf("remap %s %s", e.keys, e.to)
+	}
+	return fmt.Sprintf("noremap %s %s", e.keys, e.to)
+}
+
+// KeysExpr is the sequence of keys bound with 'remap' or 'noremap'.
+type KeysExpr struct {
+	keys  string
+	remap bool
+}
+
+func (e *KeysExpr) String() string {
+	if e.remap {
+		return fmt.Sprintf("keys %s", e.keys)
+	}
+	return fmt.Sprintf("keys %s (noremap)", e.keys)
+}
+
 type CmdExpr struct {
 	name string
 	expr Expr
@@ -62,6 +105,35 @@ type CmdExpr struct {
 
 func (e *CmdExpr) String() string { return fmt.Sprintf("cmd %s %s", e.name, e.expr) }
 
+type OpenExpr struct {
+	glob string
+	expr Expr
+}
+
+func (e *OpenExpr) String() string { return fmt.Sprintf("opener %s %s", e.glob, e.expr) }
+
+type PrevExpr struct {
+	glob string
+	expr Expr
+}
+
+func (e *PrevExpr) String() string { return fmt.Sprintf("previewer %s %s", e.glob, e.expr) }
+
+type DirExpr struct {
+	name string
+	dir  string
+}
+
+func (e *DirExpr) String() string { return fmt.Sprintf("cmddir %s %s", e.name, e.dir) }
+
+// MouseExpr is a mouse event read while 'mouse' option is set.
+type MouseExpr struct {
+	key  termbox.Key
+	x, y int
+}
+
+func (e *MouseExpr) String() string { return fmt.Sprintf("mouse %d %d %d", e.key, e.x, e.y) }
+
 type CallExpr struct {
 	name string
 	args []string
@@ -69,9 +141,13 @@ type CallExpr struct {
 
 func (e *CallExpr) String() string { return fmt.Sprintf("%s -- %s", e.name, e.args) }
 
+// ExecExpr is a shell command or a search pattern. Placeholders (e.g. '%f')
+// are only expanded in shell commands of 'map' and 'cmd' definitions so that
+// commands typed in the prompt are run as they are.
 type ExecExpr struct {
 	pref string
 	expr string
+	tmpl bool
 }
 
 func (e *ExecExpr) String() string { return fmt.Sprintf("%s %s", e.pref, e.expr) }
@@ -94,6 +170,7 @@ type Parser struct {
 	scanner *Scanner
 	expr    Expr
 	err     error
+	tmpl    int // depth of 'map' and 'cmd' definitions being parsed
 }
 
 func newParser(r io.Reader) *Parser {
@@ -106,12 +183,41 @@ func newParser(r io.Reader) *Parser {
 	}
 }
 
+// This function sets the error of the parser with the position of the current
+// token. Only the first error is kept.
+func (p *Parser) errorf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	s := p.scanner
+	line, col := s.position(s.pos)
+	p.err = fmt.Errorf("%d:%d: %s", line, col, fmt.Sprintf(format, args...))
+}
+
+// This function returns whether the current token is a word given as an
+// argument of a keyword and sets the error otherwise.
+func (p *Parser) expectIdent(name string) bool {
+	s := p.scanner
+	if s.err != nil {
+		p.err = s.err
+		return false
+	}
+	if s.typ != TokenIdent {
+		p.errorf("missing %s", name)
+		return false
+	}
+	return true
+}
+
 func (p *Parser) parseExpr() Expr {
 	s := p.scanner
 
 	var result Expr
 
-	// TODO: syntax error check
+	if s.err != nil {
+		p.err = s.err
+		return nil
+	}
 
 	switch s.typ {
 	case TokenEOF:
@@ -122,6 +228,9 @@ func (p *Parser) parseExpr() Expr {
 		switch s.tok {
 		case "set":
 			s.scan()
+			if !p.expectIdent("option name") {
+				return nil
+			}
 			opt := s.tok
 
 			s.scan()
@@ -136,20 +245,88 @@ func (p *Parser) parseExpr() Expr {
 			result = &SetExpr{opt, val}
 		case "map":
 			s.scan()
+			if !p.expectIdent("keys") {
+				return nil
+			}
 			keys := s.tok
 
 			s.scan()
+			p.tmpl++
 			expr := p.parseExpr()
+			p.tmpl--
 
 			result = &MapExpr{keys, expr}
+		case "remap", "noremap":
+			remap := s.tok == "remap"
+
+			s.scan()
+			if !p.expectIdent("keys") {
+				return nil
+			}
+			keys := s.tok
+
+			s.scan()
+			if !p.expectIdent("target keys") {
+				return nil
+			}
+			to := s.tok
+
+			s.scan()
+			s.scan()
+
+			result = &RemapExpr{keys, to, remap}
 		case "cmd":
 			s.scan()
+			if !p.expectIdent("command name") {
+				return nil
+			}
 			name := s.tok
 
 			s.scan()
+			p.tmpl++
 			expr := p.parseExpr()
+			p.tmpl--
 
 			result = &CmdExpr{name, expr}
+		case "opener":
+			s.scan()
+			if !p.expectIdent("pattern") {
+				return nil
+			}
+			glob := s.tok
+
+			s.scan()
+			expr := p.parseExpr()
+
+			result = &OpenExpr{glob, expr}
+		case "previewer":
+			s.scan()
+			if !p.expectIdent("pattern") {
+				return nil
+			}
+			glob := s.tok
+
+			s.scan()
+			expr := p.parseExpr()
+
+			result = &PrevExpr{glob, expr}
+		case "cmddir":
+			s.scan()
+			if !p.expectIdent("command name") {
+				return nil
+			}
+			name := s.tok
+
+			s.scan()
+			if !p.expectIdent("directory") {
+				return nil
+			}
+			dir := s.tok
+
+			s.scan()
+			s.scan()
+
+			result = &DirExpr{name, dir}
 		default:
 			name := s.tok
 
@@ -161,7 +338,7 @@ func (p *Parser) parseExpr() Expr {
 			s.scan()
 
 			if s.err != nil {
-				p.err = fmt.Errorf("parsing: %s", s.err)
+				p.err = s.err
 				return nil
 			}
 
@@ -172,10 +349,15 @@ func (p *Parser) parseExpr() Expr {
 
 		var exprs []Expr
 		if s.typ == TokenLBraces {
+			beg := s.pos
 			s.scan()
 			for {
 				e := p.parseExpr()
 				if e == nil {
+					if p.err == nil {
+						s.pos = beg
+						p.errorf("unterminated '{{'")
+					}
 					return nil
 				}
 				exprs = append(exprs, e)
@@ -212,21 +394,23 @@ func (p *Parser) parseExpr() Expr {
 			s.scan()
 		} else if s.typ == TokenCommand {
 			expr = s.tok
-		} else {
-			// TODO: handle error
+		} else if s.err != nil {
+			p.err = s.err
+			return nil
 		}
 
 		s.scan()
 		s.scan()
 
 		if s.err != nil {
-			p.err = fmt.Errorf("parsing: %s", s.err)
+			p.err = s.err
 			return nil
 		}
 
-		result = &ExecExpr{pref, expr}
+		result = &ExecExpr{pref, expr, p.tmpl > 0}
 	default:
-		// TODO: handle error
+		p.errorf("unexpected '%s'", s.tok)
+		return nil
 	}
 
 	log.Println("parsed:", result)
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		s   string
+		err string
+	}{
+		{"set hidden\nset\n", "2:4: missing option name"},
+		{"map\n", "1:4: missing keys"},
+		{"set hidden\n  cmd ;", "2:7: missing command name"},
+		{"noremap J\n", "1:10: missing target keys"},
+		{"cmddir build\n", "1:13: missing directory"},
+		{"cmd foo ${{\n\techo foo\n", "1:10: unterminated '{{'"},
+		{"cmd foo ${{", "1:10: unterminated '{{'"},
+		{"map x :{{\n\tcd ~\n", "1:8: unterminated '{{'"},
+		{"set hidden\n}}\n", "2:1: unexpected '}}'"},
+	}
+
+	for _, test := range tests {
+		p := newParser(strings.NewReader(test.s))
+		for p.parse() {
+		}
+		if p.err == nil || p.err.Error() != test.err {
+			t.Errorf("at input '%s' expected '%s' but got '%v'", test.s, test.err, p.err)
+		}
+	}
+}
+
+func TestParseValid(t *testing.T) {
+	inps := []string{
+		"set hidden\nmap x {foo}\ncmd a :{{\n\tcd ~\n}}\n",
+		"cmd trash ${{\n\tmv $fx ~/.trash\n}}\nmap D trash",
+		"cmd trash\nmap D\n",
+	}
+
+	for _, inp := range inps {
+		p := newParser(strings.NewReader(inp))
+		for p.parse() {
+		}
+		if p.err != nil {
+			t.Errorf("at input '%s' expected no error but got '%s'", inp, p.err)
+		}
+	}
+}
+
+func TestParseTemplates(t *testing.T) {
+	tests := []struct {
+		s    string
+		tmpl bool
+	}{
+		{"map a $tar czf x.tgz %s\n", true},
+		{"cmd a :{{\n\t$echo %f\n}}\n", true},
+		{"$date +%d\n", false},
+		{":{{\n\t$echo %f\n}}\n", false},
+	}
+
+	for _, test := range tests {
+		p := newParser(strings.NewReader(test.s))
+		if !p.parse() {
+			t.Errorf("at input '%s' expected no error but got '%v'", test.s, p.err)
+			continue
+		}
+
+		var exec *ExecExpr
+		var find func(e Expr)
+		find = func(e Expr) {
+			switch e := e.(type) {
+			case *ExecExpr:
+				exec = e
+			case *MapExpr:
+				find(e.expr)
+			case *CmdExpr:
+				find(e.expr)
+			case *ListExpr:
+				for _, e := range e.exprs {
+					find(e)
+				}
+			}
+		}
+		find(p.expr)
+
+		if exec == nil || exec.tmpl != test.tmpl {
+			t.Errorf("at input '%s' expected template '%t' but got '%v'", test.s, test.tmpl, exec)
+		}
+	}
+}
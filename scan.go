@@ -16,7 +16,7 @@ const (
 	// no explicit keyword type
 	TokenIdent     // e.g. set, ratios, 1:2:3
 	TokenColon     // :
-	TokenPrefix    // $, !, &, / or ?
+	TokenPrefix    // $, %, !, &, / or ?
 	TokenLBraces   // {{
 	TokenRBraces   // }}
 	TokenCommand   // in between a prefix to \n or between {{ and }}
@@ -36,7 +36,7 @@ type Scanner struct {
 	typ TokenType // scanned token type
 	tok string    // scanned token value
 	err error     // error if any
-	// TODO: pos
+	pos int       // offset of scanned token
 }
 
 func newScanner(r io.Reader) *Scanner {
@@ -87,20 +87,52 @@ func (s *Scanner) peek() byte {
 	return 0
 }
 
+// This function returns the line and column of the given offset in the input
+// starting from 1. It is used to report the position of syntax errors.
+func (s *Scanner) position(off int) (int, int) {
+	line, col := 1, 1
+	for _, b := range s.buf[:min(off, len(s.buf))] {
+		if b == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// This function sets the error of the scanner with the position of the given
+// offset and stops scanning. Only the first error is kept.
+func (s *Scanner) errorf(off int, format string, args ...interface{}) bool {
+	if s.err == nil {
+		line, col := s.position(off)
+		s.err = fmt.Errorf("%d:%d: %s", line, col, fmt.Sprintf(format, args...))
+	}
+	s.typ = TokenErr
+	s.tok = ""
+	return false
+}
+
 func isSpace(b byte) bool {
 	return unicode.IsSpace(rune(b))
 }
 
 func isPrefix(b byte) bool {
 	// TODO: how to differentiate slash in path vs search?
-	return b == '$' || b == '!' || b == '&' // || b == '/' || b == '?'
+	return b == '$' || b == '%' || b == '!' || b == '&' // || b == '/' || b == '?'
 }
 
 func (s *Scanner) scan() bool {
 	// log.Println("scanning:", s.tok)
 scan:
+	s.pos = s.off
 	switch {
 	case s.eof:
+		if s.blk {
+			// input ended right after the braces
+			return s.errorf(s.off-2, "unterminated '{{'")
+		}
 		s.next()
 		if s.sem {
 			s.typ = TokenSemicolon
@@ -141,8 +173,7 @@ scan:
 				}
 			}
 		}
-		// TODO: fill error
-		return false
+		return s.errorf(beg-2, "unterminated '{{'")
 	case s.cmd:
 		for !s.eof && isSpace(s.chr) {
 			s.next()
@@ -170,13 +201,17 @@ scan:
 		s.cmd = false
 		s.sem = true
 	case s.chr == '\n':
-		s.next()
 		if s.sem {
 			s.typ = TokenSemicolon
 			s.tok = "\n"
 			s.sem = false
+			// newline is scanned again to end the list
+			if !s.nln {
+				s.next()
+			}
 			return true
 		}
+		s.next()
 		if s.nln {
 			s.typ = TokenSemicolon
 			s.tok = "\n"
@@ -204,27 +239,19 @@ scan:
 		s.tok = ":"
 		s.nln = true
 		s.next()
-	case s.chr == '{':
-		if s.peek() == '{' {
-			s.next()
-			s.next()
-			s.typ = TokenLBraces
-			s.tok = "{{"
-			s.sem = false
-			s.nln = false
-			return true
-		}
-		// TODO: handle error
-	case s.chr == '}':
-		if s.peek() == '}' {
-			s.next()
-			s.next()
-			s.typ = TokenRBraces
-			s.tok = "}}"
-			s.sem = true
-			return true
-		}
-		// TODO: handle error
+	case s.chr == '{' && s.peek() == '{':
+		s.next()
+		s.next()
+		s.typ = TokenLBraces
+		s.tok = "{{"
+		s.sem = false
+		s.nln = false
+	case s.chr == '}' && s.peek() == '}':
+		s.next()
+		s.next()
+		s.typ = TokenRBraces
+		s.tok = "}}"
+		s.sem = true
 	case isPrefix(s.chr):
 		s.typ = TokenPrefix
 		s.tok = string(s.chr)
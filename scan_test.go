@@ -56,6 +56,13 @@ var inp23 = `cmd compress ${{
 var inp24 = `cmd compress ${{
 	mkdir "$1"`
 
+var inp25 = `map x :{{
+	toggle
+	%echo done
+}}`
+
+var inp26 = "map x :toggle; down\nmap y up\n"
+
 var out0 = []string{}
 var out1 = []string{}
 var out2 = []string{"set", "hidden", "\n"}
@@ -81,6 +88,8 @@ var out21 = []string{"map", "gh", ":", "{{", "cd", "~", "\n", "set", "hidden", "
 var out22 = []string{"map", "c", "$", "{{", "\n\tmkdir foo\n\tIFS=':'; cp ${fs} foo\n\ttar -czvf \"foo.tar.gz\" foo\n\trm -rf foo\n", "}}", "\n"}
 var out23 = []string{"cmd", "compress", "$", "{{", "\n\tmkdir \"$1\"\n\tIFS=':'; cp ${fs} \"$1\"\n\ttar -czvf \"$1.tar.gz\" \"$1\"\n\trm -rf \"$1\"\n", "}}", "\n"}
 var out24 = []string{"cmd", "compress", "$", "{{"}
+var out25 = []string{"map", "x", ":", "{{", "toggle", "\n", "%", "echo done", "\n", "}}", "\n"}
+var out26 = []string{"map", "x", ":", "toggle", ";", "down", "\n", "\n", "map", "y", "up", "\n"}
 
 func compare(t *testing.T, inp string, out []string) {
 	s := newScanner(strings.NewReader(inp))
@@ -122,4 +131,6 @@ func TestScan(t *testing.T) {
 	compare(t, inp22, out22)
 	compare(t, inp23, out23)
 	compare(t, inp24, out24)
+	compare(t, inp25, out25)
+	compare(t, inp26, out26)
 }
@@ -0,0 +1,204 @@
+//go:build headless
+// +build headless
+
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Screen is a fake terminal keeping the drawn cells in memory. It is used in
+// builds with 'headless' tag so that the ui can be tested end to end without
+// a terminal. Cells are recorded as they are set and copied to the rendered
+// grid on flush as in a real terminal. Events are read from a queue filled
+// with 'screenFeed'.
+type Screen struct {
+	w, h    int
+	back    []termbox.Cell
+	front   []termbox.Cell
+	cx, cy  int // cursor position or -1 when hidden
+	events  chan termbox.Event
+	flushes int
+	mouse   bool     // mouse events are reported
+	raw     []string // escape sequences written with 'screenRaw'
+	mutex   sync.Mutex
+}
+
+// Size of the fake terminal used in headless builds.
+var gHeadlessWidth, gHeadlessHeight = 80, 24
+
+// Screen is reset in place when it is initialized again since goroutines
+// started before (e.g. directory reads of earlier tests) may still interrupt
+// it. Fields are only accessed with the mutex held.
+var gScreen = &Screen{}
+
+func screenInit() error {
+	gScreen.mutex.Lock()
+	defer gScreen.mutex.Unlock()
+
+	w, h := gHeadlessWidth, gHeadlessHeight
+
+	gScreen.w, gScreen.h = w, h
+	gScreen.back = make([]termbox.Cell, w*h)
+	gScreen.front = make([]termbox.Cell, w*h)
+	gScreen.cx, gScreen.cy = -1, -1
+	gScreen.events = make(chan termbox.Event, 1024)
+	gScreen.flushes = 0
+	gScreen.mouse = false
+	gScreen.raw = nil
+
+	for i := range gScreen.back {
+		gScreen.back[i] = termbox.Cell{Ch: ' ', Fg: termbox.ColorDefault, Bg: termbox.ColorDefault}
+	}
+
+	return nil
+}
+
+func screenClose() {}
+
+func screenSize() (int, int) {
+	gScreen.mutex.Lock()
+	defer gScreen.mutex.Unlock()
+
+	return gScreen.w, gScreen.h
+}
+
+// This function returns the queue of events. Events are sent and received
+// without the mutex held since the queue may block.
+func screenEvents() chan termbox.Event {
+	gScreen.mutex.Lock()
+	defer gScreen.mutex.Unlock()
+
+	return gScreen.events
+}
+
+func screenSetCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	gScreen.mutex.Lock()
+	defer gScreen.mutex.Unlock()
+
+	if x < 0 || x >= gScreen.w || y < 0 || y >= gScreen.h {
+		return
+	}
+	gScreen.back[y*gScreen.w+x] = termbox.Cell{Ch: ch, Fg: fg, Bg: bg}
+}
+
+func screenClear(fg, bg termbox.Attribute) error {
+	gScreen.mutex.Lock()
+	defer gScreen.mutex.Unlock()
+
+	for i := range gScreen.back {
+		gScreen.back[i] = termbox.Cell{Ch: ' ', Fg: fg, Bg: bg}
+	}
+	return nil
+}
+
+func screenFlush() error {
+	gScreen.mutex.Lock()
+	defer gScreen.mutex.Unlock()
+
+	copy(gScreen.front, gScreen.back)
+	gScreen.flushes++
+	return nil
+}
+
+func screenSetCursor(x, y int) {
+	gScreen.mutex.Lock()
+	gScreen.cx, gScreen.cy = x, y
+	gScreen.mutex.Unlock()
+}
+
+func screenHideCursor() {
+	screenSetCursor(-1, -1)
+}
+
+func screenPollEvent() termbox.Event {
+	return <-screenEvents()
+}
+
+func screenInterrupt() {
+	select {
+	case screenEvents() <- termbox.Event{Type: termbox.EventInterrupt}:
+	default:
+	}
+}
+
+func screenSync() error {
+	return screenFlush()
+}
+
+func screenSetInput(mouse, alt bool) {
+	gScreen.mutex.Lock()
+	gScreen.mouse = mouse
+	gScreen.mutex.Unlock()
+}
+
+// This function records the given escape sequences instead of drawing them.
+func screenRaw(x, y int, s string) {
+	gScreen.mutex.Lock()
+	gScreen.raw = append(gScreen.raw, s)
+	gScreen.mutex.Unlock()
+}
+
+// This function returns the escape sequences written so far and clears them.
+func screenRawOutput() []string {
+	gScreen.mutex.Lock()
+	defer gScreen.mutex.Unlock()
+
+	raw := gScreen.raw
+	gScreen.raw = nil
+	return raw
+}
+
+// This function queues a mouse event for the given button or wheel key at the
+// given position. Events are dropped as in a real terminal unless mouse
+// reporting is enabled.
+func screenFeedMouse(key termbox.Key, x, y int) {
+	gScreen.mutex.Lock()
+	on := gScreen.mouse
+	gScreen.mutex.Unlock()
+
+	if on {
+		screenEvents() <- termbox.Event{Type: termbox.EventMouse, Key: key, MouseX: x, MouseY: y}
+	}
+}
+
+// This function queues key events for the given keys in the notation used in
+// key bindings (e.g. 'gg' or '<c-l>'). Keys without a notation are skipped.
+func screenFeed(keys string) {
+	for _, key := range splitKeys(keys) {
+		if ev, ok := keyEvent(key); ok {
+			screenEvents() <- ev
+		}
+	}
+}
+
+// This function returns the rendered lines of the fake terminal with trailing
+// spaces removed.
+func screenLines() []string {
+	gScreen.mutex.Lock()
+	defer gScreen.mutex.Unlock()
+
+	lines := make([]string, gScreen.h)
+	for y := range lines {
+		var buf []rune
+		for _, c := range gScreen.front[y*gScreen.w : (y+1)*gScreen.w] {
+			if c.Ch == 0 {
+				c.Ch = ' '
+			}
+			buf = append(buf, c.Ch)
+		}
+		lines[y] = strings.TrimRight(string(buf), " ")
+	}
+	return lines
+}
+
+// This function returns the rendered cell at the given position.
+func screenCell(x, y int) termbox.Cell {
+	gScreen.mutex.Lock()
+	defer gScreen.mutex.Unlock()
+
+	return gScreen.front[y*gScreen.w+x]
+}
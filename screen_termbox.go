@@ -0,0 +1,51 @@
+//go:build !headless
+// +build !headless
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nsf/termbox-go"
+)
+
+// These functions are the terminal backend used to draw the ui and to read
+// events. Builds with 'headless' tag use a fake screen instead so that the ui
+// can be tested without a terminal (see 'screen_headless.go').
+
+func screenClose()                                              { termbox.Close() }
+func screenSize() (int, int)                                    { return termbox.Size() }
+func screenSetCell(x, y int, ch rune, fg, bg termbox.Attribute) { termbox.SetCell(x, y, ch, fg, bg) }
+func screenClear(fg, bg termbox.Attribute) error                { return termbox.Clear(fg, bg) }
+func screenFlush() error                                        { return termbox.Flush() }
+func screenSetCursor(x, y int)                                  { termbox.SetCursor(x, y) }
+func screenHideCursor()                                         { termbox.HideCursor() }
+func screenPollEvent() termbox.Event                            { return termbox.PollEvent() }
+func screenInterrupt()                                          { termbox.Interrupt() }
+func screenSync() error                                         { return termbox.Sync() }
+
+func screenInit() error { return termbox.Init() }
+
+// This function sets how the input is read. Mouse events are reported when
+// mouse is set. When alt is set, escape sequences of alt keys are read as
+// single key events so that they can be used in key bindings. It is off by
+// default since a single escape key is never reported in this mode and it is
+// read as the alt modifier of the next key instead.
+func screenSetInput(mouse, alt bool) {
+	mode := termbox.InputEsc
+	if alt {
+		mode = termbox.InputAlt
+	}
+	if mouse {
+		mode |= termbox.InputMouse
+	}
+	termbox.SetInputMode(mode)
+}
+
+// This function writes the given escape sequences (e.g. images) directly to
+// the terminal at the given cell position. Cursor position is restored
+// afterwards.
+func screenRaw(x, y int, s string) {
+	fmt.Fprintf(os.Stdout, "\x1b7\x1b[%d;%dH%s\x1b8", y+1, x+1, s)
+}
@@ -6,11 +6,15 @@ import (
 	"log"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 )
 
 var (
 	gKeepFile bool
 	gFileList []string
+	gMarkList []string
+	gConnList = make(map[int]net.Conn)
 )
 
 func serve() {
@@ -37,24 +41,90 @@ func listen(l net.Listener) {
 		c, err := l.Accept()
 		if err != nil {
 			log.Printf("accepting connection: %s", err)
+			continue
 		}
-		defer c.Close()
-
-		s := bufio.NewScanner(c)
-
-		for s.Scan() {
-			switch s.Text() {
-			case "save":
-				saveFilesServer(s)
-				log.Printf("listen: save, list: %v, keep: %t", gFileList, gKeepFile)
-			case "load":
-				loadFilesServer(c)
-				log.Printf("listen: load, keep: %t", gKeepFile)
-			default:
-				log.Print("listen: unexpected command")
+
+		handleConn(c)
+	}
+}
+
+// This function splits the first word of a line from the rest. It is used to
+// read command words and client ids from the lines sent to the server.
+func splitWord(s string) (word, rest string) {
+	s = strings.TrimLeft(s, " \t")
+	if i := strings.IndexAny(s, " \t"); i != -1 {
+		return s[:i], strings.TrimLeft(s[i+1:], " \t")
+	}
+	return s, ""
+}
+
+func handleConn(c net.Conn) {
+	s := bufio.NewScanner(c)
+
+	for s.Scan() {
+		word, rest := splitWord(s.Text())
+		switch word {
+		case "save":
+			saveFilesServer(s)
+			log.Printf("listen: save, list: %v, keep: %t", gFileList, gKeepFile)
+		case "load":
+			loadFilesServer(c)
+			log.Printf("listen: load, keep: %t", gKeepFile)
+		case "save-marks":
+			saveMarksServer(s)
+			log.Printf("listen: save-marks, list: %v", gMarkList)
+
+			// clients load the marks again including the sender
+			for id := range gConnList {
+				sendServer(id, "sync")
+			}
+		case "load-marks":
+			loadMarksServer(c)
+			log.Print("listen: load-marks")
+		case "conn":
+			id, err := strconv.Atoi(rest)
+			if err != nil {
+				log.Printf("listen: conn: %s", err)
+				break
+			}
+			gConnList[id] = c
+			log.Printf("listen: conn, id: %d", id)
+
+			// connection is kept open to send commands to the client
+			return
+		case "send":
+			word, cmd := splitWord(rest)
+			id, err := strconv.Atoi(word)
+			if err != nil {
+				// no client id is given so send to all clients
+				for id := range gConnList {
+					sendServer(id, rest)
+				}
+				log.Printf("listen: send, all, cmd: %s", rest)
+				break
 			}
+			sendServer(id, cmd)
+			log.Printf("listen: send, id: %d, cmd: %s", id, cmd)
+		default:
+			log.Print("listen: unexpected command")
 		}
 	}
+
+	c.Close()
+}
+
+func sendServer(id int, cmd string) {
+	c, ok := gConnList[id]
+	if !ok {
+		log.Printf("send: no such client id: %d", id)
+		return
+	}
+
+	if _, err := fmt.Fprintln(c, cmd); err != nil {
+		log.Printf("send: %s", err)
+		c.Close()
+		delete(gConnList, id)
+	}
 }
 
 func saveFilesServer(s *bufio.Scanner) {
@@ -92,3 +162,22 @@ func loadFilesServer(c net.Conn) {
 
 	c.Close()
 }
+
+func saveMarksServer(s *bufio.Scanner) {
+	gMarkList = nil
+	for s.Scan() {
+		gMarkList = append(gMarkList, s.Text())
+	}
+
+	if s.Err() != nil {
+		log.Printf("scanning: %s", s.Err())
+	}
+}
+
+func loadMarksServer(c net.Conn) {
+	for _, m := range gMarkList {
+		fmt.Fprintln(c, m)
+	}
+
+	c.Close()
+}
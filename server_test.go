@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestShareMarks(t *testing.T) {
+	sock := gSocketPath
+	defer func() { gSocketPath = sock }()
+	gSocketPath = path.Join(t.TempDir(), "sock")
+
+	l, err := net.Listen("unix", gSocketPath)
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			handleConn(c)
+		}
+	}()
+
+	c, err := net.Dial("unix", gSocketPath)
+	if err != nil {
+		t.Fatalf("dialing: %s", err)
+	}
+	defer c.Close()
+	fmt.Fprintln(c, "conn 1")
+
+	tests := [][]string{
+		{"/foo", "/foo/bar baz"},
+		{"/qux"},
+		nil,
+	}
+
+	s := bufio.NewScanner(c)
+	for _, test := range tests {
+		if err := saveMarkList(test); err != nil {
+			t.Fatalf("saving marks: %s", err)
+		}
+
+		// clients are asked to load the marks after they are saved
+		if !s.Scan() || s.Text() != "sync" {
+			t.Errorf("at input '%v' expected 'sync' but got '%s'", test, s.Text())
+		}
+
+		got, err := loadMarkList()
+		if err != nil {
+			t.Fatalf("loading marks: %s", err)
+		}
+		if !reflect.DeepEqual(got, test) {
+			t.Errorf("at input '%v' expected '%v' but got '%v'", test, test, got)
+		}
+	}
+}
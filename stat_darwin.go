@@ -0,0 +1,14 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// This function returns the access and status change times of the given file
+// information.
+func statTimes(st *syscall.Stat_t) (atime, ctime time.Time, ok bool) {
+	atime = time.Unix(int64(st.Atimespec.Sec), int64(st.Atimespec.Nsec))
+	ctime = time.Unix(int64(st.Ctimespec.Sec), int64(st.Ctimespec.Nsec))
+	return atime, ctime, true
+}
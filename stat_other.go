@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// Access and status change times are only shown on systems with known layouts
+// of the stat structure.
+func statTimes(st *syscall.Stat_t) (atime, ctime time.Time, ok bool) {
+	return time.Time{}, time.Time{}, false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Tabs keeps the navigation state of each tab including its directories,
+// cursor positions and marks. The active tab is also kept in 'app.nav' so
+// that commands work on it as usual.
+type Tabs struct {
+	navs []*Nav
+	ind  int // index of the active tab
+}
+
+func newTabs(nav *Nav) *Tabs {
+	return &Tabs{navs: []*Nav{nav}}
+}
+
+func (tabs *Tabs) curr() *Nav {
+	return tabs.navs[tabs.ind]
+}
+
+// This function inserts the given tab after the active one and activates it.
+func (tabs *Tabs) add(nav *Nav) {
+	tabs.ind++
+	tabs.navs = append(tabs.navs[:tabs.ind], append([]*Nav{nav}, tabs.navs[tabs.ind:]...)...)
+}
+
+// This function closes the active tab and activates the next one or the
+// previous one when the last tab is closed. The only tab left can not be
+// closed.
+func (tabs *Tabs) close() error {
+	if len(tabs.navs) == 1 {
+		return errors.New("cannot close the last tab")
+	}
+
+	tabs.navs = append(tabs.navs[:tabs.ind], tabs.navs[tabs.ind+1:]...)
+
+	if tabs.ind == len(tabs.navs) {
+		tabs.ind--
+	}
+
+	return nil
+}
+
+// This function returns the path of the file to be shown in its directory in
+// another tab. Symlinks are resolved so that their targets are shown instead.
+func targetPath(p string) (string, error) {
+	f, err := os.Lstat(p)
+	if err != nil {
+		return "", err
+	}
+
+	if f.Mode()&os.ModeSymlink == 0 {
+		return p, nil
+	}
+
+	return filepath.EvalSymlinks(p)
+}
+
+// This function returns the tab after the active one or nil when there is a
+// single tab.
+func (tabs *Tabs) next() *Nav {
+	if len(tabs.navs) == 1 {
+		return nil
+	}
+	return tabs.navs[(tabs.ind+1)%len(tabs.navs)]
+}
+
+// This function activates the tab at the given offset from the active one
+// wrapping around at the ends.
+func (tabs *Tabs) move(off int) {
+	n := len(tabs.navs)
+	tabs.ind = ((tabs.ind+off)%n + n) % n
+}
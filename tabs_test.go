@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestTabs(t *testing.T) {
+	navs := []*Nav{{height: 1}, {height: 2}, {height: 3}}
+
+	tabs := newTabs(navs[0])
+	tabs.add(navs[2])
+	tabs.move(-1)
+	tabs.add(navs[1])
+
+	for i, nav := range navs {
+		if tabs.navs[i] != nav {
+			t.Errorf("at tab %d expected height '%d' but got '%d'", i, nav.height, tabs.navs[i].height)
+		}
+	}
+
+	tests := []struct {
+		op  string
+		exp int
+	}{
+		{"next", 2},
+		{"next", 0},
+		{"prev", 2},
+		{"close", 1},
+		{"prev", 0},
+		{"close", 0},
+	}
+
+	for _, test := range tests {
+		switch test.op {
+		case "next":
+			tabs.move(1)
+		case "prev":
+			tabs.move(-1)
+		case "close":
+			if err := tabs.close(); err != nil {
+				t.Errorf("at op '%s' expected no error but got '%s'", test.op, err)
+			}
+		}
+		if ind := tabs.ind; ind != test.exp {
+			t.Errorf("at op '%s' expected '%d' but got '%d'", test.op, test.exp, ind)
+		}
+	}
+
+	if tabs.curr() != navs[1] {
+		t.Errorf("at last tab expected height '%d' but got '%d'", navs[1].height, tabs.curr().height)
+	}
+
+	if err := tabs.close(); err == nil {
+		t.Errorf("at closing the last tab expected an error but got none")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// TreeFile is an entry of a subdirectory expanded in tree mode. Name is the
+// path relative to the directory shown in the pane so that paths of entries
+// are joined as usual.
+type TreeFile struct {
+	os.FileInfo
+	name string
+}
+
+func (f TreeFile) Name() string { return f.name }
+
+// This function returns the top level entries of the given entries leaving
+// out the entries of expanded subdirectories.
+func treeRoots(fi []os.FileInfo) []os.FileInfo {
+	var roots []os.FileInfo
+	for _, f := range fi {
+		if _, ok := f.(TreeFile); !ok {
+			roots = append(roots, f)
+		}
+	}
+	return roots
+}
+
+// This function returns the given top level entries with the entries of
+// expanded subdirectories inserted after them. Subdirectories are only read
+// when they are expanded and entries deeper than 'treedepth' are not shown.
+// In flat view, files of all subdirectories up to 'flattendepth' are listed
+// instead without the directories themselves. In disk usage view, entries are
+// only sorted by their sizes.
+func (dir *Dir) expand(fi []os.FileInfo) []os.FileInfo {
+	switch {
+	case dir.du:
+		return dir.usageSort(fi)
+	case dir.flat:
+		var files []os.FileInfo
+		for _, f := range expandTree(dir.path, "", fi, 1, func(name string, depth int) bool {
+			return depth <= gOpts.flattendepth
+		}) {
+			if !f.IsDir() {
+				files = append(files, f)
+			}
+		}
+		// files are sorted as a whole so that sorting by size or time
+		// works across subdirectories
+		return organizeFiles(files)
+	case dir.tree != nil:
+		return expandTree(dir.path, "", fi, 1, func(name string, depth int) bool {
+			return dir.tree[name] && depth <= gOpts.treedepth
+		})
+	}
+	return fi
+}
+
+// This function inserts the entries of subdirectories after them when the
+// given function returns true for the name and the depth of their entries.
+func expandTree(root, rel string, fi []os.FileInfo, depth int, expanded func(name string, depth int) bool) []os.FileInfo {
+	var res []os.FileInfo
+
+	for _, f := range fi {
+		name := path.Join(rel, f.Name())
+		if rel != "" {
+			f = TreeFile{f, name}
+		}
+		res = append(res, f)
+
+		if !f.IsDir() || !expanded(name, depth) {
+			continue
+		}
+
+		sub, err := readEntries(path.Join(root, name))
+		if err != nil {
+			log.Printf("reading directory: %s", err)
+			continue
+		}
+
+		res = append(res, expandTree(root, name, organizeFiles(sub), depth+1, expanded)...)
+	}
+
+	return res
+}
+
+// This function expands the entries again keeping the cursor on the given
+// entry. It is used when subdirectories are expanded or collapsed.
+func (dir *Dir) retree(height int, name string) {
+	dir.all = dir.expand(treeRoots(dir.all))
+	dir.fi = filterFiles(dir.all, dir.filter, dir.ftype)
+	dir.load(dir.ind, dir.pos, height, name)
+}
+
+// This function returns the indentation and the name of the given entry as
+// drawn in tree mode. Directories are marked with '+' when they are collapsed
+// and '-' when they are expanded.
+func (dir *Dir) treeName(f os.FileInfo) (string, string) {
+	name := f.Name()
+
+	indent := strings.Repeat("  ", strings.Count(name, "/"))
+
+	switch {
+	case !f.IsDir():
+		indent += "  "
+	case dir.tree[name]:
+		indent += "- "
+	default:
+		indent += "+ "
+	}
+
+	return indent, path.Base(name)
+}
+
+// This function turns tree mode on or off for the current directory. When it
+// is turned off, the cursor is moved to the top level entry containing the
+// current entry.
+func (nav *Nav) toggleTree() {
+	dir := nav.currDir()
+
+	var name string
+	if len(dir.fi) != 0 {
+		name = dir.fi[dir.ind].Name()
+	}
+
+	switch {
+	case dir.flat || dir.du:
+		// entries are read again since directories are left out or sorted
+		// by their sizes
+		dir.flat = false
+		dir.du = false
+		dir.tree = make(map[string]bool)
+		dir.renew(nav.height)
+		name = strings.SplitN(name, "/", 2)[0]
+	case dir.tree == nil:
+		dir.tree = make(map[string]bool)
+	default:
+		dir.tree = nil
+		name = strings.SplitN(name, "/", 2)[0]
+	}
+
+	dir.retree(nav.height, name)
+}
+
+// This function expands the current directory entry in tree mode. Tree mode
+// is turned on if it is off.
+func (nav *Nav) treeExpand() error {
+	dir := nav.currDir()
+
+	if len(dir.fi) == 0 {
+		return nil
+	}
+
+	if dir.flat {
+		return errors.New("not available in flat view")
+	}
+
+	if dir.du {
+		return errors.New("not available in disk usage view")
+	}
+
+	f := dir.fi[dir.ind]
+	if !f.IsDir() {
+		return fmt.Errorf("not a directory: %s", f.Name())
+	}
+
+	if depth := strings.Count(f.Name(), "/") + 1; depth > gOpts.treedepth {
+		return fmt.Errorf("tree depth limit reached: %d", gOpts.treedepth)
+	}
+
+	if dir.tree == nil {
+		dir.tree = make(map[string]bool)
+	}
+	dir.tree[f.Name()] = true
+
+	dir.retree(nav.height, f.Name())
+
+	return nil
+}
+
+// This function collapses the current directory entry in tree mode or the
+// directory containing the current entry if it is not expanded. The cursor is
+// moved to the collapsed directory.
+func (nav *Nav) treeCollapse() {
+	dir := nav.currDir()
+
+	if dir.tree == nil || len(dir.fi) == 0 {
+		return
+	}
+
+	name := dir.fi[dir.ind].Name()
+	if !dir.tree[name] {
+		if name = path.Dir(name); name == "." {
+			return
+		}
+	}
+
+	// expanded subdirectories are collapsed along with it
+	for p := range dir.tree {
+		if p == name || strings.HasPrefix(p, name+"/") {
+			delete(dir.tree, p)
+		}
+	}
+
+	dir.retree(nav.height, name)
+}
+
+// This function turns flat view on or off for the current directory. Flat
+// view lists the files of subdirectories along with the files of the
+// directory so that files in different subdirectories can be marked at once.
+func (nav *Nav) toggleFlatten() {
+	dir := nav.currDir()
+
+	var name string
+	if len(dir.fi) != 0 {
+		name = dir.fi[dir.ind].Name()
+	}
+
+	dir.flat = !dir.flat
+	dir.tree = nil
+	dir.du = false
+
+	if !dir.flat {
+		name = strings.SplitN(name, "/", 2)[0]
+	}
+
+	dir.renew(nav.height)
+	dir.load(dir.ind, dir.pos, nav.height, name)
+}
@@ -4,17 +4,44 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/nsf/termbox-go"
 )
 
+var gColorNames = map[string]termbox.Attribute{
+	"default": termbox.ColorDefault,
+	"black":   termbox.ColorBlack,
+	"red":     termbox.ColorRed,
+	"green":   termbox.ColorGreen,
+	"yellow":  termbox.ColorYellow,
+	"blue":    termbox.ColorBlue,
+	"magenta": termbox.ColorMagenta,
+	"cyan":    termbox.ColorCyan,
+	"white":   termbox.ColorWhite,
+}
+
+func colorName(c termbox.Attribute) string {
+	for name, attr := range gColorNames {
+		if attr == c {
+			return name
+		}
+	}
+	return "unknown"
+}
+
 type Win struct {
 	w int
 	h int
@@ -33,6 +60,8 @@ func (win *Win) renew(w, h, x, y int) {
 	win.y = y
 }
 
+// This function prints the given string. Tabs are expanded with spaces up to
+// the next multiple of 'tabstop' counted from the given column.
 func (win *Win) print(x, y int, fg, bg termbox.Attribute, s string) {
 	off := x
 	for _, c := range s {
@@ -40,14 +69,152 @@ func (win *Win) print(x, y int, fg, bg termbox.Attribute, s string) {
 			break
 		}
 
-		termbox.SetCell(win.x+x, win.y+y, c, fg, bg)
+		if c == '\t' {
+			for n := gOpts.tabstop - (x-off)%gOpts.tabstop; n > 0 && x < win.w; n-- {
+				screenSetCell(win.x+x, win.y+y, ' ', fg, bg)
+				x++
+			}
+			continue
+		}
+
+		screenSetCell(win.x+x, win.y+y, c, fg, bg)
+		x++
+	}
+}
+
+// This function replaces the control characters in the given string except
+// tabs with their caret notation (e.g. '^M' for carriage returns) so that they
+// are shown instead of being sent to the terminal.
+func escapeControls(s string) string {
+	if strings.IndexFunc(s, func(r rune) bool { return r != '\t' && unicode.IsControl(r) }) == -1 {
+		return s
+	}
+
+	var buf []rune
+	for _, r := range s {
+		switch {
+		case r == '\t' || !unicode.IsControl(r):
+			buf = append(buf, r)
+		case r < 0x20:
+			buf = append(buf, '^', r+'@')
+		case r == 0x7f:
+			buf = append(buf, '^', '?')
+		default:
+			buf = append(buf, '?')
+		}
+	}
+	return string(buf)
+}
+
+// This function prints the given line interpreting the color escape sequences
+// (e.g. '\033[1;32m') in it. Other escape sequences including operating
+// system commands (e.g. '\033]0;title\a') are skipped and remaining control
+// characters are escaped. It is used to show colored outputs of previewers
+// (e.g. syntax highlighters).
+func (win *Win) printAnsi(x, y int, fg, bg termbox.Attribute, s string) (termbox.Attribute, termbox.Attribute) {
+	st := Style{fg, bg}
+
+	// tab stops are counted from the beginning of the line
+	beg := x
+
+	// colors are dropped with 'mono' theme keeping the other attributes
+	shown := func() Style {
+		if gOpts.theme == "mono" {
+			return st.attrsOnly()
+		}
+		return st
+	}
+
+	for {
+		i := strings.IndexByte(s, '\033')
+		if i == -1 {
+			break
+		}
+
+		t := expandTabs(escapeControls(s[:i]), x-beg)
+		ps := shown()
+		win.print(x, y, ps.fg, ps.bg, t)
+		x += printWidth(x, t)
 
+		s = s[i+1:]
+
+		switch {
+		case strings.HasPrefix(s, "["):
+			j := strings.IndexFunc(s[1:], func(r rune) bool { return r >= 0x40 && r <= 0x7e })
+			if j == -1 {
+				return st.fg, st.bg
+			}
+
+			if s[1+j] == 'm' {
+				if next, err := applySGR(st, s[1:1+j]); err == nil {
+					st = next
+				}
+			}
+
+			s = s[1+j+1:]
+		case strings.HasPrefix(s, "]"):
+			// terminated with a bell or with '\033\\'
+			j := strings.IndexAny(s, "\a\033")
+			if j == -1 {
+				return st.fg, st.bg
+			}
+
+			if s[j] == '\033' && j+1 < len(s) {
+				j++
+			}
+
+			s = s[j+1:]
+		default:
+			// intermediate bytes (e.g. '\033(B') followed by a final byte
+			j := strings.IndexFunc(s, func(r rune) bool { return r < 0x20 || r > 0x2f })
+			if j == -1 {
+				return st.fg, st.bg
+			}
+			_, n := utf8.DecodeRuneInString(s[j:])
+			s = s[j+n:]
+		}
+	}
+
+	ps := shown()
+	win.print(x, y, ps.fg, ps.bg, expandTabs(escapeControls(s), x-beg))
+
+	return st.fg, st.bg
+}
+
+// This function replaces the tabs in the given string with spaces up to the
+// next multiple of 'tabstop' for a string starting at the given column.
+func expandTabs(s string, col int) string {
+	if !strings.ContainsRune(s, '\t') {
+		return s
+	}
+
+	var buf []rune
+	for _, r := range s {
+		if r != '\t' {
+			buf = append(buf, r)
+			col++
+			continue
+		}
+		for n := gOpts.tabstop - col%gOpts.tabstop; n > 0; n-- {
+			buf = append(buf, ' ')
+			col++
+		}
+	}
+	return string(buf)
+}
+
+// This function returns the number of columns the given string takes when it
+// is printed at the given column with tabs expanded.
+func printWidth(x int, s string) int {
+	off := x
+	for _, c := range s {
 		if c == '\t' {
 			x += gOpts.tabstop - (x-off)%gOpts.tabstop
 		} else {
 			x++
 		}
 	}
+	return x - off
 }
 
 func (win *Win) printf(x, y int, fg, bg termbox.Attribute, format string, a ...interface{}) {
@@ -58,6 +225,28 @@ func (win *Win) printl(x, y int, fg, bg termbox.Attribute, s string) {
 	win.printf(x, y, fg, bg, "%s%*s", s, win.w-len(s), "")
 }
 
+// This function replaces the end of the given line with the given info
+// separated with a space. The line is left as is when it is too short.
+func appendInfo(s []rune, info string) []rune {
+	r := []rune(info)
+
+	n := len(s) - len(r) - 1
+	if n < 1 {
+		return s
+	}
+
+	s = s[:n]
+	s = append(s, ' ')
+	return append(s, r...)
+}
+
+// This function checks whether info columns of the given length fit in the
+// pane while leaving at least 'namewidth' columns for file names. Otherwise
+// info columns are hidden instead of truncating names.
+func (win *Win) hasInfo(length int) bool {
+	return win.w-3-length >= gOpts.namewidth
+}
+
 func (win *Win) printd(dir *Dir, marks map[string]bool) {
 	if win.w < 3 {
 		return
@@ -65,6 +254,23 @@ func (win *Win) printd(dir *Dir, marks map[string]bool) {
 
 	fg, bg := termbox.ColorDefault, termbox.ColorDefault
 
+	// indicate filtered entries at the top right corner
+	if dir.filter != "" || dir.ftype != "" {
+		defer win.print(win.w-1, 0, termbox.AttrReverse|getColors().ui("ind").fg, bg, "F")
+	}
+
+	if dir.loading {
+		fg = termbox.AttrBold
+		win.print(0, 0, fg, bg, "loading...")
+		return
+	}
+
+	if dir.noPerm {
+		fg = termbox.AttrBold
+		win.print(0, 0, fg, bg, "permission denied")
+		return
+	}
+
 	if len(dir.fi) == 0 {
 		fg = termbox.AttrBold
 		win.print(0, 0, fg, bg, "empty")
@@ -76,67 +282,90 @@ func (win *Win) printd(dir *Dir, marks map[string]bool) {
 	beg := max(dir.ind-dir.pos, 0)
 	end := min(beg+win.h, maxind+1)
 
-	for i, f := range dir.fi[beg:end] {
-		switch {
-		case f.Mode().IsRegular():
-			if f.Mode()&0111 != 0 {
-				fg = termbox.AttrBold | termbox.ColorGreen
-			} else {
-				fg = termbox.ColorDefault
+	var cols []string
+	if len(gOpts.info) != 0 {
+		var infos [][]string
+		for _, f := range dir.fi[beg:end] {
+			var info []string
+			for _, t := range gOpts.info {
+				info = append(info, fileInfo(f, path.Join(dir.path, f.Name()), t))
 			}
-		case f.Mode().IsDir():
-			fg = termbox.AttrBold | termbox.ColorBlue
-		case f.Mode()&os.ModeSymlink != 0:
-			fg = termbox.ColorCyan
-		case f.Mode()&os.ModeNamedPipe != 0:
-			fg = termbox.ColorRed
-		case f.Mode()&os.ModeSocket != 0:
-			fg = termbox.ColorYellow
-		case f.Mode()&os.ModeDevice != 0:
-			fg = termbox.ColorWhite
+			infos = append(infos, info)
 		}
+		cols = infoColumns(infos, win.hasInfo)
+	}
+
+	var largest int64
+	if dir.du {
+		largest = dir.usageMax()
+	}
+
+	vbeg, vend, visual := dir.visualRange()
+
+	for i, f := range dir.fi[beg:end] {
+		st := getColors().get(f)
+		fg, bg = st.fg, st.bg
 
 		path := path.Join(dir.path, f.Name())
 
-		if marks[path] {
-			win.print(0, i, fg, termbox.ColorMagenta, " ")
+		if marks[path] && gOpts.markmode == "margin" {
+			if st, ok := getColors()["mark"]; ok {
+				win.print(0, i, st.fg, st.bg, gOpts.markchar)
+			} else if gOpts.markchar == " " {
+				win.print(0, i, fg, gOpts.markcolor, " ")
+			} else {
+				win.print(0, i, gOpts.markcolor, bg, gOpts.markchar)
+			}
+		}
+
+		// entries in the visual range are drawn apart from marked entries
+		if ind := beg + i; visual && ind >= vbeg && ind <= vend {
+			vs := getColors().ui("visual")
+			fg |= vs.fg
+			if vs.bg != termbox.ColorDefault {
+				bg = vs.bg
+			}
 		}
 
 		if i == dir.pos {
 			fg = fg | termbox.AttrReverse
 		}
 
-		var s []byte
+		var s []rune
 
 		s = append(s, ' ')
 
-		s = append(s, f.Name()...)
+		if marks[path] && gOpts.markmode == "prefix" {
+			s = append(s, []rune(gOpts.markchar)...)
+		}
+
+		if dir.du {
+			s = append(s, []rune(dir.usageInfo(f, largest))...)
+		}
+
+		name := f.Name()
+		if dir.tree != nil {
+			var indent string
+			indent, name = dir.treeName(f)
+			s = append(s, []rune(indent)...)
+		}
+
+		// icons are read out as noise by screen readers
+		if gOpts.icons && !gOpts.screenreader {
+			s = append(s, []rune(getIcons().get(f))...)
+			s = append(s, ' ')
+		}
+
+		s = append(s, []rune(escapeName(name))...)
 
 		if len(s) > win.w-2 {
-			s = s[:win.w-2]
+			s = s[:max(win.w-2, 0)]
 		} else {
-			s = append(s, make([]byte, win.w-2-len(s))...)
+			s = append(s, make([]rune, win.w-2-len(s))...)
 		}
 
-		switch gOpts.showinfo {
-		case "none":
-			break
-		case "size":
-			if win.w > 8 {
-				h := humanize(f.Size())
-				s = append(s[:win.w-3-len(h)])
-				s = append(s, ' ')
-				s = append(s, h...)
-			}
-		case "time":
-			if win.w > 24 {
-				t := f.ModTime().Format("Jan _2 15:04")
-				s = append(s[:win.w-3-len(t)])
-				s = append(s, ' ')
-				s = append(s, t...)
-			}
-		default:
-			log.Printf("unknown showinfo type: %s", gOpts.showinfo)
+		if cols != nil && cols[i] != "" {
+			s = appendInfo(s, cols[i])
 		}
 
 		// TODO: add a trailing '~' to the name if cut
@@ -145,14 +374,15 @@ func (win *Win) printd(dir *Dir, marks map[string]bool) {
 	}
 }
 
-func (win *Win) printr(reg *os.File) error {
+// This function prints the given file starting from the line given as offset.
+func (win *Win) printr(reg io.ReadSeeker, off int) error {
 	fg, bg := termbox.ColorDefault, termbox.ColorDefault
 
 	buf := bufio.NewScanner(reg)
 
 	for i := 0; i < win.h && buf.Scan(); i++ {
 		for _, r := range buf.Text() {
-			if unicode.IsSpace(r) {
+			if unicode.IsSpace(r) || r == '\033' {
 				continue
 			}
 			if !unicode.IsPrint(r) {
@@ -171,8 +401,11 @@ func (win *Win) printr(reg *os.File) error {
 
 	buf = bufio.NewScanner(reg)
 
+	for i := 0; i < off && buf.Scan(); i++ {
+	}
+
 	for i := 0; i < win.h && buf.Scan(); i++ {
-		win.print(2, i, fg, bg, buf.Text())
+		fg, bg = win.printAnsi(2, i, fg, bg, buf.Text())
 	}
 
 	if buf.Err() != nil {
@@ -183,35 +416,98 @@ func (win *Win) printr(reg *os.File) error {
 }
 
 type UI struct {
-	wins    []*Win
-	pwdwin  *Win
-	msgwin  *Win
-	menuwin *Win
-	message string
+	wins     []*Win
+	pwdwin   *Win
+	msgwin   *Win
+	menuwin  *Win
+	message  string
+	prevPath string // file last shown with a previewer
+	indLen   int    // length of the indicators last drawn in the msgwin
+	pending  string // count and keys typed so far for a binding
+	timedMsg string // informational message to be cleared after a timeout
+	msgTime  time.Time
+	flashed  time.Time
+	prevOff  int    // first line shown in the preview pane scrolled with mouse
+	offPath  string // file the preview offset belongs to
+	clickX   int    // position of the last mouse click to detect double clicks
+	clickY   int
+	clicked  time.Time
+	tabs     *Tabs  // tabs shown in the header when there are more than one
+	image    *Image // image to be drawn in the preview pane
+	seq      string // escape sequence drawing the image if any
+	shown    *Image // image drawn on the screen
+	failed   Image  // last image failed to draw previewed as text instead
+	annDir   string // directory, file and message last announced
+	annFile  string
+	annMsg   string
 }
 
-func getWidths(wtot int) []int {
-	rsum := 0
-	for _, rat := range gOpts.ratios {
-		rsum += rat
-	}
+// Terminal widths below which panes are dropped when 'autopanes' is set.
+const (
+	gTwoPaneWidth = 80
+	gOnePaneWidth = 50
+)
+
+// This function returns the ratios used for the given terminal width. When
+// 'autopanes' option is set, leftmost panes are dropped on narrow terminals
+// so that only the current and preview panes or only the current pane is
+// left.
+func getRatios(wtot int) []int {
+	rats := gOpts.ratios
 
-	wlen := len(gOpts.ratios)
-	widths := make([]int, wlen)
+	if !gOpts.autopanes {
+		return rats
+	}
 
-	wsum := 0
-	for i := 0; i < wlen-1; i++ {
-		widths[i] = gOpts.ratios[i] * (wtot / rsum)
-		wsum += widths[i]
+	switch {
+	case wtot < gOnePaneWidth:
+		return []int{1}
+	case wtot < gTwoPaneWidth && len(rats) > 2:
+		return rats[len(rats)-2:]
 	}
-	widths[wlen-1] = wtot - wsum
 
-	return widths
+	return rats
 }
 
-func newUI() *UI {
-	wtot, htot := termbox.Size()
+// Panes narrower than this are dropped starting from the leftmost pane.
+const gMinPaneWidth = 5
+
+func getWidths(wtot int) []int {
+	rats := getRatios(wtot)
+
+	for {
+		rsum := 0
+		for _, rat := range rats {
+			rsum += rat
+		}
+
+		wlen := len(rats)
+		widths := make([]int, wlen)
 
+		wsum := 0
+		for i := 0; i < wlen-1; i++ {
+			widths[i] = rats[i] * wtot / rsum
+			wsum += widths[i]
+		}
+		widths[wlen-1] = wtot - wsum
+
+		narrow := false
+		for _, w := range widths {
+			if w < gMinPaneWidth {
+				narrow = true
+				break
+			}
+		}
+
+		if !narrow || wlen == 1 {
+			return widths
+		}
+
+		rats = rats[1:]
+	}
+}
+
+func getWins(wtot, htot int) []*Win {
 	var wins []*Win
 
 	widths := getWidths(wtot)
@@ -223,81 +519,459 @@ func newUI() *UI {
 		wacc += widths[i]
 	}
 
+	return wins
+}
+
+func newUI() *UI {
+	wtot, htot := screenSize()
+
 	return &UI{
-		wins:    wins,
+		wins:    getWins(wtot, htot),
 		pwdwin:  newWin(wtot, 1, 0, 0),
 		msgwin:  newWin(wtot, 1, 0, htot-1),
 		menuwin: newWin(wtot, 1, 0, htot-2),
 	}
 }
 
+// This function recomputes the panes for the current terminal size. The
+// number of panes may change with 'autopanes' and 'ratios' options so panes
+// are recreated.
 func (ui *UI) renew() {
-	termbox.Flush()
+	screenFlush()
 
-	wtot, htot := termbox.Size()
+	wtot, htot := screenSize()
 
-	widths := getWidths(wtot)
-
-	wacc := 0
-	wlen := len(widths)
-	for i := 0; i < wlen; i++ {
-		ui.wins[i].renew(widths[i], htot-2, wacc, 1)
-		wacc += widths[i]
-	}
+	ui.wins = getWins(wtot, htot)
 
+	ui.pwdwin.renew(wtot, 1, 0, 0)
 	ui.msgwin.renew(wtot, 1, 0, htot-1)
+	ui.menuwin.renew(wtot, 1, 0, htot-2)
 }
 
+// This function clears the message so that the status of the current file is
+// shown in the message line instead. It is called after moving the cursor.
 func (ui *UI) echoFileInfo(nav *Nav) {
+	ui.message = ""
+}
+
+// This function returns the status of the current file shown at the left of
+// the message line when there is no message (e.g. '-rw-r--r-- 1.2K Mon Jan 2
+// 15:04:05 2006').
+func fileStatus(nav *Nav) string {
 	dir := nav.currDir()
 
-	if len(dir.fi) == 0 {
-		return
+	if dir.loading || len(dir.fi) == 0 {
+		return ""
 	}
 
 	curr := nav.currFile()
 
-	ui.message = fmt.Sprintf("%v %v %v", curr.Mode(), humanize(curr.Size()), curr.ModTime().Format(time.ANSIC))
+	return fmt.Sprintf("%v %v %v", curr.Mode(), humanize(curr.Size()), curr.ModTime().Format(gOpts.timefmt))
+}
+
+// This function shows an informational message which is cleared after the
+// number of seconds given in 'msgtimeout' option. Errors are assigned to the
+// message directly instead so that they stay until the next key press
+// replaces them.
+func (ui *UI) echo(msg string) {
+	ui.message = msg
+
+	if gOpts.msgtimeout <= 0 {
+		return
+	}
+
+	ui.timedMsg = msg
+	ui.msgTime = time.Now()
+
+	time.AfterFunc(time.Duration(gOpts.msgtimeout)*time.Second, screenInterrupt)
+}
+
+// This function clears the informational message if its time is up. It
+// returns true when the message is cleared.
+func (ui *UI) expireMsg() bool {
+	if ui.timedMsg == "" || ui.message != ui.timedMsg {
+		return false
+	}
+
+	if time.Since(ui.msgTime) < time.Duration(gOpts.msgtimeout)*time.Second {
+		return false
+	}
+
+	ui.message = ""
+	ui.timedMsg = ""
+
+	return true
+}
+
+// Duration of the reverse video for visual bell.
+const gFlashTime = 100 * time.Millisecond
+
+// This function rings the bell given in 'bell' option. Audible bell writes the
+// bell character to the terminal and visual bell flashes the screen by
+// switching the terminal to reverse video for a moment. Main loop is
+// interrupted afterwards to switch it back in 'expireFlash'.
+func (ui *UI) bell() {
+	switch gOpts.bell {
+	case "audible":
+		os.Stdout.WriteString("\a")
+	case "visual":
+		screenRaw(0, 0, "\033[?5h")
+		ui.flashed = time.Now()
+		time.AfterFunc(gFlashTime, screenInterrupt)
+	}
+}
+
+// This function switches the terminal back from reverse video when the time
+// of visual bell is up. It returns true when the flash is cleared.
+func (ui *UI) expireFlash() bool {
+	if ui.flashed.IsZero() || time.Since(ui.flashed) < gFlashTime {
+		return false
+	}
+
+	screenRaw(0, 0, "\033[?5l")
+	ui.flashed = time.Time{}
+
+	return true
 }
 
 func (ui *UI) clearMsg() {
 	fg, bg := termbox.ColorDefault, termbox.ColorDefault
 	win := ui.msgwin
 	win.printl(0, 0, fg, bg, "")
-	termbox.SetCursor(win.x, win.y)
-	termbox.Flush()
+	screenSetCursor(win.x, win.y)
+	screenFlush()
 }
 
-func (ui *UI) draw(nav *Nav) {
-	fg, bg := termbox.ColorDefault, termbox.ColorDefault
+// This function returns the indicator of view settings shown at the right of
+// the message line (e.g. '[time↓][h]' when sorted by time in reverse with
+// hidden files). Directories listed among files are shown as '[nodirfirst]'.
+func viewIndicator() string {
+	ind := "[" + gOpts.sortby
+	if gOpts.reverse {
+		ind += "↓"
+	}
+	ind += "]"
+	if !gOpts.dirfirst {
+		ind += "[nodirfirst]"
+	}
+	if gOpts.hidden {
+		ind += "[h]"
+	}
+	return ind
+}
 
-	termbox.Clear(fg, bg)
-	defer termbox.Flush()
+// Segments of the ruler which can be given in 'ruler' option. Segments can
+// also be environment variables given as '%{NAME}'.
+var gRulerSegments = []string{"mode", "acc", "progress", "selection", "filter", "ind", "position"}
 
+func isRulerSegment(s string) bool {
+	if strings.HasPrefix(s, "%{") && strings.HasSuffix(s, "}") && len(s) > 3 {
+		return true
+	}
+	for _, seg := range gRulerSegments {
+		if s == seg {
+			return true
+		}
+	}
+	return false
+}
+
+// This function returns the ruler shown at the right of the message line with
+// the segments in 'ruler' option (e.g. '[normal][1 job 45%][3 marked]
+// [filter][natural][h][4/12]'). Empty segments are left out.
+func (ui *UI) ruler(nav *Nav) string {
 	dir := nav.currDir()
 
-	path := strings.Replace(dir.path, envHome, "~", -1)
+	var ind string
+	for _, seg := range gOpts.ruler {
+		switch seg {
+		case "mode":
+			if dir.visual != "" {
+				ind += "[visual]"
+			} else {
+				ind += "[normal]"
+			}
+		case "acc":
+			ind += ui.pending
+		case "progress":
+			ind += jobIndicator()
+		case "selection":
+			if n := len(nav.marks); n != 0 {
+				ind += fmt.Sprintf("[%d marked]", n)
+			}
+		case "filter":
+			if dir.filter != "" || dir.ftype != "" {
+				ind += "[filter]"
+			}
+		case "ind":
+			ind += viewIndicator()
+		case "position":
+			if !dir.loading && len(dir.fi) != 0 {
+				ind += fmt.Sprintf("[%d/%d]", dir.ind+1, len(dir.fi))
+			}
+		default:
+			ind += os.Getenv(seg[2 : len(seg)-1])
+		}
+	}
+
+	return ind
+}
+
+// This function draws the indicators at the right of the message line. It is
+// also called on its own to update the progress of jobs without redrawing the
+// whole screen. Previous indicators are cleared since they may be longer than
+// the current ones.
+func (ui *UI) drawIndicators(nav *Nav) {
+	fg, bg := termbox.ColorDefault, termbox.ColorDefault
+
+	ind := ui.ruler(nav)
+
+	// indicators may contain multibyte characters (e.g. '↓' for reverse)
+	l := utf8.RuneCountInString(ind)
+	n := max(l, ui.indLen)
+	ui.msgwin.print(ui.msgwin.w-n, 0, fg, bg, strings.Repeat(" ", n-l)+ind)
+	ui.indLen = l
+}
+
+// This function returns the path shown in the pwdwin. When the path contains
+// symlinks, the resolved physical path is shown instead or in addition to the
+// logical path depending on the 'pwdmode' option.
+func pwdPath(wd string) string {
+	home := func(s string) string { return strings.Replace(s, envHome, "~", -1) }
+
+	if gOpts.pwdmode == "logical" {
+		return home(wd)
+	}
+
+	phys, err := filepath.EvalSymlinks(wd)
+	if err != nil {
+		log.Printf("resolving path: %s", err)
+		return home(wd)
+	}
+
+	if phys == wd {
+		return home(wd)
+	}
+
+	if gOpts.pwdmode == "physical" {
+		return home(phys)
+	}
+
+	return fmt.Sprintf("%s -> %s", home(wd), home(phys))
+}
+
+// This function runs the given previewer command in the shell and returns its
+// output. The file path, width and height of the preview pane are passed as
+// arguments to the command.
+func runPreviewer(s, path string, w, h int) ([]byte, error) {
+	cmd := exec.Command(envShell, "-c", s, "--", path, strconv.Itoa(w), strconv.Itoa(h))
+	return cmd.Output()
+}
+
+// This function runs the command given in 'cleaner' option when the given file
+// was last shown by a previewer and another file is previewed afterwards. It
+// is used to clear images drawn by previewers (e.g. kitty icat or ueberzug)
+// which are not cleared with the rest of the screen. The previewed file and
+// the dimensions and position of the preview pane are passed as arguments.
+// Cleaner is run in the background so that redraws are not blocked.
+func (ui *UI) clean(prev string) {
+	if prev == "" || prev == ui.prevPath {
+		return
+	}
+
+	win := ui.wins[len(ui.wins)-1]
+	go runCleaner(gOpts.cleaner, prev, win.x, win.y, win.w, win.h)
+}
+
+func runCleaner(cleaner, path string, x, y, w, h int) {
+	if cleaner == "" {
+		return
+	}
+
+	cmd := exec.Command(cleaner, path,
+		strconv.Itoa(w), strconv.Itoa(h), strconv.Itoa(x), strconv.Itoa(y))
+
+	if err := cmd.Run(); err != nil {
+		log.Printf("running cleaner: %s", err)
+	}
+}
+
+// This function erases the image drawn on the screen unless the same image
+// is drawn again. This way images are not drawn again on each redraw and are
+// erased when the selection changes. Cleaner is also run for erased images
+// as it is run for previewers.
+func (ui *UI) hideImage() {
+	if ui.shown == nil || (ui.image != nil && *ui.image == *ui.shown) {
+		return
+	}
+
+	if err := eraseImage(ui.shown); err != nil {
+		log.Printf("erasing image: %s", err)
+	}
+
+	img := ui.shown
+	runCleaner(gOpts.cleaner, img.path, img.x, img.y, img.w, img.h)
+
+	ui.shown = nil
+}
+
+// This function draws the image of the preview pane. Images failed to draw
+// are previewed as text instead so the preview pane is redrawn afterwards.
+func (ui *UI) showImage() {
+	if ui.image == nil || ui.shown != nil {
+		return
+	}
+
+	if err := drawImage(ui.image, ui.seq); err != nil {
+		msg := fmt.Sprintf("drawing image: %s", err)
+		ui.message = msg
+		log.Print(msg)
+
+		ui.failed = *ui.image
+		select {
+		case gPreviewChan <- ui.image.path:
+		default:
+		}
+		return
+	}
 
-	ui.pwdwin.printf(0, 0, termbox.AttrBold|termbox.ColorGreen, bg, "%s@%s", envUser, envHost)
-	ui.pwdwin.printf(len(envUser)+len(envHost)+1, 0, fg, bg, ":")
-	ui.pwdwin.printf(len(envUser)+len(envHost)+2, 0, termbox.AttrBold|termbox.ColorBlue, bg, "%s", path)
+	ui.shown = ui.image
+}
+
+// This function returns whether the last pane is used for previews and how
+// directories are laid out in the panes. Directory 'doff+i' is drawn in pane
+// 'woff+i' for the first 'length' panes starting from 'woff'.
+func (ui *UI) panes(nav *Nav) (preview bool, woff, doff, length int) {
+	// a single pane is used for the current directory
+	preview = gOpts.preview && len(ui.wins) > 1
 
-	length := min(len(ui.wins), len(nav.dirs))
-	woff := len(ui.wins) - length
+	length = min(len(ui.wins), len(nav.dirs))
+	woff = len(ui.wins) - length
 
-	if gOpts.preview {
+	if preview {
 		length = min(len(ui.wins)-1, len(nav.dirs))
 		woff = len(ui.wins) - 1 - length
 	}
 
-	doff := len(nav.dirs) - length
+	doff = len(nav.dirs) - length
+
+	return preview, woff, doff, length
+}
+
+// This function returns the index of the pane at the given screen position
+// and the line in the pane or -1 when the position is not in a pane.
+func (ui *UI) paneAt(x, y int) (int, int) {
+	for i, win := range ui.wins {
+		if x >= win.x && x < win.x+win.w && y >= win.y && y < win.y+win.h {
+			return i, y - win.y
+		}
+	}
+	return -1, 0
+}
+
+// Maximum interval between two clicks at the same position to be counted as
+// a double click.
+const gDoubleClick = 500 * time.Millisecond
+
+// This function reports whether a click at the given position is the second
+// click of a double click and records it otherwise.
+func (ui *UI) doubleClick(x, y int) bool {
+	now := time.Now()
+	if x == ui.clickX && y == ui.clickY && now.Sub(ui.clicked) < gDoubleClick {
+		ui.clicked = time.Time{}
+		return true
+	}
+	ui.clickX, ui.clickY, ui.clicked = x, y, now
+	return false
+}
+
+func (ui *UI) draw(nav *Nav) {
+	fg, bg := termbox.ColorDefault, termbox.ColorDefault
+
+	screenClear(fg, bg)
+
+	// images are erased before and drawn after the cells are flushed
+	ui.image, ui.seq = nil, ""
+	defer ui.showImage()
+	defer screenFlush()
+	defer ui.hideImage()
+
+	defer ui.announce(nav)
+
+	dir := nav.currDir()
+
+	path := escapeName(pwdPath(dir.path))
+
+	colors := getColors()
+
+	// offsets are counted in cells for names with multibyte characters
+	user := envUser + "@" + envHost
+	off := printWidth(0, user)
+	ui.pwdwin.print(0, 0, colors.ui("user").fg, bg, user)
+	ui.pwdwin.print(off, 0, fg, bg, ":")
+	off++
+	ui.pwdwin.print(off, 0, colors.ui("path").fg, bg, path)
+	off += printWidth(off, path)
+
+	var ind string
+	if dir.filter != "" {
+		ind += fmt.Sprintf(" [filter: %s]", dir.filter)
+	}
+	if dir.ftype != "" {
+		ind += fmt.Sprintf(" [type: %s]", dir.ftype)
+	}
+	if nav.search != "" {
+		ind += fmt.Sprintf(" [search: %s]", nav.search)
+	}
+	ui.pwdwin.print(off, 0, colors.ui("ind").fg, bg, ind)
+
+	x := ui.pwdwin.w
+
+	if gLevel > 1 {
+		lvl := fmt.Sprintf("[%d]", gLevel)
+		x -= len(lvl)
+		ui.pwdwin.print(x, 0, termbox.AttrBold|colors.ui("ind").fg, bg, lvl)
+	}
+
+	// tabs are numbered from 1 with the active tab highlighted
+	if ui.tabs != nil && len(ui.tabs.navs) > 1 {
+		for i := len(ui.tabs.navs) - 1; i >= 0; i-- {
+			s := fmt.Sprintf(" %d ", i+1)
+			x -= len(s)
+			attr := termbox.ColorDefault
+			if i == ui.tabs.ind {
+				attr = termbox.AttrReverse
+			}
+			ui.pwdwin.print(x, 0, attr, bg, s)
+		}
+	}
+
+	preview, woff, doff, length := ui.panes(nav)
 	for i := 0; i < length; i++ {
 		ui.wins[woff+i].printd(nav.dirs[doff+i], nav.marks)
 	}
 
-	defer ui.msgwin.print(0, 0, fg, bg, ui.message)
+	// terminal cursor follows the current file for screen readers
+	if gOpts.screenreader && length > 0 {
+		win := ui.wins[woff+length-1]
+		screenSetCursor(win.x+1, win.y+dir.pos)
+	}
+
+	// status of the current file is shown when there is no message
+	msg := ui.message
+	if msg == "" {
+		msg = fileStatus(nav)
+	}
+	defer ui.msgwin.print(0, 0, fg, bg, msg)
+
+	ui.drawIndicators(nav)
 
-	if gOpts.preview {
+	// cleaner is only run when the file shown by a previewer changes
+	prev := ui.prevPath
+	ui.prevPath = ""
+	defer ui.clean(prev)
+
+	if preview {
 		if len(dir.fi) == 0 {
 			return
 		}
@@ -305,7 +979,14 @@ func (ui *UI) draw(nav *Nav) {
 		preview := ui.wins[len(ui.wins)-1]
 		path := nav.currPath()
 
-		f, err := os.Stat(path)
+		if path != ui.offPath {
+			ui.prevOff = 0
+			ui.offPath = path
+		}
+
+		prefetchPreviews(dir, nav.height, preview.w, preview.h)
+
+		f, err := statPath(path)
 		if err != nil {
 			msg := fmt.Sprintf("getting file information: %s", err)
 			ui.message = msg
@@ -313,19 +994,62 @@ func (ui *UI) draw(nav *Nav) {
 			return
 		}
 
-		if f.IsDir() {
-			dir := newDir(path)
-			dir.load(nav.inds[path], nav.poss[path], nav.height, nav.names[path])
-			preview.printd(dir, nav.marks)
+		// archives are shown as directories unless there is a previewer
+		if f.IsDir() || isBrowsable(path, f) && previewCmd(path) == "" {
+			preview.printd(nav.loadDir(path), nav.marks)
 		} else if f.Mode().IsRegular() {
+			if path, err = extractPath(path); err != nil {
+				msg := fmt.Sprintf("extracting file: %s", err)
+				ui.message = msg
+				log.Print(msg)
+				return
+			}
+
+			if s := previewCmd(path); s != "" {
+				ui.prevPath = path
+				out, ok := asyncPreview(s, path, f, preview.w, preview.h)
+				if !ok {
+					preview.print(0, 0, termbox.AttrBold, bg, "loading...")
+					return
+				}
+				if err := preview.printr(bytes.NewReader(out), ui.prevOff); err != nil {
+					ui.message = err.Error()
+					log.Print(err)
+				}
+				return
+			}
+
+			// images are shown when there is no previewer for the file and
+			// they are previewed as text when they can not be shown
+			if m := imageMethod(); m != "" && isImage(path) {
+				img := Image{m, path, preview.x, preview.y, preview.w, preview.h}
+				if img != ui.failed {
+					seq, ok, err := asyncImage(img)
+					if !ok {
+						preview.print(0, 0, termbox.AttrBold, bg, "loading...")
+						return
+					}
+					if err == nil {
+						ui.image, ui.seq = &img, seq
+						return
+					}
+					msg := fmt.Sprintf("loading image: %s", err)
+					ui.message = msg
+					log.Print(msg)
+					ui.failed = img
+				}
+			}
+
 			file, err := os.Open(path)
 			if err != nil {
 				msg := fmt.Sprintf("opening file: %s", err)
 				ui.message = msg
 				log.Print(msg)
+				return
 			}
+			defer file.Close()
 
-			if err := preview.printr(file); err != nil {
+			if err := preview.printr(file, ui.prevOff); err != nil {
 				ui.message = err.Error()
 				log.Print(err)
 			}
@@ -346,46 +1070,160 @@ func findBinds(keys map[string]Expr, prefix string) (binds map[string]Expr, ok b
 	return
 }
 
+// Number of times each key binding is used. It is used to suggest frequently
+// used bindings first when an unknown mapping is typed.
+var gBindUses = make(map[string]int)
+
+// Maximum number of bindings suggested for an unknown mapping.
+const gMaxSuggestions = 3
+
+// This function returns the bindings closest to the given unknown key
+// sequence. Bindings within one edit of the sequence or starting with the
+// sequence without its last key are suggested. Closer bindings come first and
+// frequently used ones are preferred among equally close bindings.
+func suggestBinds(keys map[string]Expr, s string) []string {
+	acc := []rune(s)
+
+	prefix := string(acc[:max(len(acc)-1, 0)])
+
+	dists := make(map[string]int)
+	for key := range keys {
+		d := editDistance(acc, []rune(key))
+		if d > 1 {
+			if prefix == "" || !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			d = 2
+		}
+		dists[key] = d
+	}
+
+	var sugs []string
+	for key := range dists {
+		sugs = append(sugs, key)
+	}
+
+	sort.Sort(bySuggestion{sugs, dists})
+
+	if len(sugs) > gMaxSuggestions {
+		sugs = sugs[:gMaxSuggestions]
+	}
+
+	return sugs
+}
+
+type bySuggestion struct {
+	keys  []string
+	dists map[string]int
+}
+
+func (a bySuggestion) Len() int      { return len(a.keys) }
+func (a bySuggestion) Swap(i, j int) { a.keys[i], a.keys[j] = a.keys[j], a.keys[i] }
+
+func (a bySuggestion) Less(i, j int) bool {
+	ki, kj := a.keys[i], a.keys[j]
+	if a.dists[ki] != a.dists[kj] {
+		return a.dists[ki] < a.dists[kj]
+	}
+	if gBindUses[ki] != gBindUses[kj] {
+		return gBindUses[ki] > gBindUses[kj]
+	}
+	return ki < kj
+}
+
+// This function returns the number of insertions, deletions and substitutions
+// needed to change one sequence to the other.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(min(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+// This function splits the given key sequence into keys in the notation used
+// in key bindings (e.g. 'g<space>' to 'g' and '<space>').
+func splitKeys(s string) []string {
+	var keys []string
+
+	for s != "" {
+		if s[0] == '<' {
+			if i := strings.IndexByte(s, '>'); i > 1 && !strings.ContainsAny(s[1:i], "< ") {
+				keys = append(keys, s[:i+1])
+				s = s[i+1:]
+				continue
+			}
+		}
+		r, n := utf8.DecodeRuneInString(s)
+		keys = append(keys, string(r))
+		s = s[n:]
+	}
+
+	return keys
+}
+
 func (ui *UI) getExpr() Expr {
 	r := &CallExpr{"redraw", nil}
 
+	defer func() { ui.pending = "" }()
+
 	var acc []rune
+	var menu []string
+	var count int
 
 	for {
-		switch ev := termbox.PollEvent(); ev.Type {
+		switch ev := screenPollEvent(); ev.Type {
 		case termbox.EventKey:
-			if ev.Ch != 0 {
+			if ev.Ch != 0 && ev.Mod&termbox.ModAlt == 0 {
+				// digits pick an entry from the menu unless they continue a mapping
+				if n := int(ev.Ch - '1'); n >= 0 && n < min(len(menu), 9) {
+					if binds, _ := findBinds(gOpts.keys, string(acc)+string(ev.Ch)); len(binds) == 0 {
+						gBindUses[menu[n]]++
+						return countExpr(gOpts.keys[menu[n]], count)
+					}
+				}
+				// digits before keys are read as a count unless they start a
+				// mapping and a leading zero is not a count as in vim
+				if len(acc) == 0 && isCountDigit(ev.Ch, count) {
+					if binds, _ := findBinds(gOpts.keys, string(ev.Ch)); len(binds) == 0 {
+						count = min(count*10+int(ev.Ch-'0'), gMaxCount)
+						ui.drawPending(count, acc)
+						continue
+					}
+				}
 				acc = append(acc, ev.Ch)
 			} else {
-				// TODO: rest of the keys
-				switch ev.Key {
-				case termbox.KeySpace:
-					acc = append(acc, '<', 's', 'p', 'a', 'c', 'e', '>')
-				case termbox.KeyEnter:
-					acc = append(acc, '<', 'c', 'r', '>')
-				case termbox.KeyBackspace:
-					acc = append(acc, '<', 'b', 's', '>')
-				case termbox.KeyBackspace2:
-					acc = append(acc, '<', 'b', 's', '2', '>')
-				case termbox.KeyTab:
-					acc = append(acc, '<', 't', 'a', 'b', '>')
-				case termbox.KeyArrowUp:
-					acc = append(acc, '<', 'u', 'p', '>')
-				case termbox.KeyArrowDown:
-					acc = append(acc, '<', 'd', 'o', 'w', 'n', '>')
-				case termbox.KeyArrowLeft:
-					acc = append(acc, '<', 'l', 'e', 'f', 't', '>')
-				case termbox.KeyArrowRight:
-					acc = append(acc, '<', 'r', 'i', 'g', 'h', 't', '>')
-				case termbox.KeyCtrlL:
-					acc = append(acc, '<', 'c', '-', 'l', '>')
-				case termbox.KeyEsc:
+				switch key := keyString(ev); key {
+				case "<esc>":
+					// escape cancels pending keys and it is only looked
+					// up in bindings when there are none
+					if e, ok := gOpts.keys["<esc>"]; ok && len(acc) == 0 {
+						return e
+					}
 					acc = nil
 					return r
-				default:
+				case "":
 					ui.message = fmt.Sprintf("unhandled key")
+					ui.bell()
 					acc = nil
 					return r
+				default:
+					acc = append(acc, []rune(key)...)
 				}
 			}
 
@@ -394,74 +1232,372 @@ func (ui *UI) getExpr() Expr {
 			switch len(binds) {
 			case 0:
 				ui.message = fmt.Sprintf("unknown mapping: %s", string(acc))
+				if keys := suggestBinds(gOpts.keys, string(acc)); len(keys) != 0 {
+					ui.message += fmt.Sprintf(" (did you mean %s?)", strings.Join(keys, ", "))
+				}
+				ui.bell()
 				acc = nil
 				return r
 			case 1:
 				if ok {
-					return gOpts.keys[string(acc)]
+					gBindUses[string(acc)]++
+					return countExpr(gOpts.keys[string(acc)], count)
 				}
-				ui.listBinds(binds)
+				ui.drawPending(count, acc)
+				menu = ui.listBinds(binds)
 			default:
 				if ok {
 					// TODO: use a delay
-					return gOpts.keys[string(acc)]
+					gBindUses[string(acc)]++
+					return countExpr(gOpts.keys[string(acc)], count)
 				}
-				ui.listBinds(binds)
+				ui.drawPending(count, acc)
+				menu = ui.listBinds(binds)
 			}
 		case termbox.EventResize:
 			return r
+		case termbox.EventInterrupt:
+			return nil
+		case termbox.EventMouse:
+			if gOpts.mouse {
+				return &MouseExpr{ev.Key, ev.MouseX, ev.MouseY}
+			}
 		default:
 			// TODO: handle other events
 		}
 	}
 }
 
+// Counts typed before keys are limited to avoid overflows.
+const gMaxCount = 10000
+
+func isCountDigit(ch rune, count int) bool {
+	return ch >= '1' && ch <= '9' || ch == '0' && count != 0
+}
+
+// This function returns the command bound to the keys typed after the given
+// count. Movement commands are given the count as an argument (e.g. '5j' runs
+// 'down 5') and other commands are repeated by the count.
+func countExpr(e Expr, count int) Expr {
+	if count == 0 {
+		return e
+	}
+
+	if c, ok := e.(*CallExpr); ok && len(c.args) == 0 {
+		switch c.name {
+		case "up", "down", "top", "bot":
+			return &CallExpr{c.name, []string{strconv.Itoa(count)}}
+		}
+	}
+
+	exprs := make([]Expr, count)
+	for i := range exprs {
+		exprs[i] = e
+	}
+
+	return &ListExpr{exprs}
+}
+
+// This function draws the count and the keys typed so far for a binding in the
+// ruler while the rest of the keys are read.
+func (ui *UI) drawPending(count int, acc []rune) {
+	ui.pending = string(acc)
+	if count != 0 {
+		ui.pending = strconv.Itoa(count) + ui.pending
+	}
+
+	if ui.tabs != nil {
+		ui.drawIndicators(ui.tabs.curr())
+		screenFlush()
+	}
+}
+
+// This function returns the indicator of the input mode for the given prompt
+// shown at the right of the message line while reading input.
+func promptMode(pref string) string {
+	switch pref {
+	case ":":
+		return "[command]"
+	case "$", "%", "!", "&":
+		return "[shell]"
+	case "/", "?":
+		return "[search]"
+	case "filter: ":
+		return "[filter]"
+	case "rename: ", "rename!: ":
+		return "[rename]"
+	}
+	if strings.HasPrefix(pref, "(reverse-i-search)") {
+		return "[history]"
+	}
+	return "[input]"
+}
+
 func (ui *UI) prompt(pref string) string {
+	return ui.promptText(pref, "", 0, 0, 0, nil)
+}
+
+// This function draws the prompt with the given input. Runes between 'sbeg'
+// and 'send' are highlighted as the selection. When the check function is
+// given and returns an error, input is drawn in red with the error message at
+// the right end of the line.
+func (ui *UI) drawPrompt(pref string, acc []rune, cur, sbeg, send int, check func(string) error) {
 	fg, bg := termbox.ColorDefault, termbox.ColorDefault
 
 	win := ui.msgwin
 
-	win.printl(0, 0, fg, bg, pref)
-	termbox.SetCursor(win.x+len(pref), win.y)
-	defer termbox.HideCursor()
-	termbox.Flush()
+	// checked first since it may redraw the screen (e.g. incremental search)
+	var msg string
+	if check != nil {
+		if err := check(string(acc)); err != nil {
+			fg = getColors().ui("err").fg
+			msg = err.Error()
+		}
+	}
+
+	win.printl(0, 0, termbox.ColorDefault, bg, pref)
 
-	var acc []rune
+	if msg != "" {
+		win.print(max(len(pref)+len(acc)+1, win.w-len(msg)), 0, fg, bg, msg)
+	} else if mode := promptMode(pref); len(pref)+len(acc)+len(mode) < win.w {
+		win.print(win.w-len(mode), 0, termbox.AttrBold, bg, mode)
+	}
+
+	win.print(len(pref), 0, fg, bg, string(acc[:sbeg]))
+	win.print(len(pref)+sbeg, 0, fg|termbox.AttrReverse, bg, string(acc[sbeg:send]))
+	win.print(len(pref)+send, 0, fg, bg, string(acc[send:]))
+	screenSetCursor(win.x+len(pref)+cur, win.y)
+	screenFlush()
+}
+
+// This function reads a line starting with the given initial text. The cursor
+// is placed at 'cur' and text between 'sbeg' and 'send' is selected so that
+// typing replaces it and backspace deletes it. Selection is dropped as soon
+// as any other key is pressed. Nothing is selected when 'sbeg' is equal to
+// 'send'. Offsets are given in runes. The check function is used to validate
+// the input while typing (see 'drawPrompt'). Command and shell prompts keep a
+// history where up and down keys cycle through entries and ctrl-r searches
+// backwards for entries containing the typed pattern.
+func (ui *UI) promptText(pref, text string, cur, sbeg, send int, check func(string) error) string {
+	fg, bg := termbox.ColorDefault, termbox.ColorDefault
+
+	win := ui.msgwin
+
+	acc := []rune(text)
+
+	cur = max(0, min(cur, len(acc)))
+	sbeg = max(0, min(sbeg, len(acc)))
+	send = max(sbeg, min(send, len(acc)))
+
+	// history entries where 'hind' is the entry shown or the length when the
+	// input is not from the history, in which case it is kept in 'saved'
+	var hist []string
+	if isHistoryPrefix(pref) && gOpts.history != 0 {
+		h, err := loadHistory()
+		if err != nil {
+			log.Printf("loading history: %s", err)
+		}
+		hist = prefixHistory(h, pref)
+	}
+	hind := len(hist)
+	saved := acc
+
+	// pattern of the reverse history search while searching
+	var query []rune
+	searching := false
+
+	draw := func() {
+		if searching {
+			ui.drawPrompt(fmt.Sprintf("(reverse-i-search)'%s': ", string(query)), acc, cur, 0, 0, nil)
+			return
+		}
+		ui.drawPrompt(pref, acc, cur, sbeg, send, check)
+	}
+
+	draw()
+	defer screenHideCursor()
+
+	// completion candidates shown in the menu
+	var cands []string
+	var menu *List
+	ind := -1
 
 	for {
-		switch ev := termbox.PollEvent(); ev.Type {
+		switch ev := screenPollEvent(); ev.Type {
 		case termbox.EventKey:
-			if ev.Ch != 0 {
-				acc = append(acc, ev.Ch)
+			// alt keys are not used in the prompt
+			if ev.Mod&termbox.ModAlt != 0 {
+				continue
+			}
+
+			key := keyString(ev)
+
+			if searching {
+				switch {
+				case ev.Ch != 0 || key == "<space>":
+					if ev.Ch != 0 {
+						query = append(query, ev.Ch)
+					} else {
+						query = append(query, ' ')
+					}
+					// current entry is kept as long as it still matches
+					if i := searchHistory(hist, string(query), hind+1); i >= 0 {
+						hind = i
+						acc = []rune(hist[i])
+					}
+				case key == "<bs>" || key == "<bs2>":
+					if len(query) > 0 {
+						query = query[:len(query)-1]
+					}
+					if i := searchHistory(hist, string(query), len(hist)); i >= 0 {
+						hind = i
+						acc = []rune(hist[i])
+					}
+				case key == "<c-r>":
+					if i := searchHistory(hist, string(query), hind); i >= 0 {
+						hind = i
+						acc = []rune(hist[i])
+					}
+				case key == "<esc>":
+					searching = false
+				default:
+					// other keys end the search and are handled as usual
+					searching = false
+				}
+				cur = len(acc)
+				if searching || key == "<esc>" {
+					draw()
+					continue
+				}
+			}
+
+			// digits pick a candidate unless they continue one of the
+			// candidates (e.g. 'file2') and other keys except tab close the menu
+			var pick string
+			if cands != nil && key != "<tab>" {
+				if i := menu.numbered(ev.Ch, ui.wins[0].h); i >= 0 && !continuesWord(acc, ev.Ch, cands) {
+					pick = cands[i]
+				}
+				cands = nil
+				ui.clearMenu()
+			}
+
+			// typing replaces the selection
+			erase := key == "<bs>" || key == "<bs2>" || key == "<delete>"
+			if sbeg != send && key == "<c-u>" {
+				cur = send
+			} else if sbeg != send && (ev.Ch != 0 || key == "<space>" || erase) {
+				acc = append(acc[:sbeg], acc[send:]...)
+				cur = sbeg
+				if erase {
+					sbeg, send = 0, 0
+					draw()
+					continue
+				}
+			}
+			sbeg, send = 0, 0
+
+			if pick != "" {
+				acc = replaceWord(acc, pick+" ")
+				cur = len(acc)
+			} else if ev.Ch != 0 {
+				acc = append(acc[:cur], append([]rune{ev.Ch}, acc[cur:]...)...)
+				cur++
 			} else {
-				// TODO: rest of the keys
-				switch ev.Key {
-				case termbox.KeySpace:
-					acc = append(acc, ' ')
-				case termbox.KeyBackspace2:
-					if len(acc) > 0 {
-						acc = acc[:len(acc)-1]
+				switch key {
+				case "<space>":
+					acc = append(acc[:cur], append([]rune{' '}, acc[cur:]...)...)
+					cur++
+				case "<bs>", "<bs2>":
+					if cur > 0 {
+						acc = append(acc[:cur-1], acc[cur:]...)
+						cur--
+					}
+				case "<delete>":
+					if cur < len(acc) {
+						acc = append(acc[:cur], acc[cur+1:]...)
+					}
+				case "<left>":
+					cur = max(cur-1, 0)
+				case "<right>":
+					cur = min(cur+1, len(acc))
+				case "<c-a>", "<home>":
+					cur = 0
+				case "<c-e>", "<end>":
+					cur = len(acc)
+				case "<c-w>":
+					acc, cur = deleteWord(acc, cur)
+				case "<c-u>":
+					acc = acc[cur:]
+					cur = 0
+				case "<up>":
+					if hind > 0 {
+						if hind == len(hist) {
+							saved = acc
+						}
+						hind--
+						acc = []rune(hist[hind])
+						cur = len(acc)
 					}
-				case termbox.KeyEnter:
+				case "<down>":
+					if hind < len(hist) {
+						hind++
+						if hind == len(hist) {
+							acc = saved
+						} else {
+							acc = []rune(hist[hind])
+						}
+						cur = len(acc)
+					}
+				case "<c-r>":
+					if hist != nil {
+						searching = true
+						query = nil
+						if hind == len(hist) {
+							saved = acc
+						}
+					}
+				case "<cr>":
 					win.printl(0, 0, fg, bg, "")
-					termbox.SetCursor(win.x, win.y)
-					termbox.Flush()
+					screenSetCursor(win.x, win.y)
+					screenFlush()
+					if isHistoryPrefix(pref) {
+						if err := addHistory(pref, string(acc)); err != nil {
+							log.Printf("saving history: %s", err)
+						}
+					}
 					return string(acc)
-				case termbox.KeyTab:
-					if pref == ":" {
-						acc = compCmd(acc)
+				case "<tab>":
+					if cands != nil {
+						ind = (ind + 1) % len(cands)
+						acc = replaceWord(acc, cands[ind])
+						cur = len(acc)
+						menu.ind = ind
+						ui.drawList(menu)
+						break
+					}
+					switch pref {
+					case ":":
+						acc, cands = compCmd(acc)
+					case "$", "%", "!", "&":
+						acc, cands = compShell(acc)
+					case "copyto: ", "moveto: ":
+						acc, cands = compDir(acc)
+					}
+					cur = len(acc)
+					if len(cands) > 1 {
+						ind = -1
+						menu = newList("completions", cands)
+						ui.drawList(menu)
 					} else {
-						acc = compShell(acc)
+						cands = nil
 					}
-				case termbox.KeyEsc:
+				case "<esc>":
 					return ""
 				}
 			}
 
-			win.printl(0, 0, fg, bg, pref)
-			win.print(len(pref), 0, fg, bg, string(acc))
-			termbox.SetCursor(win.x+len(pref)+len(acc), win.y)
-			termbox.Flush()
+			draw()
 		default:
 			// TODO: handle other events
 		}
@@ -469,31 +1605,88 @@ func (ui *UI) prompt(pref string) string {
 }
 
 func (ui *UI) pause() {
-	termbox.Close()
+	ui.image = nil
+	ui.hideImage()
+	screenClose()
 }
 
 func (ui *UI) resume() {
-	if err := termbox.Init(); err != nil {
+	if err := screenInit(); err != nil {
 		log.Fatalf("initializing termbox: %s", err)
 	}
+	screenSetInput(gOpts.mouse, gOpts.altkeys)
 }
 
 func (ui *UI) sync() {
-	if err := termbox.Sync(); err != nil {
+	if err := screenSync(); err != nil {
 		log.Printf("syncing termbox: %s", err)
 	}
-	termbox.SetCursor(0, 0)
-	termbox.HideCursor()
+
+	// images are cleared with the screen
+	ui.shown = nil
+	screenSetCursor(0, 0)
+	screenHideCursor()
+}
+
+// This function deletes the word before the cursor along with the spaces
+// following it as in shells (i.e. ctrl-w). It returns the new input and the
+// new cursor position.
+func deleteWord(acc []rune, cur int) ([]rune, int) {
+	beg := cur
+	for beg > 0 && unicode.IsSpace(acc[beg-1]) {
+		beg--
+	}
+	for beg > 0 && !unicode.IsSpace(acc[beg-1]) {
+		beg--
+	}
+	return append(acc[:beg:beg], acc[cur:]...), beg
+}
+
+// This function returns the index where the last word in the given input
+// starts. Words are separated with spaces as in completion.
+func lastWord(acc []rune) int {
+	i := len(acc) - 1
+	for i >= 0 && acc[i] != ' ' {
+		i--
+	}
+	return i + 1
+}
+
+// This function reports whether typing the given character after the last
+// word in the given input still matches one of the given candidates.
+func continuesWord(acc []rune, ch rune, cands []string) bool {
+	word := string(acc[lastWord(acc):]) + string(ch)
+	for _, c := range cands {
+		if strings.HasPrefix(c, word) {
+			return true
+		}
+	}
+	return false
 }
 
-func (ui *UI) listBinds(binds map[string]Expr) {
+// This function replaces the last word in the given input with the given word.
+// It is used to fill in the input with completion candidates.
+func replaceWord(acc []rune, word string) []rune {
+	ret := append([]rune{}, acc[:lastWord(acc)]...)
+	return append(ret, []rune(word)...)
+}
+
+// This function lists the given bindings in the menu sorted by their keys and
+// returns the keys in the same order as they are numbered in the menu.
+func (ui *UI) listBinds(binds map[string]Expr) []string {
+	var keys []string
+	for key := range binds {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
 	t := new(tabwriter.Writer)
 	b := new(bytes.Buffer)
 
 	t.Init(b, 0, 8, 0, '\t', 0)
 	fmt.Fprintln(t, "keys\tcommand")
-	for key, expr := range binds {
-		fmt.Fprintf(t, "%s\t%v\n", key, expr)
+	for _, key := range keys {
+		fmt.Fprintf(t, "%s\t%v\n", key, binds[key])
 	}
 	t.Flush()
 
@@ -501,13 +1694,55 @@ func (ui *UI) listBinds(binds map[string]Expr) {
 
 	lines = lines[:len(lines)-1]
 
-	ui.menuwin.h = len(lines) - 1
-	ui.menuwin.y = ui.wins[0].h - ui.menuwin.h
+	ui.drawList(newList(lines[0], lines[1:]))
+
+	return keys
+}
+
+// This function lists the given marks in the menu and reads the key of a mark.
+// It returns an empty string when the reading is canceled with escape.
+func (ui *UI) readMark(marks map[string]string) string {
+	defer ui.clearMenu()
 
-	ui.menuwin.printl(0, 0, termbox.AttrBold, termbox.AttrBold, lines[0])
-	for i, line := range lines[1:] {
-		ui.menuwin.printl(0, i+1, termbox.ColorDefault, termbox.ColorDefault, line)
+	var keys []string
+	for key := range marks {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
+
+	t := new(tabwriter.Writer)
+	b := new(bytes.Buffer)
+
+	t.Init(b, 0, 8, 0, '\t', 0)
+	fmt.Fprintln(t, "mark\tdirectory")
+	for _, key := range keys {
+		fmt.Fprintf(t, "%s\t%s\n", key, escapeName(marks[key]))
+	}
+	t.Flush()
 
-	termbox.Flush()
+	lines := strings.Split(b.String(), "\n")
+
+	lines = lines[:len(lines)-1]
+
+	ui.drawList(newList(lines[0], lines[1:]))
+
+	for {
+		ev := screenPollEvent()
+		if ev.Type != termbox.EventKey {
+			continue
+		}
+		if ev.Ch != 0 {
+			return string(ev.Ch)
+		}
+		if ev.Key == termbox.KeyEsc {
+			return ""
+		}
+	}
+}
+
+func (ui *UI) clearMenu() {
+	for i := 0; i <= ui.menuwin.h; i++ {
+		ui.menuwin.printl(0, i, termbox.ColorDefault, termbox.ColorDefault, "")
+	}
+	screenFlush()
 }
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestGetWidths(t *testing.T) {
+	defer func(rats []int) { gOpts.ratios = rats }(gOpts.ratios)
+
+	tests := []struct {
+		rats   []int
+		wtot   int
+		widths []int
+	}{
+		{[]int{1, 2, 3}, 60, []int{10, 20, 30}},
+		{[]int{1, 2, 3}, 100, []int{16, 33, 51}},
+		{[]int{1, 2, 3}, 20, []int{8, 12}},
+		{[]int{1, 2, 3}, 8, []int{8}},
+		{[]int{1, 2, 3}, 3, []int{3}},
+		{[]int{1}, 80, []int{80}},
+	}
+
+	for _, test := range tests {
+		gOpts.ratios = test.rats
+		if widths := getWidths(test.wtot); !reflect.DeepEqual(widths, test.widths) {
+			t.Errorf("at input '%v' with width '%d' expected '%v' but got '%v'", test.rats, test.wtot, test.widths, widths)
+		}
+	}
+}
+
+func TestAppendInfo(t *testing.T) {
+	tests := []struct {
+		s    string
+		info string
+		out  string
+	}{
+		{" foo      ", "1.0K", " foo  1.0K"},
+		{" foobarbaz", "1.0K", " foob 1.0K"},
+		{" foo", "1.0K", " foo"},
+		{"", "1.0K", ""},
+	}
+
+	for _, test := range tests {
+		if out := string(appendInfo([]rune(test.s), test.info)); out != test.out {
+			t.Errorf("at input '%s' with info '%s' expected '%s' but got '%s'", test.s, test.info, test.out, out)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		d    int
+	}{
+		{"", "", 0},
+		{"", "gg", 2},
+		{"gg", "gg", 0},
+		{"gq", "gg", 1},
+		{"g", "gg", 1},
+		{"ab", "ba", 2},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, test := range tests {
+		if d := editDistance([]rune(test.a), []rune(test.b)); d != test.d {
+			t.Errorf("at input '%s' and '%s' expected '%d' but got '%d'", test.a, test.b, test.d, d)
+		}
+	}
+}
+
+func TestSuggestBinds(t *testing.T) {
+	keys := map[string]Expr{
+		"j":  &CallExpr{"down", nil},
+		"k":  &CallExpr{"up", nil},
+		"gg": &CallExpr{"top", nil},
+		"gh": &CallExpr{"cd", []string{"~"}},
+		"yy": &CallExpr{"copy", nil},
+	}
+
+	tests := []struct {
+		s    string
+		sugs []string
+	}{
+		{"x", []string{"j", "k"}},
+		{"gq", []string{"gg", "gh"}},
+		{"gqq", nil},
+		{"zzz", nil},
+	}
+
+	for _, test := range tests {
+		if sugs := suggestBinds(keys, test.s); !reflect.DeepEqual(sugs, test.sugs) {
+			t.Errorf("at input '%s' expected '%v' but got '%v'", test.s, test.sugs, sugs)
+		}
+	}
+}
+
+func TestSplitKeys(t *testing.T) {
+	tests := []struct {
+		s    string
+		keys []string
+	}{
+		{"", nil},
+		{"jjj", []string{"j", "j", "j"}},
+		{"g<space>", []string{"g", "<space>"}},
+		{"<c-l>j", []string{"<c-l>", "j"}},
+		{"<", []string{"<"}},
+		{"<>", []string{"<", ">"}},
+		{"<a b>", []string{"<", "a", " ", "b", ">"}},
+	}
+
+	for _, test := range tests {
+		if keys := splitKeys(test.s); !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("at input '%s' expected '%v' but got '%v'", test.s, test.keys, keys)
+		}
+	}
+}
+
+func TestDeleteWord(t *testing.T) {
+	tests := []struct {
+		s   string
+		cur int
+		exp string
+	}{
+		{"", 0, ""},
+		{"foo", 3, ""},
+		{"foo bar", 7, "foo "},
+		{"foo bar  ", 9, "foo "},
+		{"foo bar", 5, "foo ar"},
+		{"foo bar", 4, "bar"},
+		{"foo bar", 0, "foo bar"},
+	}
+
+	for _, test := range tests {
+		if acc, _ := deleteWord([]rune(test.s), test.cur); string(acc) != test.exp {
+			t.Errorf("at input '%s' expected '%s' but got '%s'", test.s, test.exp, string(acc))
+		}
+	}
+}
+
+func TestEscapeControls(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp string
+	}{
+		{"", ""},
+		{"foo", "foo"},
+		{"foo\tbar", "foo\tbar"},
+		{"foo\r", "foo^M"},
+		{"\x00\x1b\x7f", "^@^[^?"},
+		{"foo\u0085bar", "foo?bar"},
+	}
+
+	for _, test := range tests {
+		if s := escapeControls(test.s); s != test.exp {
+			t.Errorf("at input '%q' expected '%s' but got '%s'", test.s, test.exp, s)
+		}
+	}
+}
+
+func TestExpandTabs(t *testing.T) {
+	tests := []struct {
+		s   string
+		col int
+		exp string
+	}{
+		{"foo", 0, "foo"},
+		{"\tfoo", 0, "        foo"},
+		{"foo\tbar", 0, "foo     bar"},
+		{"foo\tbar", 3, "foo  bar"},
+		{"\t\t", 7, "         "},
+	}
+
+	for _, test := range tests {
+		if s := expandTabs(test.s, test.col); s != test.exp {
+			t.Errorf("at input '%q' expected '%s' but got '%s'", test.s, test.exp, s)
+		}
+	}
+}
+
+func BenchmarkPrintd(b *testing.B) {
+	dir := genDir(b, 1000)
+	defer os.RemoveAll(dir)
+
+	d := newDir(dir)
+	win := newWin(80, 40, 0, 0)
+	marks := map[string]bool{path.Join(dir, d.fi[1].Name()): true}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		d.ind = i % len(d.fi)
+		win.printd(d, marks)
+	}
+}
+
+func TestViewIndicator(t *testing.T) {
+	defer func(o Opts) { gOpts = o }(gOpts)
+
+	tests := []struct {
+		sortby   string
+		reverse  bool
+		dirfirst bool
+		hidden   bool
+		exp      string
+	}{
+		{"natural", false, true, false, "[natural]"},
+		{"time", true, true, true, "[time↓][h]"},
+		{"size", false, false, false, "[size][nodirfirst]"},
+		{"name", true, false, true, "[name↓][nodirfirst][h]"},
+	}
+
+	for _, test := range tests {
+		gOpts.sortby, gOpts.reverse, gOpts.dirfirst, gOpts.hidden = test.sortby, test.reverse, test.dirfirst, test.hidden
+		if got := viewIndicator(); got != test.exp {
+			t.Errorf("at input '%v' expected '%s' but got '%s'", test, test.exp, got)
+		}
+	}
+}